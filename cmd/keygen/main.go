@@ -1,12 +1,23 @@
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"math/big"
 
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/blockchain"
 	"github.com/StupidBug/fabric-zkrollup/pkg/core/crypto"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
 )
 
 func main() {
@@ -17,12 +28,68 @@ func main() {
 	signCmd := flag.Bool("sign", false, "Sign a transaction")
 	fromAddr := flag.String("from", "", "From address")
 	toAddr := flag.String("to", "", "To address")
-	value := flag.Int("value", 0, "Transfer value")
+	value := flag.String("value", "0", "Transfer value, a decimal integer")
 	nonce := flag.Int("nonce", 0, "Transaction nonce")
+	fee := flag.String("fee", "0", "Fee burned on top of the value, a decimal integer")
 	privKey := flag.String("privkey", "", "Private key for signing")
 
+	// Define flags for sequencer keys
+	genSequencerCmd := flag.String("gensequencer", "", "Generate a sequencer key and write it to this PEM file")
+	rotateCmd := flag.Bool("rotate", false, "Sign a transaction rotating the sequencer key")
+	sequencerKey := flag.String("seqkey", "", "PEM file with the current sequencer key")
+	newSequencerKey := flag.String("newkey", "", "PEM file with the new sequencer key")
+
 	flag.Parse()
 
+	if *genSequencerCmd != "" {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			log.Fatal(err)
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+		if err := ioutil.WriteFile(*genSequencerCmd, data, 0600); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Sequencer public key: %x\n", transaction.NewPublicKey(&key.PublicKey).Bytes())
+		return
+	}
+
+	if *rotateCmd {
+		if *sequencerKey == "" || *newSequencerKey == "" {
+			log.Fatal("Missing required parameters for rotation")
+		}
+		current, err := blockchain.LoadSequencerKey(*sequencerKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		next, err := blockchain.LoadSequencerKey(*newSequencerKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		tx := transaction.NewRotateSequencerTx(&next.PublicKey, uint64(*nonce))
+		if err := tx.SignTransaction(current); err != nil {
+			log.Fatal(err)
+		}
+
+		// Request body for /transaction/send
+		body, _ := json.MarshalIndent(map[string]interface{}{
+			"from":      tx.From,
+			"value":     "0",
+			"nonce":     fmt.Sprint(tx.Nonce),
+			"type":      tx.Type.String(),
+			"data":      hex.EncodeToString(tx.Data),
+			"signature": map[string]string{"r": tx.Signature.R.Text(16), "s": tx.Signature.S.Text(16)},
+			"publicKey": map[string]string{"x": current.X.Text(16), "y": current.Y.Text(16)},
+		}, "", "  ")
+		fmt.Println(string(body))
+		return
+	}
+
 	if *genKeyCmd {
 		// Generate new key pair
 		priv, pub := crypto.GenerateKeyPair()
@@ -37,12 +104,24 @@ func main() {
 			log.Fatal("Missing required parameters for signing")
 		}
 
+		amount, err := types.ParseAmount(*value)
+		if err != nil {
+			log.Fatalf("Invalid value: %v", err)
+		}
+		feeAmount, err := types.ParseAmount(*fee)
+		if err != nil {
+			log.Fatalf("Invalid fee: %v", err)
+		}
+
 		// Parse private key
 		privKeyBig := new(big.Int)
 		privKeyBig.SetString(*privKey, 16)
 
 		// Create transaction data
-		txData := fmt.Sprintf("%s%s%d%d", *fromAddr, *toAddr, *value, *nonce)
+		txData := fmt.Sprintf("%s%s%s%d", *fromAddr, *toAddr, amount, *nonce)
+		if !feeAmount.IsZero() {
+			txData += fmt.Sprintf("fee%s", feeAmount)
+		}
 
 		// Sign transaction
 		sig := crypto.Sign(txData, privKeyBig)
@@ -56,5 +135,5 @@ func main() {
 		return
 	}
 
-	fmt.Println("Please specify either -genkey or -sign")
+	fmt.Println("Please specify one of -genkey, -sign, -gensequencer or -rotate")
 }
@@ -1,25 +1,155 @@
 package main
 
 import (
-	"github.com/StupidBug/fabric-zkrollup/pkg/api/router"
-	"github.com/StupidBug/fabric-zkrollup/pkg/core/blockchain"
+	"context"
+	"errors"
+	"flag"
 	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/chaincode"
+	"github.com/StupidBug/fabric-zkrollup/pkg/config"
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/blockchain"
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/store"
+	"github.com/StupidBug/fabric-zkrollup/pkg/logging"
+	"github.com/StupidBug/fabric-zkrollup/pkg/node"
+	"github.com/StupidBug/fabric-zkrollup/pkg/zk"
 )
 
 func main() {
-	// Create blockchain instance
-	bc := blockchain.NewBlockchain()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "rollback":
+			rollback(os.Args[2:])
+			return
+		case "snapshot":
+			snapshot(os.Args[2:])
+			return
+		}
+	}
+
+	cfg := loadConfig(os.Args[1:])
+	bc, err := openBlockchain(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.Sequencer.KeyFile != "" {
+		key, err := blockchain.LoadSequencerKey(cfg.Sequencer.KeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		bc.SetSequencerKey(key)
+		if cfg.Follower.SequencerURL == "" && !key.PublicKey.Equal(bc.GetSequencerKey()) {
+			log.Printf("Sequencer key %s is not the authorized sequencer key; blocks will not be produced", cfg.Sequencer.KeyFile)
+		}
+	} else if cfg.Follower.SequencerURL == "" {
+		log.Println("No sequencer key configured, signing blocks with the insecure development key")
+	}
 
-	// Start automatic block creation
-	bc.StartAutoBlock()
+	bc.SetProofSubmitter(chaincode.NewFabricSubmitter(cfg.ChaincodeConfig()))
+	if cfg.Fabric.ForcedInclusion {
+		// The queue functions are not part of every verifier chaincode, so
+		// the feature is only turned on once the queue can be read
+		queue := chaincode.NewFabricForcedQueue(cfg.ChaincodeConfig())
+		if _, err := queue.ForcedTransactions(); err != nil {
+			log.Fatalf("Forced inclusion needs GetForcedTxs and RegisterForcedTx in the verifier chaincode: %v", err)
+		}
+		bc.SetForcedQueue(queue)
+	}
+	if err := bc.SetProductionConfig(blockchain.ProductionConfig{
+		Interval:        cfg.Block.Interval,
+		MaxTransactions: cfg.Block.MaxTransactions,
+		MaxBytes:        cfg.Block.MaxBytes,
+		MaxCost:         cfg.Block.MaxCost,
+	}); err != nil {
+		log.Fatal(err)
+	}
+	if err := bc.SetPoolConfig(cfg.TxPoolConfig()); err != nil {
+		log.Fatal(err)
+	}
+	if path := cfg.PoolJournalPath(); path != "" && cfg.Follower.SequencerURL == "" {
+		if err := bc.OpenPoolJournal(path); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	n := node.New(bc, node.Config{
+		ListenAddr:   cfg.ListenAddr,
+		SequencerURL: cfg.Follower.SequencerURL,
+		SyncInterval: cfg.Follower.SyncInterval,
+	})
 
-	// Create and setup router
-	r := router.NewRouter(bc)
-	r.Setup()
+	// Run until SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Start HTTP server
-	log.Println("Server is running on :8080")
-	if err := r.Run(":8080"); err != nil {
+	if err := n.Start(ctx); err != nil {
 		log.Fatal(err)
 	}
+	<-ctx.Done()
+	stop()
+	log.Println("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := n.Stop(shutdownCtx); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// loadConfig loads the configuration from args and the environment and sets
+// up logging, exiting if it is invalid
+func loadConfig(args []string) *config.Config {
+	cfg, err := config.Load(args, os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Route the standard logger through slog so the level and format apply
+	// to it. Components created afterwards log through the default logger.
+	level, _ := cfg.SlogLevel()
+	logger, err := logging.New(os.Stderr, cfg.LogFormat, level)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
+	return cfg
+}
+
+// chainGenesis returns the configured genesis, with a prover for the key
+// directory if one is configured
+func chainGenesis(cfg *config.Config) (blockchain.Genesis, error) {
+	genesis, err := cfg.BlockchainGenesis()
+	if err != nil {
+		return genesis, err
+	}
+	if cfg.Prover.KeyDir != "" {
+		if genesis.Prover, err = zk.NewProver(cfg.Prover.Backend, cfg.Prover.KeyDir); err != nil {
+			return genesis, err
+		}
+	}
+	return genesis, nil
+}
+
+// openBlockchain opens the chain in the configured data directory, or an
+// in-memory chain if there is none
+func openBlockchain(cfg *config.Config) (*blockchain.Blockchain, error) {
+	genesis, err := chainGenesis(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var st *store.Store
+	if cfg.DataDir != "" {
+		if st, err = store.Open(cfg.DataDir); err != nil {
+			return nil, err
+		}
+	}
+	return blockchain.NewBlockchainWithGenesis(genesis, st)
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+)
+
+// rollback implements "zkrollup rollback <height> [flags]", which rolls the
+// chain in the data directory back to height. The node must not be running;
+// use the admin API to roll back a running node, which also returns the
+// dropped transactions to its pool.
+func rollback(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: zkrollup rollback <height> [flags]")
+		os.Exit(2)
+	}
+	height, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid height %q: %v", args[0], err)
+	}
+
+	cfg := loadConfig(args[1:])
+	if cfg.DataDir == "" {
+		log.Fatal("rollback needs a data directory")
+	}
+	bc, err := openBlockchain(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	result, err := bc.RollbackTo(height)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Rolled back %d blocks, chain tip is now at height %d\n", result.Dropped, result.Height)
+}
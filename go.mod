@@ -7,6 +7,8 @@ require (
 	github.com/consensys/gnark-crypto v0.5.3
 	github.com/gin-gonic/gin v1.10.0
 	github.com/hyperledger/fabric-sdk-go v1.0.0-rc1
+	github.com/prometheus/client_golang v1.1.0
+	gopkg.in/yaml.v2 v2.3.0
 )
 
 require (
@@ -48,7 +50,6 @@ require (
 	github.com/pelletier/go-toml/v2 v2.2.3 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/prometheus/client_golang v1.1.0 // indirect
 	github.com/prometheus/client_model v0.0.0-20190812154241-14fe0d1b01d4 // indirect
 	github.com/prometheus/common v0.6.0 // indirect
 	github.com/prometheus/procfs v0.0.3 // indirect
@@ -72,6 +73,5 @@ require (
 	google.golang.org/genproto v0.0.0-20190819201941-24fa4b261c55 // indirect
 	google.golang.org/grpc v1.29.1 // indirect
 	google.golang.org/protobuf v1.36.5 // indirect
-	gopkg.in/yaml.v2 v2.3.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
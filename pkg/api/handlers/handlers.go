@@ -4,12 +4,16 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"encoding/hex"
+	"errors"
+	"log/slog"
 	"math/big"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/StupidBug/fabric-zkrollup/pkg/core/blockchain"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/block"
 	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
 
 	"github.com/gin-gonic/gin"
@@ -17,10 +21,11 @@ import (
 
 type Handler struct {
 	blockchain *blockchain.Blockchain
+	logger     *slog.Logger
 }
 
-func NewHandler(bc *blockchain.Blockchain) *Handler {
-	return &Handler{blockchain: bc}
+func NewHandler(bc *blockchain.Blockchain, logger *slog.Logger) *Handler {
+	return &Handler{blockchain: bc, logger: logger}
 }
 
 // SignatureRequest represents the signature part of a transaction request
@@ -38,28 +43,53 @@ type PublicKeyRequest struct {
 // TransactionRequest represents a transaction request
 type TransactionRequest struct {
 	From      string           `json:"from" binding:"required"`
-	To        string           `json:"to" binding:"required"`
+	To        string           `json:"to"` // Empty for governance transactions
 	Value     string           `json:"value" binding:"required"`
 	Nonce     string           `json:"nonce" binding:"required"`
 	Signature SignatureRequest `json:"signature" binding:"required"`
 	PublicKey PublicKeyRequest `json:"publicKey" binding:"required"`
+	Type      string           `json:"type"` // transfer (default) or rotate_sequencer
+	Data      string           `json:"data"` // Hex payload of governance transactions
+	Fee       string           `json:"fee"`  // Fee burned on top of the value, 0 if empty
 }
 
 // TransactionResponse represents a transaction response
 type TransactionResponse struct {
-	Hash      string `json:"hash"`
-	From      string `json:"from"`
-	To        string `json:"to"`
-	Value     string `json:"value"`
-	Nonce     uint64 `json:"nonce"`
-	Status    string `json:"status"`
-	Timestamp int64  `json:"timestamp"`
+	Hash       string `json:"hash"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Value      string `json:"value"`
+	Nonce      uint64 `json:"nonce"`
+	Status     string `json:"status"`
+	Finality   string `json:"finality,omitempty"` // Finality of the block of an included transaction
+	Timestamp  int64  `json:"timestamp"`
+	Type       string `json:"type"`
+	Data       string `json:"data,omitempty"`
+	Fee        string `json:"fee"`
+	ReplacedBy string `json:"replacedBy,omitempty"` // Hash of the replacement, set for replaced transactions
 }
 
 // BalanceResponse represents a balance response
 type BalanceResponse struct {
+	Address string  `json:"address"`
+	Balance string  `json:"balance"`
+	Height  *uint64 `json:"height,omitempty"` // Set for historical queries
+}
+
+// AccountResponse represents an account in the account list
+type AccountResponse struct {
 	Address string `json:"address"`
 	Balance string `json:"balance"`
+	Nonce   uint64 `json:"nonce"`
+}
+
+// AccountsResponse represents a page of the accounts at a height
+type AccountsResponse struct {
+	Height   uint64            `json:"height"`
+	Total    int               `json:"total"`
+	Offset   int               `json:"offset"`
+	Limit    int               `json:"limit"`
+	Accounts []AccountResponse `json:"accounts"`
 }
 
 // BlockResponse represents a block response
@@ -71,9 +101,34 @@ type BlockResponse struct {
 	StateRoot        string                `json:"stateRoot"`
 	Timestamp        int64                 `json:"timestamp"`
 	TransactionCount uint32                `json:"transactionCount"`
+	Finality         string                `json:"finality"`
+	Sequencer        string                `json:"sequencer"` // Public key of the producer
 	Transactions     []TransactionResponse `json:"transactions"`
 }
 
+// AccountTransactionResponse represents a confirmed transaction in an
+// account's history
+type AccountTransactionResponse struct {
+	TransactionResponse
+	Height uint64 `json:"height"`
+	Index  int    `json:"index"`
+}
+
+// AccountTransactionsResponse represents a page of an account's history
+type AccountTransactionsResponse struct {
+	Address      string                       `json:"address"`
+	Total        int                          `json:"total"`
+	Offset       int                          `json:"offset"`
+	Limit        int                          `json:"limit"`
+	Transactions []AccountTransactionResponse `json:"transactions"`
+}
+
+// Pagination defaults for list endpoints
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
 // SendTransaction handles transaction submission
 func (h *Handler) SendTransaction(c *gin.Context) {
 	var req TransactionRequest
@@ -83,7 +138,7 @@ func (h *Handler) SendTransaction(c *gin.Context) {
 	}
 
 	// Parse value
-	value, err := strconv.Atoi(req.Value)
+	value, err := types.ParseAmount(req.Value)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid value"})
 		return
@@ -96,6 +151,29 @@ func (h *Handler) SendTransaction(c *gin.Context) {
 		return
 	}
 
+	// Parse fee
+	var fee types.Amount
+	if req.Fee != "" {
+		if fee, err = types.ParseAmount(req.Fee); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid fee"})
+			return
+		}
+	}
+
+	// Parse type and payload
+	txType := transaction.TypeTransfer
+	if req.Type != "" {
+		if txType, err = transaction.ParseType(req.Type); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	data, err := hex.DecodeString(req.Data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data"})
+		return
+	}
+
 	// Parse signature
 	r := new(big.Int)
 	s := new(big.Int)
@@ -127,9 +205,6 @@ func (h *Handler) SendTransaction(c *gin.Context) {
 		Y:     y,
 	}
 
-	// Store public key in state
-	h.blockchain.SetPublicKey(req.From, pubKey)
-
 	// Create transaction
 	tx := transaction.Transaction{
 		From:      req.From,
@@ -142,13 +217,25 @@ func (h *Handler) SendTransaction(c *gin.Context) {
 			R: r,
 			S: s,
 		},
+		// An account without a key is bound to this one if its address
+		// is derived from it
+		PublicKey: transaction.NewPublicKey(pubKey),
+		Type:      txType,
+		Data:      data,
+		Fee:       fee,
 	}
 
 	// Compute hash
 	tx.Hash = tx.ComputeHash()
 
-	// Add to blockchain
-	if err := h.blockchain.AddTransaction(tx); err != nil {
+	// Queue for admission and wait for the result
+	ctx := c.Request.Context()
+	if err := h.blockchain.SubmitTransaction(ctx, tx, nil).Wait(ctx); err != nil {
+		var rejected *blockchain.RejectError
+		if errors.As(err, &rejected) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": rejected.Reason})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -162,6 +249,8 @@ func (h *Handler) SendTransaction(c *gin.Context) {
 		"nonce":     tx.Nonce,
 		"status":    tx.Status,
 		"timestamp": tx.Timestamp,
+		"type":      tx.Type.String(),
+		"fee":       tx.Fee,
 		"signature": gin.H{
 			"r": req.Signature.R,
 			"s": req.Signature.S,
@@ -196,14 +285,49 @@ func (h *Handler) GetTransaction(c *gin.Context) {
 		return
 	}
 
-	resp := TransactionResponse{
-		Hash:      hex.EncodeToString(tx.Hash[:]),
-		From:      tx.From,
-		To:        tx.To,
-		Value:     strconv.Itoa(tx.Value),
-		Nonce:     tx.Nonce,
-		Status:    tx.Status.String(),
-		Timestamp: tx.Timestamp,
+	resp := newTransactionResponse(tx)
+	if tx.Status == transaction.StatusReplaced {
+		if by, ok := h.blockchain.ReplacedBy(hash); ok {
+			resp.ReplacedBy = hex.EncodeToString(by[:])
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetAccountTransactions handles paginated retrieval of the confirmed
+// transactions sent or received by an address, newest first
+func (h *Handler) GetAccountTransactions(c *gin.Context) {
+	address := c.Query("address")
+	if address == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing address parameter"})
+		return
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageLimit)))
+	if err != nil || limit <= 0 || limit > maxPageLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+
+	txs, total := h.blockchain.GetAddressTransactions(address, offset, limit)
+	resp := AccountTransactionsResponse{
+		Address:      address,
+		Total:        total,
+		Offset:       offset,
+		Limit:        limit,
+		Transactions: make([]AccountTransactionResponse, 0, len(txs)),
+	}
+	for i := range txs {
+		resp.Transactions = append(resp.Transactions, AccountTransactionResponse{
+			TransactionResponse: newTransactionResponse(&txs[i].Transaction),
+			Height:              txs[i].Height,
+			Index:               txs[i].Index,
+		})
 	}
 
 	c.JSON(http.StatusOK, resp)
@@ -217,11 +341,21 @@ func (h *Handler) GetBalance(c *gin.Context) {
 		return
 	}
 
-	balance := h.blockchain.GetBalance(address)
-
-	resp := BalanceResponse{
-		Address: address,
-		Balance: strconv.Itoa(balance),
+	resp := BalanceResponse{Address: address}
+	height, historical, ok := parseHeight(c)
+	if !ok {
+		return
+	}
+	if historical {
+		balance, err := h.blockchain.GetBalanceAt(address, height)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		resp.Balance = balance.String()
+		resp.Height = &height
+	} else {
+		resp.Balance = h.blockchain.GetBalance(address).String()
 	}
 
 	c.JSON(http.StatusOK, resp)
@@ -235,13 +369,94 @@ func (h *Handler) GetNonce(c *gin.Context) {
 		return
 	}
 
+	height, historical, ok := parseHeight(c)
+	if !ok {
+		return
+	}
+	if historical {
+		// The pool only matters for the current state
+		nonce, err := h.blockchain.GetNonceAt(address, height)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"address": address,
+			"nonce":   nonce,
+			"height":  height,
+		})
+		return
+	}
+
 	nonce := h.blockchain.GetNonce(address)
+	pendingNonce := h.blockchain.GetPendingNonce(address)
 	c.JSON(http.StatusOK, gin.H{
-		"address": address,
-		"nonce":   nonce,
+		"address":      address,
+		"nonce":        nonce,
+		"pendingNonce": pendingNonce,
 	})
 }
 
+// GetAccounts handles paginated retrieval of every account, sorted by
+// address, at the latest or a given height
+func (h *Handler) GetAccounts(c *gin.Context) {
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageLimit)))
+	if err != nil || limit <= 0 || limit > maxPageLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+	height, historical, ok := parseHeight(c)
+	if !ok {
+		return
+	}
+	if !historical {
+		height = h.blockchain.GetHeight() - 1
+	}
+
+	accounts, err := h.blockchain.GetAccountsAt(height)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	resp := AccountsResponse{
+		Height:   height,
+		Total:    len(accounts),
+		Offset:   offset,
+		Limit:    limit,
+		Accounts: make([]AccountResponse, 0, limit),
+	}
+	for i := offset; i < len(accounts) && i < offset+limit; i++ {
+		resp.Accounts = append(resp.Accounts, AccountResponse{
+			Address: accounts[i].Address,
+			Balance: accounts[i].Balance.String(),
+			Nonce:   accounts[i].Nonce,
+		})
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// parseHeight parses the optional height query parameter. It reports whether
+// the parameter was given, and writes an error response and returns false in
+// ok if it is invalid.
+func parseHeight(c *gin.Context) (height uint64, historical bool, ok bool) {
+	value, given := c.GetQuery("height")
+	if !given {
+		return 0, false, true
+	}
+	height, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid height"})
+		return 0, false, false
+	}
+	return height, true, true
+}
+
 // GetStateRoot handles state root retrieval
 func (h *Handler) GetStateRoot(c *gin.Context) {
 	stateRoot := h.blockchain.GetStateRoot()
@@ -253,6 +468,7 @@ func (h *Handler) GetStateRoot(c *gin.Context) {
 // CreateBlock handles block creation requests
 func (h *Handler) CreateBlock(c *gin.Context) {
 	if err := h.blockchain.CreateBlock(); err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "Failed to create block", "err", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -260,6 +476,32 @@ func (h *Handler) CreateBlock(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
+// RollbackRequest represents a request to roll the chain back
+type RollbackRequest struct {
+	Height *uint64 `json:"height" binding:"required"` // Height of the new chain tip
+}
+
+// Rollback handles rolling the chain back to a height
+func (h *Handler) Rollback(c *gin.Context) {
+	var req RollbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	h.logger.WarnContext(ctx, "Rollback requested", "height", *req.Height, "client_ip", c.ClientIP())
+	result, err := h.blockchain.RollbackTo(*req.Height)
+	if err != nil {
+		h.logger.WarnContext(ctx, "Rollback refused", "height", *req.Height, "err", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.logger.WarnContext(ctx, "Rollback completed", "height", result.Height,
+		"dropped", result.Dropped, "requeued", result.Requeued)
+	c.JSON(http.StatusOK, result)
+}
+
 // GetBlockchainInfo returns information about the blockchain
 func (h *Handler) GetBlockchainInfo(c *gin.Context) {
 	latestBlock := h.blockchain.GetLatestBlock()
@@ -295,32 +537,7 @@ func (h *Handler) GetAllBlocks(c *gin.Context) {
 
 	var response []BlockResponse
 	for _, block := range blocks {
-		blockHash := block.ComputeHash()
-		prevHash := block.Header.PrevHash
-
-		var transactions []TransactionResponse
-		for _, tx := range block.Transactions {
-			transactions = append(transactions, TransactionResponse{
-				Hash:      hex.EncodeToString(tx.Hash[:]),
-				From:      tx.From,
-				To:        tx.To,
-				Value:     strconv.Itoa(tx.Value),
-				Nonce:     tx.Nonce,
-				Status:    tx.Status.String(),
-				Timestamp: tx.Timestamp,
-			})
-		}
-
-		response = append(response, BlockResponse{
-			Height:           block.Header.Height,
-			Hash:             hex.EncodeToString(blockHash[:]),
-			PrevHash:         hex.EncodeToString(prevHash[:]),
-			MerkleRoot:       hex.EncodeToString(block.Header.MerkleRoot[:]),
-			StateRoot:        block.Header.StateRoot,
-			Timestamp:        block.Header.Timestamp.Unix(),
-			TransactionCount: block.Header.TransactionCount,
-			Transactions:     transactions,
-		})
+		response = append(response, newBlockResponse(block))
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -330,3 +547,105 @@ func (h *Handler) GetAllBlocks(c *gin.Context) {
 		},
 	})
 }
+
+// GetBlockByHash handles retrieving a single block by its hash
+func (h *Handler) GetBlockByHash(c *gin.Context) {
+	hashHex := c.Query("hash")
+	if hashHex == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing hash parameter"})
+		return
+	}
+
+	hashBytes, err := hex.DecodeString(hashHex)
+	if err != nil || len(hashBytes) != 32 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid hash format"})
+		return
+	}
+
+	var hash [32]byte
+	copy(hash[:], hashBytes)
+
+	block, err := h.blockchain.GetBlockByHash(hash)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Block not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newBlockResponse(block))
+}
+
+// SyncBlocksResponse carries full blocks, including transactions with their
+// signatures and the block proofs, for followers to verify and import
+type SyncBlocksResponse struct {
+	Height uint64         `json:"height"` // Number of blocks in the chain
+	Blocks []*block.Block `json:"blocks"`
+}
+
+// GetSyncBlocks handles retrieving full blocks starting at a height
+func (h *Handler) GetSyncBlocks(c *gin.Context) {
+	from, err := strconv.ParseUint(c.DefaultQuery("from", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from height"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageLimit)))
+	if err != nil || limit <= 0 || limit > maxPageLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+
+	c.JSON(http.StatusOK, SyncBlocksResponse{
+		Height: h.blockchain.GetHeight(),
+		Blocks: h.blockchain.GetBlocksFrom(from, limit),
+	})
+}
+
+// statusConfirmed is the status of a transaction in a block. It predates
+// finality tracking and is kept so existing clients still recognize
+// included transactions; the finality field tells how far the block is.
+const statusConfirmed = "confirmed"
+
+// newTransactionResponse converts a transaction to its API representation
+func newTransactionResponse(tx *transaction.Transaction) TransactionResponse {
+	status, finality := tx.Status.String(), ""
+	if tx.Status.Included() {
+		status, finality = statusConfirmed, tx.Status.String()
+	}
+	return TransactionResponse{
+		Hash:      hex.EncodeToString(tx.Hash[:]),
+		From:      tx.From,
+		To:        tx.To,
+		Value:     tx.Value.String(),
+		Nonce:     tx.Nonce,
+		Status:    status,
+		Finality:  finality,
+		Timestamp: tx.Timestamp,
+		Type:      tx.Type.String(),
+		Data:      hex.EncodeToString(tx.Data),
+		Fee:       tx.Fee.String(),
+	}
+}
+
+// newBlockResponse converts a block to its API representation
+func newBlockResponse(block *block.Block) BlockResponse {
+	blockHash := block.ComputeHash()
+	prevHash := block.Header.PrevHash
+
+	var transactions []TransactionResponse
+	for i := range block.Transactions {
+		transactions = append(transactions, newTransactionResponse(&block.Transactions[i]))
+	}
+
+	return BlockResponse{
+		Height:           block.Header.Height,
+		Hash:             hex.EncodeToString(blockHash[:]),
+		PrevHash:         hex.EncodeToString(prevHash[:]),
+		MerkleRoot:       hex.EncodeToString(block.Header.MerkleRoot[:]),
+		StateRoot:        block.Header.StateRoot,
+		Timestamp:        block.Header.Timestamp.Unix(),
+		TransactionCount: block.Header.TransactionCount,
+		Finality:         block.Status.String(),
+		Sequencer:        hex.EncodeToString(block.Header.Sequencer.Bytes()),
+		Transactions:     transactions,
+	}
+}
@@ -1,8 +1,16 @@
 package router
 
 import (
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
 	"github.com/StupidBug/fabric-zkrollup/pkg/api/handlers"
 	"github.com/StupidBug/fabric-zkrollup/pkg/core/blockchain"
+	"github.com/StupidBug/fabric-zkrollup/pkg/logging"
+	"github.com/StupidBug/fabric-zkrollup/pkg/metrics"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,12 +21,13 @@ type Router struct {
 	handler *handlers.Handler
 }
 
-// NewRouter creates a new router instance
-func NewRouter(bc *blockchain.Blockchain) *Router {
-	engine := gin.Default()
+// NewRouter creates a new router instance logging to logger
+func NewRouter(bc *blockchain.Blockchain, logger *slog.Logger) *Router {
+	engine := gin.New()
+	engine.Use(logRequests(logger), gin.Recovery(), observeRequests)
 	return &Router{
 		engine:  engine,
-		handler: handlers.NewHandler(bc),
+		handler: handlers.NewHandler(bc, logger),
 	}
 }
 
@@ -29,20 +38,109 @@ func (r *Router) Setup() {
 	{
 		// Transaction endpoints
 		v1.POST("/transaction/send", r.handler.SendTransaction)
-		v1.GET("/transaction/get", r.handler.GetTransaction)
+	}
+	r.setupQueryRoutes(v1)
+	r.setupAdminRoutes(v1)
+	r.engine.GET("/metrics", gin.WrapH(metrics.Handler()))
+}
+
+// SetupReadOnly sets up only the query routes, for nodes that follow a
+// sequencer instead of accepting transactions. Followers take their chain
+// from the sequencer, so they do not serve the admin routes either.
+func (r *Router) SetupReadOnly() {
+	v1 := r.engine.Group("/api/v1")
+	r.setupQueryRoutes(v1)
+	r.engine.GET("/metrics", gin.WrapH(metrics.Handler()))
+}
 
-		// Balance endpoints
-		v1.GET("/balance/get", r.handler.GetBalance)
+// setupQueryRoutes registers the routes that only read chain data
+func (r *Router) setupQueryRoutes(v1 *gin.RouterGroup) {
+	// Transaction endpoints
+	v1.GET("/transaction/get", r.handler.GetTransaction)
 
-		// Account endpoints
-		v1.GET("/account/nonce", r.handler.GetNonce)
+	// Balance endpoints
+	v1.GET("/balance/get", r.handler.GetBalance)
 
-		// State endpoints
-		v1.GET("/state/root", r.handler.GetStateRoot)
+	// Account endpoints
+	v1.GET("/account/nonce", r.handler.GetNonce)
+	v1.GET("/account/transactions", r.handler.GetAccountTransactions)
+	v1.GET("/accounts", r.handler.GetAccounts)
+
+	// State endpoints
+	v1.GET("/state/root", r.handler.GetStateRoot)
+
+	// Block endpoints
+	v1.GET("/blocks", r.handler.GetAllBlocks)
+	v1.GET("/block/by-hash", r.handler.GetBlockByHash)
+
+	// Sync endpoints
+	v1.GET("/sync/blocks", r.handler.GetSyncBlocks)
+}
+
+// setupAdminRoutes registers the routes that operate the node. They are only
+// served to clients on the same host.
+func (r *Router) setupAdminRoutes(v1 *gin.RouterGroup) {
+	admin := v1.Group("/admin", localOnly)
+	admin.POST("/rollback", r.handler.Rollback)
+}
 
-		// Block endpoints
-		v1.GET("/blocks", r.handler.GetAllBlocks)
+// localOnly rejects requests that do not come from a loopback address. The
+// connection's address is used rather than forwarding headers, which clients
+// control.
+func localOnly(c *gin.Context) {
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin endpoints are only served on localhost"})
+		return
 	}
+	c.Next()
+}
+
+// logRequests assigns each request an ID, taken from the X-Request-ID header
+// if the client sent one, which is returned in the response and attached to
+// every record logged with the request's context. The request itself is
+// logged once it completes.
+func logRequests(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		id := c.GetHeader(logging.RequestIDHeader)
+		if id == "" || len(id) > 64 {
+			id = logging.NewRequestID()
+		}
+		c.Header(logging.RequestIDHeader, id)
+		ctx := logging.WithRequestID(c.Request.Context(), id)
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		// Scrapes would drown out everything else
+		level := slog.LevelInfo
+		if c.FullPath() == "/metrics" {
+			level = slog.LevelDebug
+		}
+		logger.Log(ctx, level, "HTTP request", "method", c.Request.Method, "path", c.Request.URL.Path,
+			"status", c.Writer.Status(), "duration", time.Since(start), "client_ip", c.ClientIP())
+	}
+}
+
+// observeRequests records the duration of each request by route. Requests
+// that match no route share one label, so that arbitrary paths cannot
+// create new series.
+func observeRequests(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	metrics.HTTPRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).
+		Observe(time.Since(start).Seconds())
+}
+
+// Handler returns the router as an http.Handler
+func (r *Router) Handler() http.Handler {
+	return r.engine
 }
 
 // Run starts the HTTP server
@@ -1,10 +1,11 @@
 package chaincode
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -46,77 +47,162 @@ var credPath = filepath.Join(
 const (
 	myChannel     = "mychannel"
 	smartContract = "basic"
+	mspID         = "Org1MSP"
+	walletLabel   = "appUser"
+	walletDir     = "wallet"
 )
 
+// FabricConfig holds the settings for connecting to the Fabric network
+type FabricConfig struct {
+	ConnectionProfile string // Path to the connection profile
+	CredentialsDir    string // MSP directory of the client identity
+	MSPID             string // MSP of the client identity
+	Identity          string // Wallet label of the client identity
+	WalletDir         string // Directory of the file system wallet
+	Channel           string // Channel the verifier chaincode runs on
+	Contract          string // Name of the verifier chaincode
+}
+
+// DefaultFabricConfig returns the settings for User1 of Org1 on the
+// fabric-samples test network
+func DefaultFabricConfig() FabricConfig {
+	return FabricConfig{
+		ConnectionProfile: ccpPath,
+		CredentialsDir:    credPath,
+		MSPID:             mspID,
+		Identity:          walletLabel,
+		WalletDir:         walletDir,
+		Channel:           myChannel,
+		Contract:          smartContract,
+	}
+}
+
 var id = 0
 
-func JsonVerify(output *zk.ProofOutput) {
+// Submitter submits block proofs to the Fabric verifier chaincode
+type Submitter interface {
+	// SubmitProof submits the proof of the block at height. It returns once
+	// Fabric has committed the verification, so a nil error means the proof
+	// is final.
+	SubmitProof(height uint64, output *zk.ProofOutput) error
+}
+
+// FabricSubmitter submits proofs through the Fabric gateway, using the block
+// height as the proof id
+type FabricSubmitter struct {
+	cfg    FabricConfig
+	logger *slog.Logger
+}
+
+// NewFabricSubmitter creates a submitter for the Fabric network in cfg,
+// logging to the default logger
+func NewFabricSubmitter(cfg FabricConfig) *FabricSubmitter {
+	return &FabricSubmitter{cfg: cfg, logger: slog.Default()}
+}
+
+// SetLogger replaces the logger of the submitter
+func (s *FabricSubmitter) SetLogger(logger *slog.Logger) {
+	s.logger = logger
+}
+
+// SubmitProof implements Submitter
+func (s *FabricSubmitter) SubmitProof(height uint64, output *zk.ProofOutput) error {
 	outputBytes, err := json.Marshal(output)
 	if err != nil {
-		fmt.Printf("Failed to marshal proof output: %v\n", err)
-		return
+		return fmt.Errorf("failed to marshal proof output: %v", err)
+	}
+	return verifyMerkleRPC(s.cfg, s.logger.With("height", height), strconv.FormatUint(height, 10), string(outputBytes))
+}
+
+// JsonVerify serializes the proof output and submits it to the Fabric
+// verifier chaincode.
+func JsonVerify(output *zk.ProofOutput) error {
+	outputBytes, err := json.Marshal(output)
+	if err != nil {
+		return fmt.Errorf("failed to marshal proof output: %v", err)
 	}
 	id++
-	VerifyMerkleRPC(strconv.Itoa(id), string(outputBytes))
+	return VerifyMerkleRPC(strconv.Itoa(id), string(outputBytes))
 }
-func VerifyMerkleRPC(id string, output string) {
-	err := os.Setenv("DISCOVERY_AS_LOCALHOST", "true")
+
+// VerifyMerkleRPC submits a serialized proof to the VerifySaveProof chaincode
+// function under the given id.
+func VerifyMerkleRPC(id string, output string) error {
+	return verifyMerkleRPC(DefaultFabricConfig(), slog.Default(), id, output)
+}
+
+// verifyMerkleRPC submits a serialized proof to the Fabric network in cfg
+func verifyMerkleRPC(cfg FabricConfig, logger *slog.Logger, id string, output string) error {
+	gw, contract, err := connect(cfg, logger)
 	if err != nil {
-		log.Fatalf("Error setting DISCOVERY_AS_LOCALHOST environemnt variable: %v", err)
+		return err
 	}
+	defer gw.Close()
 
-	wallet, err := gateway.NewFileSystemWallet("wallet")
+	logger.Debug("Submitting proof to Fabric", "channel", cfg.Channel, "contract", cfg.Contract, "proof_id", id)
+	result, err := contract.SubmitTransaction("VerifySaveProof", id, output)
 	if err != nil {
-		log.Fatalf("Failed to create wallet: %v", err)
+		return fmt.Errorf("failed to submit transaction: %v", err)
 	}
+	logger.Info("Proof verified on Fabric", "proof_id", id, "result", string(result))
 
-	if !wallet.Exists("appUser") {
-		err = populateWallet(wallet)
+	// Listing every stored proof is only worth it when debugging
+	if logger.Enabled(context.Background(), slog.LevelDebug) {
+		result, err = contract.EvaluateTransaction("GetAllProof")
 		if err != nil {
-			log.Fatalf("Failed to populate wallet contents: %v", err)
+			return fmt.Errorf("failed to evaluate transaction: %v", err)
 		}
+		logger.Debug("Proofs stored on Fabric", "proofs", string(result))
 	}
-	gw, err := gateway.Connect(
-		gateway.WithConfig(config.FromFile(filepath.Clean(ccpPath))),
-		gateway.WithIdentity(wallet, "appUser"),
-	)
+	return nil
+}
+
+// connect opens a gateway to the Fabric network in cfg and returns the
+// verifier contract. The caller must close the gateway.
+func connect(cfg FabricConfig, logger *slog.Logger) (*gateway.Gateway, *gateway.Contract, error) {
+	err := os.Setenv("DISCOVERY_AS_LOCALHOST", "true")
 	if err != nil {
-		log.Fatalf("Failed to connect to gateway: %v", err)
+		return nil, nil, fmt.Errorf("error setting DISCOVERY_AS_LOCALHOST environemnt variable: %v", err)
 	}
-	defer gw.Close()
 
-	network, err := gw.GetNetwork(myChannel)
+	wallet, err := gateway.NewFileSystemWallet(cfg.WalletDir)
 	if err != nil {
-		log.Fatalf("Failed to get network: %v", err)
+		return nil, nil, fmt.Errorf("failed to create wallet: %v", err)
 	}
 
-	contract := network.GetContract(smartContract)
-	log.Println("--> Submit Transaction: VerifySaveProof")
-	result, err := contract.SubmitTransaction("VerifySaveProof", id, output)
+	if !wallet.Exists(cfg.Identity) {
+		err = populateWallet(cfg, logger, wallet)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to populate wallet contents: %v", err)
+		}
+	}
+	gw, err := gateway.Connect(
+		gateway.WithConfig(config.FromFile(filepath.Clean(cfg.ConnectionProfile))),
+		gateway.WithIdentity(wallet, cfg.Identity),
+	)
 	if err != nil {
-		log.Fatalf("Failed to Submit transaction: %v", err)
+		return nil, nil, fmt.Errorf("failed to connect to gateway: %v", err)
 	}
-	log.Printf("Verification result: %s\n", string(result))
-	log.Println("Proof verification succeeded!")
-	log.Println("--> Evaluate Transaction: GetAllProof, function returns all the current proofs")
-	result, err = contract.EvaluateTransaction("GetAllProof")
+
+	network, err := gw.GetNetwork(cfg.Channel)
 	if err != nil {
-		log.Fatalf("Failed to evaluate transaction: %v", err)
+		gw.Close()
+		return nil, nil, fmt.Errorf("failed to get network: %v", err)
 	}
-	log.Println(string(result))
+	return gw, network.GetContract(cfg.Contract), nil
 }
 
-func populateWallet(wallet *gateway.Wallet) error {
-	log.Println("============ Populating wallet ============")
+func populateWallet(cfg FabricConfig, logger *slog.Logger, wallet *gateway.Wallet) error {
+	logger.Info("Populating Fabric wallet", "dir", cfg.WalletDir, "identity", cfg.Identity)
 
-	certPath := filepath.Join(credPath, "signcerts", "cert.pem")
+	certPath := filepath.Join(cfg.CredentialsDir, "signcerts", "cert.pem")
 	// read the certificate pem
 	cert, err := ioutil.ReadFile(filepath.Clean(certPath))
 	if err != nil {
 		return err
 	}
 
-	keyDir := filepath.Join(credPath, "keystore")
+	keyDir := filepath.Join(cfg.CredentialsDir, "keystore")
 	// there's a single file in this dir containing the private key
 	files, err := ioutil.ReadDir(keyDir)
 	if err != nil {
@@ -131,7 +217,7 @@ func populateWallet(wallet *gateway.Wallet) error {
 		return err
 	}
 
-	identity := gateway.NewX509Identity("Org1MSP", string(cert), string(key))
+	identity := gateway.NewX509Identity(cfg.MSPID, string(cert), string(key))
 
-	return wallet.Put("appUser", identity)
+	return wallet.Put(cfg.Identity, identity)
 }
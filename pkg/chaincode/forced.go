@@ -0,0 +1,153 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+	"github.com/StupidBug/fabric-zkrollup/pkg/zk"
+)
+
+// ForcedTransaction is a transaction registered on Fabric for forced
+// inclusion. The sequencer must include it in a block at or below Deadline
+// if it can be executed by then. Only blocks whose parent is above
+// Registered are held to it, as earlier blocks may predate the registration.
+type ForcedTransaction struct {
+	Index       uint64                  `json:"index"`      // Position in the queue
+	Registered  uint64                  `json:"registered"` // L2 tip on Fabric when the transaction was registered
+	Deadline    uint64                  `json:"deadline"`   // L2 height by which the transaction must be included
+	Transaction transaction.Transaction `json:"transaction"`
+}
+
+// ForcedQueue is the forced-inclusion queue kept by the verifier chaincode.
+// When a transaction is registered, the chaincode records the L2 tip it
+// knows, the last block whose proof it verified, and fixes the deadline a
+// set number of blocks after that tip. The queue only grows.
+//
+// The verifier chaincode is deployed separately from this repository and
+// must provide RegisterForcedTx, taking the JSON encoded transaction and
+// returning the new entry, and GetForcedTxs, returning every entry in queue
+// order.
+type ForcedQueue interface {
+	// RegisterForced adds a signed transaction to the queue
+	RegisterForced(tx transaction.Transaction) (*ForcedTransaction, error)
+	// ForcedTransactions returns every registered transaction in queue order
+	ForcedTransactions() ([]ForcedTransaction, error)
+}
+
+// FabricForcedQueue reads and writes the forced-inclusion queue through the
+// RegisterForcedTx and GetForcedTxs functions of the verifier chaincode
+type FabricForcedQueue struct {
+	cfg    FabricConfig
+	logger *slog.Logger
+}
+
+// NewFabricForcedQueue creates a client for the queue on the Fabric network
+// in cfg, logging to the default logger
+func NewFabricForcedQueue(cfg FabricConfig) *FabricForcedQueue {
+	return &FabricForcedQueue{cfg: cfg, logger: slog.Default()}
+}
+
+// RegisterForced implements ForcedQueue
+func (q *FabricForcedQueue) RegisterForced(tx transaction.Transaction) (*ForcedTransaction, error) {
+	txBytes, err := json.Marshal(tx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal transaction: %v", err)
+	}
+	gw, contract, err := connect(q.cfg, q.logger)
+	if err != nil {
+		return nil, err
+	}
+	defer gw.Close()
+
+	result, err := contract.SubmitTransaction("RegisterForcedTx", string(txBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to register forced transaction: %v", err)
+	}
+	var entry ForcedTransaction
+	if err := json.Unmarshal(result, &entry); err != nil {
+		return nil, fmt.Errorf("failed to parse forced transaction: %v", err)
+	}
+	return &entry, nil
+}
+
+// ForcedTransactions implements ForcedQueue
+func (q *FabricForcedQueue) ForcedTransactions() ([]ForcedTransaction, error) {
+	gw, contract, err := connect(q.cfg, q.logger)
+	if err != nil {
+		return nil, err
+	}
+	defer gw.Close()
+
+	result, err := contract.EvaluateTransaction("GetForcedTxs")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read forced transactions: %v", err)
+	}
+	var entries []ForcedTransaction
+	if err := json.Unmarshal(result, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse forced transactions: %v", err)
+	}
+	return entries, nil
+}
+
+// MemoryFabric is an in-memory stand-in for the verifier chaincode, for tests
+// and local development. It accepts proofs in height order without checking
+// them and keeps the forced-inclusion queue.
+type MemoryFabric struct {
+	mu       sync.Mutex
+	delay    uint64 // Blocks between registration and deadline
+	verified uint64 // Height of the last accepted proof
+	forced   []ForcedTransaction
+}
+
+// NewMemoryFabric creates an empty stand-in that gives forced transactions a
+// deadline delay blocks after the last verified block
+func NewMemoryFabric(delay uint64) *MemoryFabric {
+	return &MemoryFabric{delay: delay}
+}
+
+// SubmitProof implements Submitter
+func (f *MemoryFabric) SubmitProof(height uint64, output *zk.ProofOutput) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if height <= f.verified {
+		return fmt.Errorf("proof for block %d already verified", height)
+	}
+	f.verified = height
+	return nil
+}
+
+// RegisterForced implements ForcedQueue. The deadline is fixed to the
+// verified tip at registration and does not move with later proofs.
+func (f *MemoryFabric) RegisterForced(tx transaction.Transaction) (*ForcedTransaction, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if tx.Hash != tx.ComputeHash() {
+		return nil, fmt.Errorf("transaction hash mismatch")
+	}
+	if tx.Signature.R == nil || tx.Signature.S == nil {
+		return nil, fmt.Errorf("missing signature")
+	}
+	entry := ForcedTransaction{
+		Index:       uint64(len(f.forced)),
+		Registered:  f.verified,
+		Deadline:    f.verified + f.delay,
+		Transaction: tx,
+	}
+	f.forced = append(f.forced, entry)
+	return &entry, nil
+}
+
+// ForcedTransactions implements ForcedQueue
+func (f *MemoryFabric) ForcedTransactions() ([]ForcedTransaction, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entries := make([]ForcedTransaction, len(f.forced))
+	copy(entries, f.forced)
+	return entries, nil
+}
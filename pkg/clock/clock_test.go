@@ -0,0 +1,41 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestManual(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewManual(start)
+	ticker := m.NewTicker(time.Second)
+
+	m.Advance(500 * time.Millisecond)
+	select {
+	case <-ticker.C():
+		t.Fatal("Expected no tick before the interval")
+	default:
+	}
+
+	// Ticks missed by a slow receiver are dropped
+	m.Advance(3 * time.Second)
+	if tick := <-ticker.C(); !tick.Equal(start.Add(time.Second)) {
+		t.Errorf("Expected first tick at 1s, got %v", tick.Sub(start))
+	}
+	select {
+	case <-ticker.C():
+		t.Fatal("Expected missed ticks to be dropped")
+	default:
+	}
+	if got := m.Now().Sub(start); got != 3500*time.Millisecond {
+		t.Errorf("Expected clock at 3.5s, got %v", got)
+	}
+
+	ticker.Stop()
+	m.Advance(time.Minute)
+	select {
+	case <-ticker.C():
+		t.Fatal("Expected stopped ticker not to tick")
+	default:
+	}
+}
@@ -0,0 +1,492 @@
+package config
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/chaincode"
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/blockchain"
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/txpool"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+	"github.com/StupidBug/fabric-zkrollup/pkg/zk"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the configuration of a zkrollup node
+type Config struct {
+	ListenAddr      string          `yaml:"listen_addr"`      // Address the HTTP API listens on
+	DataDir         string          `yaml:"data_dir"`         // Directory to persist blocks in, in-memory if empty
+	LogLevel        string          `yaml:"log_level"`        // debug, info, warn or error
+	LogFormat       string          `yaml:"log_format"`       // text or json
+	ShutdownTimeout time.Duration   `yaml:"shutdown_timeout"` // Time to wait for in-flight work on shutdown
+	Block           BlockConfig     `yaml:"block"`
+	Pool            PoolConfig      `yaml:"pool"`
+	Prover          ProverConfig    `yaml:"prover"`
+	Sequencer       SequencerConfig `yaml:"sequencer"`
+	Genesis         GenesisConfig   `yaml:"genesis"`
+	Follower        FollowerConfig  `yaml:"follower"`
+	Fabric          FabricConfig    `yaml:"fabric"`
+}
+
+// BlockConfig controls block production
+type BlockConfig struct {
+	Interval        time.Duration `yaml:"interval"`         // Time between blocks
+	MaxTransactions int           `yaml:"max_transactions"` // Maximum transactions per block
+	MaxBytes        int           `yaml:"max_bytes"`        // Maximum total size of the transactions in a block
+	MaxCost         int           `yaml:"max_cost"`         // Maximum total execution cost of the transactions in a block
+}
+
+// PoolConfig limits the transaction pool
+type PoolConfig struct {
+	MaxSize      int           `yaml:"max_size"`            // Transactions the pool holds at most
+	MaxPerSender int           `yaml:"max_per_sender"`      // Transactions one sender may have in the pool
+	MaxQueued    int           `yaml:"max_queued"`          // Transactions one sender may have waiting behind a nonce gap
+	MaxNonceGap  int           `yaml:"max_nonce_gap"`       // Distance from the sender's confirmed nonce a transaction may be pooled at
+	MinFee       types.Amount  `yaml:"min_fee"`             // Fee a transaction has to pay to enter the pool
+	Lifetime     time.Duration `yaml:"lifetime"`            // Time a transaction may wait in the pool, unlimited if 0
+	Revalidate   time.Duration `yaml:"revalidate_interval"` // Time between sweeps for expired and invalid transactions
+	PriceBump    int           `yaml:"price_bump"`          // Percentage by which a replacement must raise the fee
+	Journal      string        `yaml:"journal"`             // File to journal pooled transactions to, relative to the data directory
+	Rejournal    time.Duration `yaml:"rejournal_interval"`  // Time between compactions of the journal
+}
+
+// ProverConfig selects the proving backend and where its keys are kept
+type ProverConfig struct {
+	Backend string `yaml:"backend"` // Proving backend, only groth16 is supported
+	KeyDir  string `yaml:"key_dir"` // Directory to persist circuit keys in, the data directory if empty
+}
+
+// SequencerConfig holds the identity of a sequencer
+type SequencerConfig struct {
+	KeyFile string `yaml:"key_file"` // PEM encoded P-256 key blocks are signed with, the development key if empty
+}
+
+// GenesisConfig holds the chain parameters fixed at genesis, which must be
+// the same on every node of a chain
+type GenesisConfig struct {
+	SequencerKey string `yaml:"sequencer_key"` // Hex uncompressed public key of the first sequencer, the development key if empty
+}
+
+// FollowerConfig configures follower mode
+type FollowerConfig struct {
+	SequencerURL string        `yaml:"sequencer_url"` // Sequencer to follow instead of producing blocks, if set
+	SyncInterval time.Duration `yaml:"sync_interval"` // Time between syncs with the sequencer
+}
+
+// FabricConfig holds the settings for connecting to the Fabric network
+type FabricConfig struct {
+	ConnectionProfile string `yaml:"connection_profile"` // Path to the connection profile
+	CredentialsDir    string `yaml:"credentials_dir"`    // MSP directory of the client identity
+	MSPID             string `yaml:"msp_id"`             // MSP of the client identity
+	Identity          string `yaml:"identity"`           // Wallet label of the client identity
+	WalletDir         string `yaml:"wallet_dir"`         // Directory of the file system wallet
+	Channel           string `yaml:"channel"`            // Channel the verifier chaincode runs on
+	Contract          string `yaml:"contract"`           // Name of the verifier chaincode
+	ForcedInclusion   bool   `yaml:"forced_inclusion"`   // Honour the forced-inclusion queue of the chaincode
+}
+
+// Default returns the default configuration, which runs an in-memory
+// sequencer against the fabric-samples test network
+func Default() *Config {
+	fabric := chaincode.DefaultFabricConfig()
+	pool := txpool.DefaultConfig()
+	return &Config{
+		ListenAddr:      ":8080",
+		LogLevel:        "info",
+		LogFormat:       "text",
+		ShutdownTimeout: 30 * time.Second,
+		Block: BlockConfig{
+			Interval:        1 * time.Second,
+			MaxTransactions: 16,
+			MaxBytes:        64 << 10,
+			MaxCost:         16 * transaction.CostTransfer,
+		},
+		Pool: PoolConfig{
+			MaxSize:      pool.MaxSize,
+			MaxPerSender: pool.MaxPerSender,
+			MaxQueued:    pool.MaxQueued,
+			MaxNonceGap:  int(pool.MaxNonceGap),
+			MinFee:       pool.MinFee,
+			Lifetime:     pool.Lifetime,
+			Revalidate:   pool.Revalidate,
+			PriceBump:    pool.PriceBump,
+			Journal:      "txpool.jsonl",
+			Rejournal:    pool.Rejournal,
+		},
+		Prover: ProverConfig{
+			Backend: zk.BackendGroth16,
+		},
+		Follower: FollowerConfig{
+			SyncInterval: 2 * time.Second,
+		},
+		Fabric: FabricConfig{
+			ConnectionProfile: fabric.ConnectionProfile,
+			CredentialsDir:    fabric.CredentialsDir,
+			MSPID:             fabric.MSPID,
+			Identity:          fabric.Identity,
+			WalletDir:         fabric.WalletDir,
+			Channel:           fabric.Channel,
+			Contract:          fabric.Contract,
+		},
+	}
+}
+
+// configEnv is the environment variable naming the config file
+const configEnv = "ZKROLLUP_CONFIG"
+
+// setting is a configuration value that can be overridden from the
+// environment and the command line
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{"listen", "ZKROLLUP_LISTEN_ADDR", "Address the HTTP API listens on", setString(func(c *Config) *string { return &c.ListenAddr })},
+	{"datadir", "ZKROLLUP_DATA_DIR", "Directory to persist blocks in (in-memory if empty)", setString(func(c *Config) *string { return &c.DataDir })},
+	{"log-level", "ZKROLLUP_LOG_LEVEL", "Log level: debug, info, warn or error", setString(func(c *Config) *string { return &c.LogLevel })},
+	{"log-format", "ZKROLLUP_LOG_FORMAT", "Log format: text or json", setString(func(c *Config) *string { return &c.LogFormat })},
+	{"shutdown-timeout", "ZKROLLUP_SHUTDOWN_TIMEOUT", "Time to wait for in-flight requests and proving on shutdown", setDuration(func(c *Config) *time.Duration { return &c.ShutdownTimeout })},
+	{"block-interval", "ZKROLLUP_BLOCK_INTERVAL", "Time between blocks", setDuration(func(c *Config) *time.Duration { return &c.Block.Interval })},
+	{"max-block-txs", "ZKROLLUP_MAX_BLOCK_TXS", "Maximum transactions per block", setInt(func(c *Config) *int { return &c.Block.MaxTransactions })},
+	{"max-block-bytes", "ZKROLLUP_MAX_BLOCK_BYTES", "Maximum total size of the transactions in a block", setInt(func(c *Config) *int { return &c.Block.MaxBytes })},
+	{"max-block-cost", "ZKROLLUP_MAX_BLOCK_COST", "Maximum total execution cost of the transactions in a block (a transfer costs 100)", setInt(func(c *Config) *int { return &c.Block.MaxCost })},
+	{"pool-size", "ZKROLLUP_POOL_SIZE", "Maximum transactions in the pool", setInt(func(c *Config) *int { return &c.Pool.MaxSize })},
+	{"pool-sender-limit", "ZKROLLUP_POOL_SENDER_LIMIT", "Maximum pooled transactions per sender", setInt(func(c *Config) *int { return &c.Pool.MaxPerSender })},
+	{"pool-queue-limit", "ZKROLLUP_POOL_QUEUE_LIMIT", "Maximum pooled transactions per sender waiting behind a nonce gap", setInt(func(c *Config) *int { return &c.Pool.MaxQueued })},
+	{"pool-nonce-gap", "ZKROLLUP_POOL_NONCE_GAP", "Distance from the sender's confirmed nonce a transaction may be pooled at", setInt(func(c *Config) *int { return &c.Pool.MaxNonceGap })},
+	{"pool-min-fee", "ZKROLLUP_POOL_MIN_FEE", "Fee a transaction has to pay to enter the pool", setAmount(func(c *Config) *types.Amount { return &c.Pool.MinFee })},
+	{"pool-lifetime", "ZKROLLUP_POOL_LIFETIME", "Time a transaction may wait in the pool (0 for unlimited)", setDuration(func(c *Config) *time.Duration { return &c.Pool.Lifetime })},
+	{"pool-revalidate", "ZKROLLUP_POOL_REVALIDATE", "Time between sweeps of the pool for expired and invalid transactions", setDuration(func(c *Config) *time.Duration { return &c.Pool.Revalidate })},
+	{"pool-journal", "ZKROLLUP_POOL_JOURNAL", "File in the data directory to journal pooled transactions to (disabled if empty)", setString(func(c *Config) *string { return &c.Pool.Journal })},
+	{"pool-rejournal", "ZKROLLUP_POOL_REJOURNAL", "Time between compactions of the pool journal", setDuration(func(c *Config) *time.Duration { return &c.Pool.Rejournal })},
+	{"pool-price-bump", "ZKROLLUP_POOL_PRICE_BUMP", "Percentage by which a replacement must raise the fee of a pooled transaction", setInt(func(c *Config) *int { return &c.Pool.PriceBump })},
+	{"prover-backend", "ZKROLLUP_PROVER_BACKEND", "Proving backend", setString(func(c *Config) *string { return &c.Prover.Backend })},
+	{"prover-key-dir", "ZKROLLUP_PROVER_KEY_DIR", "Directory to persist circuit keys in (the data directory if empty)", setString(func(c *Config) *string { return &c.Prover.KeyDir })},
+	{"sequencer-key", "ZKROLLUP_SEQUENCER_KEY", "PEM file with the key blocks are signed with (development key if empty)", setString(func(c *Config) *string { return &c.Sequencer.KeyFile })},
+	{"genesis-sequencer-key", "ZKROLLUP_GENESIS_SEQUENCER_KEY", "Hex public key of the first sequencer, pinned in genesis (development key if empty)", setString(func(c *Config) *string { return &c.Genesis.SequencerKey })},
+	{"follow", "ZKROLLUP_FOLLOW", "Sequencer API URL to follow instead of producing blocks", setString(func(c *Config) *string { return &c.Follower.SequencerURL })},
+	{"sync-interval", "ZKROLLUP_SYNC_INTERVAL", "Interval between syncs with the sequencer in follower mode", setDuration(func(c *Config) *time.Duration { return &c.Follower.SyncInterval })},
+	{"fabric-connection-profile", "ZKROLLUP_FABRIC_CONNECTION_PROFILE", "Path to the Fabric connection profile", setString(func(c *Config) *string { return &c.Fabric.ConnectionProfile })},
+	{"fabric-credentials", "ZKROLLUP_FABRIC_CREDENTIALS", "MSP directory of the Fabric client identity", setString(func(c *Config) *string { return &c.Fabric.CredentialsDir })},
+	{"fabric-msp-id", "ZKROLLUP_FABRIC_MSP_ID", "MSP of the Fabric client identity", setString(func(c *Config) *string { return &c.Fabric.MSPID })},
+	{"fabric-identity", "ZKROLLUP_FABRIC_IDENTITY", "Wallet label of the Fabric client identity", setString(func(c *Config) *string { return &c.Fabric.Identity })},
+	{"fabric-wallet", "ZKROLLUP_FABRIC_WALLET", "Directory of the Fabric wallet", setString(func(c *Config) *string { return &c.Fabric.WalletDir })},
+	{"fabric-channel", "ZKROLLUP_FABRIC_CHANNEL", "Channel the verifier chaincode runs on", setString(func(c *Config) *string { return &c.Fabric.Channel })},
+	{"fabric-contract", "ZKROLLUP_FABRIC_CONTRACT", "Name of the verifier chaincode", setString(func(c *Config) *string { return &c.Fabric.Contract })},
+	{"forced-inclusion", "ZKROLLUP_FORCED_INCLUSION", "Include and require transactions from the forced-inclusion queue of the chaincode", setBool(func(c *Config) *bool { return &c.Fabric.ForcedInclusion })},
+}
+
+// setString returns a setter for the string field selected by field
+func setString(field func(c *Config) *string) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+// setDuration returns a setter for the duration field selected by field
+func setDuration(field func(c *Config) *time.Duration) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+// setInt returns a setter for the int field selected by field
+func setInt(field func(c *Config) *int) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+// setAmount returns a setter for the amount field selected by field
+func setAmount(field func(c *Config) *types.Amount) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		a, err := types.ParseAmount(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = a
+		return nil
+	}
+}
+
+// setBool returns a setter for the bool field selected by field
+func setBool(field func(c *Config) *bool) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+// Load builds the configuration from the defaults, a YAML config file, the
+// environment and the command line args, each layer overriding the one
+// before, and validates the result. The config file is named by the -config
+// flag or the ZKROLLUP_CONFIG environment variable. lookupEnv is usually
+// os.LookupEnv.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	fs := flag.NewFlagSet("zkrollup", flag.ContinueOnError)
+	path := fs.String("config", "", fmt.Sprintf("Path to a YAML config file (env %s)", configEnv))
+
+	// Flags are applied last, after the file and environment are loaded
+	type flagValue struct {
+		setting setting
+		value   string
+	}
+	var flagValues []flagValue
+	for _, s := range settings {
+		s := s
+		fs.Func(s.flag, fmt.Sprintf("%s (env %s)", s.usage, s.env), func(value string) error {
+			if err := s.set(Default(), value); err != nil {
+				return err
+			}
+			flagValues = append(flagValues, flagValue{setting: s, value: value})
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	cfg := Default()
+	if *path == "" {
+		*path, _ = lookupEnv(configEnv)
+	}
+	if *path != "" {
+		if err := cfg.loadFile(*path); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range settings {
+		if value, ok := lookupEnv(s.env); ok {
+			if err := s.set(cfg, value); err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", s.env, err)
+			}
+		}
+	}
+	for _, fv := range flagValues {
+		fv.setting.set(cfg, fv.value)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %v", err)
+	}
+	return cfg, nil
+}
+
+// loadFile overlays the YAML config file at path. Unknown keys are rejected so
+// that typos do not silently fall back to defaults.
+func (c *Config) loadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// Validate checks that the configuration is usable
+func (c *Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		return fmt.Errorf("listen address %q: %v", c.ListenAddr, err)
+	}
+	if _, err := c.SlogLevel(); err != nil {
+		return err
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		return fmt.Errorf("unknown log format %q", c.LogFormat)
+	}
+	if c.ShutdownTimeout <= 0 {
+		return fmt.Errorf("shutdown timeout must be positive")
+	}
+	if c.Block.Interval <= 0 {
+		return fmt.Errorf("block interval must be positive")
+	}
+	if c.Block.MaxTransactions <= 0 {
+		return fmt.Errorf("max transactions per block must be positive")
+	}
+	if c.Block.MaxBytes <= 0 {
+		return fmt.Errorf("max block size must be positive")
+	}
+	if c.Block.MaxCost <= 0 {
+		return fmt.Errorf("max block cost must be positive")
+	}
+	if c.Pool.MaxSize <= 0 {
+		return fmt.Errorf("pool size must be positive")
+	}
+	if c.Pool.MaxPerSender <= 0 {
+		return fmt.Errorf("pool limit per sender must be positive")
+	}
+	if c.Pool.MaxQueued <= 0 {
+		return fmt.Errorf("pool queue limit per sender must be positive")
+	}
+	if c.Pool.MaxNonceGap <= 0 {
+		return fmt.Errorf("pool nonce gap must be positive")
+	}
+	if c.Pool.Lifetime < 0 {
+		return fmt.Errorf("pool lifetime must not be negative")
+	}
+	if c.Pool.Revalidate <= 0 {
+		return fmt.Errorf("pool revalidation interval must be positive")
+	}
+	if c.Pool.PriceBump < 0 || c.Pool.PriceBump > txpool.MaxPriceBump {
+		return fmt.Errorf("pool price bump must be between 0 and %d", txpool.MaxPriceBump)
+	}
+	if c.Pool.Rejournal <= 0 {
+		return fmt.Errorf("pool rejournal interval must be positive")
+	}
+	if c.Prover.Backend != zk.BackendGroth16 {
+		return fmt.Errorf("unsupported prover backend %q", c.Prover.Backend)
+	}
+	if _, err := c.BlockchainGenesis(); err != nil {
+		return err
+	}
+
+	if c.Follower.SequencerURL != "" {
+		u, err := url.Parse(c.Follower.SequencerURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("sequencer URL %q must be an http or https URL", c.Follower.SequencerURL)
+		}
+		if c.Follower.SyncInterval <= 0 {
+			return fmt.Errorf("sync interval must be positive")
+		}
+		// Followers only read the forced-inclusion queue from Fabric
+		if !c.Fabric.ForcedInclusion {
+			return nil
+		}
+		return c.validateFabric()
+	}
+
+	// A real sequencer key is useless against the development genesis key
+	if c.Sequencer.KeyFile != "" && c.Genesis.SequencerKey == "" {
+		return fmt.Errorf("genesis sequencer key must be set when a sequencer key file is used")
+	}
+	return c.validateFabric()
+}
+
+// validateFabric checks that the Fabric connection settings are complete
+func (c *Config) validateFabric() error {
+	fabric := []struct {
+		name  string
+		value string
+	}{
+		{"connection profile", c.Fabric.ConnectionProfile},
+		{"credentials dir", c.Fabric.CredentialsDir},
+		{"MSP ID", c.Fabric.MSPID},
+		{"identity", c.Fabric.Identity},
+		{"wallet dir", c.Fabric.WalletDir},
+		{"channel", c.Fabric.Channel},
+		{"contract", c.Fabric.Contract},
+	}
+	for _, f := range fabric {
+		if f.value == "" {
+			return fmt.Errorf("fabric %s must be set", f.name)
+		}
+	}
+	return nil
+}
+
+// TxPoolConfig returns the limits of the transaction pool
+func (c *Config) TxPoolConfig() txpool.Config {
+	return txpool.Config{
+		MaxSize:      c.Pool.MaxSize,
+		MaxPerSender: c.Pool.MaxPerSender,
+		MaxQueued:    c.Pool.MaxQueued,
+		MaxNonceGap:  uint64(c.Pool.MaxNonceGap),
+		MinFee:       c.Pool.MinFee,
+		Lifetime:     c.Pool.Lifetime,
+		Revalidate:   c.Pool.Revalidate,
+		PriceBump:    c.Pool.PriceBump,
+		Rejournal:    c.Pool.Rejournal,
+	}
+}
+
+// PoolJournalPath returns the file to journal pooled transactions to, or an
+// empty string if the pool is not journaled. An in-memory node keeps no
+// journal.
+func (c *Config) PoolJournalPath() string {
+	if c.DataDir == "" || c.Pool.Journal == "" {
+		return ""
+	}
+	if filepath.IsAbs(c.Pool.Journal) {
+		return c.Pool.Journal
+	}
+	return filepath.Join(c.DataDir, c.Pool.Journal)
+}
+
+// ChaincodeConfig returns the Fabric settings for the chaincode client
+func (c *Config) ChaincodeConfig() chaincode.FabricConfig {
+	return chaincode.FabricConfig{
+		ConnectionProfile: c.Fabric.ConnectionProfile,
+		CredentialsDir:    c.Fabric.CredentialsDir,
+		MSPID:             c.Fabric.MSPID,
+		Identity:          c.Fabric.Identity,
+		WalletDir:         c.Fabric.WalletDir,
+		Channel:           c.Fabric.Channel,
+		Contract:          c.Fabric.Contract,
+	}
+}
+
+// BlockchainGenesis returns the genesis of the chain
+func (c *Config) BlockchainGenesis() (blockchain.Genesis, error) {
+	if c.Genesis.SequencerKey == "" {
+		return blockchain.DefaultGenesis(), nil
+	}
+	data, err := hex.DecodeString(strings.TrimPrefix(c.Genesis.SequencerKey, "0x"))
+	if err != nil {
+		return blockchain.Genesis{}, fmt.Errorf("genesis sequencer key: %v", err)
+	}
+	key, err := transaction.ParsePublicKey(data)
+	if err != nil {
+		return blockchain.Genesis{}, fmt.Errorf("genesis sequencer key: %v", err)
+	}
+	return blockchain.Genesis{SequencerKey: key.ECDSA()}, nil
+}
+
+// SlogLevel returns the log level as a slog.Level
+func (c *Config) SlogLevel() (slog.Level, error) {
+	switch strings.ToLower(c.LogLevel) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q", c.LogLevel)
+	}
+}
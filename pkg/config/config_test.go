@@ -0,0 +1,165 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
+)
+
+// env returns a lookup function over a fixed environment
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := vars[key]
+		return value, ok
+	}
+}
+
+// writeConfig writes a config file and returns its path
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load(nil, env(nil))
+	if err != nil {
+		t.Fatalf("Failed to load defaults: %v", err)
+	}
+	if cfg.ListenAddr != ":8080" || cfg.Block.Interval != time.Second || cfg.Block.MaxTransactions != 16 {
+		t.Errorf("Unexpected defaults: %+v", cfg)
+	}
+}
+
+func TestLoadLayering(t *testing.T) {
+	path := writeConfig(t, `
+listen_addr: ":9000"
+data_dir: /var/lib/zkrollup
+block:
+  interval: 5s
+  max_transactions: 8
+fabric:
+  channel: filechannel
+`)
+
+	cfg, err := Load(
+		[]string{"-config", path, "-max-block-txs", "4"},
+		env(map[string]string{
+			"ZKROLLUP_BLOCK_INTERVAL": "3s",
+			"ZKROLLUP_MAX_BLOCK_TXS":  "6",
+		}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	// File overrides defaults, environment overrides file, flags override both
+	if cfg.ListenAddr != ":9000" || cfg.DataDir != "/var/lib/zkrollup" || cfg.Fabric.Channel != "filechannel" {
+		t.Errorf("Expected file values, got %+v", cfg)
+	}
+	if cfg.Block.Interval != 3*time.Second {
+		t.Errorf("Expected environment block interval 3s, got %v", cfg.Block.Interval)
+	}
+	if cfg.Block.MaxTransactions != 4 {
+		t.Errorf("Expected flag max transactions 4, got %d", cfg.Block.MaxTransactions)
+	}
+	if cfg.Fabric.Contract != "basic" {
+		t.Errorf("Expected default contract, got %q", cfg.Fabric.Contract)
+	}
+
+	// The config file can also be named by the environment
+	cfg, err = Load(nil, env(map[string]string{"ZKROLLUP_CONFIG": path}))
+	if err != nil {
+		t.Fatalf("Failed to load config from environment: %v", err)
+	}
+	if cfg.ListenAddr != ":9000" {
+		t.Errorf("Expected listen address from file, got %q", cfg.ListenAddr)
+	}
+}
+
+func TestLoadRejectsInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		file string
+		want string
+	}{
+		{name: "unknown file key", file: "block:\n  intervall: 1s\n", want: "intervall"},
+		{name: "bad duration", env: map[string]string{"ZKROLLUP_BLOCK_INTERVAL": "soon"}, want: "ZKROLLUP_BLOCK_INTERVAL"},
+		{name: "bad flag", args: []string{"-max-block-txs", "many"}, want: "max-block-txs"},
+		{name: "zero interval", args: []string{"-block-interval", "0s"}, want: "block interval"},
+		{name: "zero block size", args: []string{"-max-block-txs", "0"}, want: "max transactions"},
+		{name: "zero block bytes", args: []string{"-max-block-bytes", "0"}, want: "max block size"},
+		{name: "zero block cost", args: []string{"-max-block-cost", "-1"}, want: "max block cost"},
+		{name: "zero pool size", args: []string{"-pool-size", "0"}, want: "pool size"},
+		{name: "zero pool sender limit", args: []string{"-pool-sender-limit", "0"}, want: "pool limit per sender"},
+		{name: "zero pool queue limit", args: []string{"-pool-queue-limit", "0"}, want: "pool queue limit"},
+		{name: "zero pool nonce gap", env: map[string]string{"ZKROLLUP_POOL_NONCE_GAP": "0"}, want: "pool nonce gap"},
+		{name: "negative pool min fee", args: []string{"-pool-min-fee", "-1"}, want: "pool-min-fee"},
+		{name: "bad file min fee", file: "pool:\n  min_fee: cheap\n", want: "invalid amount"},
+		{name: "negative pool lifetime", env: map[string]string{"ZKROLLUP_POOL_LIFETIME": "-1m"}, want: "pool lifetime"},
+		{name: "zero pool revalidation", args: []string{"-pool-revalidate", "0s"}, want: "pool revalidation interval"},
+		{name: "negative pool price bump", args: []string{"-pool-price-bump", "-1"}, want: "pool price bump"},
+		{name: "huge pool price bump", args: []string{"-pool-price-bump", "1001"}, want: "pool price bump"},
+		{name: "zero pool rejournal", args: []string{"-pool-rejournal", "0s"}, want: "pool rejournal interval"},
+		{name: "listen address", args: []string{"-listen", "8080"}, want: "listen address"},
+		{name: "log level", args: []string{"-log-level", "loud"}, want: "log level"},
+		{name: "log format", args: []string{"-log-format", "xml"}, want: "log format"},
+		{name: "prover backend", args: []string{"-prover-backend", "plonk"}, want: "prover backend"},
+		{name: "sequencer URL", args: []string{"-follow", "localhost:8080"}, want: "sequencer URL"},
+		{name: "fabric channel", args: []string{"-fabric-channel", ""}, want: "fabric channel"},
+		{name: "follower forced inclusion", args: []string{"-follow", "http://localhost:8080", "-forced-inclusion", "true", "-fabric-channel", ""}, want: "fabric channel"},
+		{name: "bad bool", args: []string{"-forced-inclusion", "maybe"}, want: "forced-inclusion"},
+		{name: "genesis sequencer key", args: []string{"-genesis-sequencer-key", "04ab"}, want: "genesis sequencer key"},
+		{name: "sequencer key without genesis", args: []string{"-sequencer-key", "sequencer.pem"}, want: "genesis sequencer key must be set"},
+		{name: "extra argument", args: []string{"serve"}, want: "unexpected arguments"},
+	}
+
+	for _, tt := range tests {
+		args := tt.args
+		if tt.file != "" {
+			args = append([]string{"-config", writeConfig(t, tt.file)}, args...)
+		}
+		_, err := Load(args, env(tt.env))
+		if err == nil {
+			t.Errorf("%s: expected error", tt.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected error mentioning %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestFollowerSkipsFabricValidation(t *testing.T) {
+	cfg, err := Load([]string{"-follow", "http://sequencer:8080", "-fabric-channel", ""}, env(nil))
+	if err != nil {
+		t.Fatalf("Expected follower config without Fabric to be valid: %v", err)
+	}
+	if cfg.Follower.SequencerURL != "http://sequencer:8080" {
+		t.Errorf("Unexpected sequencer URL %q", cfg.Follower.SequencerURL)
+	}
+}
+
+func TestLoadPoolMinFee(t *testing.T) {
+	path := writeConfig(t, "pool:\n  min_fee: 340282366920938463463374607431768211455\n")
+	cfg, err := Load([]string{"-config", path}, env(nil))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.TxPoolConfig().MinFee != types.MaxAmount() {
+		t.Errorf("Expected min fee %s, got %s", types.MaxAmount(), cfg.Pool.MinFee)
+	}
+	cfg, err = Load(nil, env(map[string]string{"ZKROLLUP_POOL_MIN_FEE": "10"}))
+	if err != nil || cfg.Pool.MinFee != types.NewAmount(10) {
+		t.Errorf("Expected min fee 10 from the environment, got %v (%v)", cfg, err)
+	}
+}
@@ -0,0 +1,213 @@
+package blockchain
+
+import (
+	"context"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+)
+
+const (
+	// admissionQueueSize is the number of submissions that may wait for
+	// signature verification before SubmitTransaction blocks
+	admissionQueueSize = 1024
+	// maxAdmissionBatch is the number of verified submissions admitted to
+	// the pool under one write lock
+	maxAdmissionBatch = 256
+)
+
+// AdmissionFuture is the pending result of a transaction submitted to the
+// admission pipeline
+type AdmissionFuture struct {
+	done     chan struct{}
+	err      error
+	callback func(error)
+}
+
+// Done returns a channel that is closed once the transaction has been
+// admitted or rejected
+func (f *AdmissionFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Err returns nil if the transaction was admitted and why it was not
+// otherwise. It must only be called after Done is closed.
+func (f *AdmissionFuture) Err() error {
+	return f.err
+}
+
+// Wait waits for the result of the submission. If ctx ends first it returns
+// the context error, and the transaction may still be admitted.
+func (f *AdmissionFuture) Wait(ctx context.Context) error {
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// complete records the result and notifies the submitter
+func (f *AdmissionFuture) complete(err error) {
+	f.err = err
+	if f.callback != nil {
+		f.callback(err)
+	}
+	close(f.done)
+}
+
+// submission is a transaction travelling through the admission pipeline
+type submission struct {
+	ctx    context.Context
+	tx     transaction.Transaction
+	err    error // Set once a stage rejects the transaction
+	future *AdmissionFuture
+}
+
+// admission is the pipeline behind SubmitTransaction. Signatures are
+// verified by a pool of workers, one per CPU, and the verified transactions
+// are validated and pooled in batches by a single goroutine, which takes the
+// write lock once per batch and orders each batch by sender and nonce. The
+// pipeline runs while block production does.
+type admission struct {
+	mu       sync.RWMutex     // Held for reading by submitters, for writing to start and stop
+	queue    chan *submission // Waiting for verification, nil while stopped
+	verified chan *submission // Waiting to be pooled
+	workers  sync.WaitGroup   // Verification workers
+	batcher  sync.WaitGroup   // The goroutine pooling verified submissions
+}
+
+// SubmitTransaction queues a transaction for admission to the pool and
+// returns its pending result. Unlike AddTransaction it returns before the
+// signature is checked, so many submissions are verified in parallel and
+// pooled together. If callback is not nil it is called with the result,
+// from the pipeline, before the future completes; it must not block. When
+// the queue is full SubmitTransaction waits for room, and if ctx ends first
+// the returned future fails with the context error. While the pipeline is
+// not running, before Start or after Stop, the transaction is admitted
+// before SubmitTransaction returns, as by AddTransaction.
+func (bc *Blockchain) SubmitTransaction(ctx context.Context, tx transaction.Transaction, callback func(error)) *AdmissionFuture {
+	future := &AdmissionFuture{done: make(chan struct{}), callback: callback}
+	if err := ctx.Err(); err != nil {
+		future.complete(err)
+		return future
+	}
+
+	bc.admission.mu.RLock()
+	defer bc.admission.mu.RUnlock()
+	if bc.admission.queue == nil {
+		future.complete(bc.AddTransactionContext(ctx, tx))
+		return future
+	}
+	select {
+	case bc.admission.queue <- &submission{ctx: ctx, tx: tx, future: future}:
+	case <-ctx.Done():
+		future.complete(ctx.Err())
+	}
+	return future
+}
+
+// startAdmission starts the verification workers and the batcher
+func (bc *Blockchain) startAdmission() {
+	a := &bc.admission
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.queue = make(chan *submission, admissionQueueSize)
+	a.verified = make(chan *submission, admissionQueueSize)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		a.workers.Add(1)
+		go func(queue <-chan *submission, verified chan<- *submission) {
+			defer a.workers.Done()
+			bc.verifySubmissions(queue, verified)
+		}(a.queue, a.verified)
+	}
+	a.batcher.Add(1)
+	go func(verified <-chan *submission) {
+		defer a.batcher.Done()
+		bc.admitSubmissions(verified)
+	}(a.verified)
+}
+
+// stopAdmission stops the pipeline once every queued submission has been
+// admitted or rejected. Submitters waiting for room in the queue get in
+// before it is closed; later ones are admitted synchronously.
+func (bc *Blockchain) stopAdmission() {
+	a := &bc.admission
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.queue == nil {
+		return
+	}
+	close(a.queue)
+	a.workers.Wait()
+	close(a.verified)
+	a.batcher.Wait()
+	a.queue, a.verified = nil, nil
+}
+
+// verifySubmissions checks the signatures of queued submissions until the
+// queue is closed
+func (bc *Blockchain) verifySubmissions(queue <-chan *submission, verified chan<- *submission) {
+	for s := range queue {
+		s.err = bc.verifyTransaction(&s.tx)
+		verified <- s
+	}
+}
+
+// admitSubmissions pools verified submissions in batches of whatever has
+// arrived since the last batch, until verified is closed
+func (bc *Blockchain) admitSubmissions(verified <-chan *submission) {
+	batch := make([]*submission, 0, maxAdmissionBatch)
+	for s := range verified {
+		batch = append(batch[:0], s)
+	drain:
+		for len(batch) < maxAdmissionBatch {
+			select {
+			case s, ok := <-verified:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, s)
+			default:
+				break drain
+			}
+		}
+		bc.admitBatch(batch)
+	}
+}
+
+// admitBatch validates and pools a batch of verified submissions under one
+// write lock, in sender and nonce order so a sender's consecutive
+// transactions are admitted as executable, then reports each result
+func (bc *Blockchain) admitBatch(batch []*submission) {
+	sort.SliceStable(batch, func(i, j int) bool {
+		if batch[i].tx.From != batch[j].tx.From {
+			return batch[i].tx.From < batch[j].tx.From
+		}
+		return batch[i].tx.Nonce < batch[j].tx.Nonce
+	})
+
+	bc.mu.Lock()
+	for _, s := range batch {
+		if s.err == nil {
+			s.err = bc.admitTransaction(&s.tx)
+		}
+	}
+	bc.updateGauges()
+	bc.mu.Unlock()
+
+	admitted := 0
+	for _, s := range batch {
+		if s.err == nil {
+			admitted++
+			bc.announceTransaction(s.ctx, &s.tx)
+		}
+		bc.recordAdmission(s.ctx, &s.tx, s.err)
+		s.future.complete(s.err)
+	}
+	bc.logger.Debug("Transaction batch admitted", "size", len(batch), "admitted", admitted)
+}
@@ -0,0 +1,222 @@
+package blockchain
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/txpool"
+	"github.com/StupidBug/fabric-zkrollup/pkg/leaktest"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+)
+
+// admissionSenders are the genesis accounts the admission tests send from
+var admissionSenders = []string{
+	"0000000000000000000000000000000000000001",
+	"0000000000000000000000000000000000000002",
+	"0000000000000000000000000000000000000003",
+}
+
+// signedTransfers registers a key for every admission sender with bc and
+// returns n signed transfers spread over them, in nonce order per sender
+func signedTransfers(tb testing.TB, bc *Blockchain, n int) []transaction.Transaction {
+	tb.Helper()
+	keys := make([]*ecdsa.PrivateKey, len(admissionSenders))
+	for i, address := range admissionSenders {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			tb.Fatalf("Failed to generate key pair: %v", err)
+		}
+		keys[i] = key
+		bc.SetPublicKey(address, &key.PublicKey)
+	}
+
+	txs := make([]transaction.Transaction, n)
+	for i := range txs {
+		sender := i % len(admissionSenders)
+		txs[i] = transaction.Transaction{
+			From:  admissionSenders[sender],
+			To:    admissionSenders[(sender+1)%len(admissionSenders)],
+			Value: types.NewAmount(1),
+			Nonce: uint64(i / len(admissionSenders)),
+		}
+		if err := txs[i].SignTransaction(keys[sender]); err != nil {
+			tb.Fatalf("Failed to sign transaction: %v", err)
+		}
+		txs[i].Hash = txs[i].ComputeHash()
+	}
+	return txs
+}
+
+// newAdmissionChain returns a chain whose pool has room for n transactions,
+// all from one sender if need be. The admission pipeline is running until
+// the test ends, and no block is produced meanwhile.
+func newAdmissionChain(tb testing.TB, n int) *Blockchain {
+	tb.Helper()
+	bc := newTestBlockchain(tb)
+	bc.SetProver(nil)
+	cfg := txpool.DefaultConfig()
+	cfg.MaxSize = n + 1
+	cfg.MaxPerSender = n + 1
+	cfg.MaxQueued = n + 1
+	cfg.MaxNonceGap = uint64(n + 1)
+	if err := bc.SetPoolConfig(cfg); err != nil {
+		tb.Fatalf("Failed to set pool config: %v", err)
+	}
+	production := DefaultProductionConfig()
+	production.Interval = time.Hour
+	production.MaxTransactions = n + 1
+	production.MaxBytes = 1 << 30
+	production.MaxCost = (n + 1) * transaction.CostTransfer
+	if err := bc.SetProductionConfig(production); err != nil {
+		tb.Fatalf("Failed to set production config: %v", err)
+	}
+	if err := bc.Start(context.Background()); err != nil {
+		tb.Fatalf("Failed to start: %v", err)
+	}
+	tb.Cleanup(func() {
+		if err := bc.Stop(context.Background()); err != nil {
+			tb.Errorf("Failed to stop: %v", err)
+		}
+	})
+	return bc
+}
+
+func TestSubmitTransaction(t *testing.T) {
+	bc := newAdmissionChain(t, 30)
+	txs := signedTransfers(t, bc, 30)
+
+	// Submit concurrently and newest nonce first; every transaction is
+	// executable once all are in
+	var called int32
+	futures := make([]*AdmissionFuture, len(txs))
+	var wg sync.WaitGroup
+	for i := len(txs) - 1; i >= 0; i-- {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			futures[i] = bc.SubmitTransaction(context.Background(), txs[i], func(err error) {
+				if err == nil {
+					atomic.AddInt32(&called, 1)
+				}
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for i, future := range futures {
+		if err := future.Wait(ctx); err != nil {
+			t.Fatalf("Transaction %d was not admitted: %v", i, err)
+		}
+	}
+	if called != int32(len(txs)) {
+		t.Errorf("Expected %d callbacks, got %d", len(txs), called)
+	}
+	if pending := bc.GetPendingTransactions(); len(pending) != len(txs) {
+		t.Errorf("Expected %d executable transactions, got %d", len(txs), len(pending))
+	}
+
+	// Rejections come back through the future and the callback
+	forged := txs[0]
+	forged.Nonce = 10
+	var reason string
+	future := bc.SubmitTransaction(context.Background(), forged, func(err error) {
+		var rejected *RejectError
+		if errors.As(err, &rejected) {
+			reason = rejected.Reason
+		}
+	})
+	var rejected *RejectError
+	if err := future.Wait(ctx); !errors.As(err, &rejected) || rejected.Reason != RejectInvalidSignature {
+		t.Fatalf("Expected rejection %q, got %v", RejectInvalidSignature, err)
+	}
+	if reason != RejectInvalidSignature || future.Err() == nil {
+		t.Errorf("Expected the callback to see the rejection, got %q", reason)
+	}
+
+	// A submission whose context has ended is not queued
+	done, stop := context.WithCancel(context.Background())
+	stop()
+	if err := bc.SubmitTransaction(done, txs[0], nil).Err(); err != context.Canceled {
+		t.Errorf("Expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestAdmissionStop(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	bc := newAdmissionChain(t, 300)
+	txs := signedTransfers(t, bc, 300)
+
+	// Stop drains whatever is queued; no submission is left pending
+	futures := make([]*AdmissionFuture, len(txs))
+	for i := range txs {
+		futures[i] = bc.SubmitTransaction(context.Background(), txs[i], nil)
+	}
+	if err := bc.Stop(context.Background()); err != nil {
+		t.Fatalf("Failed to stop: %v", err)
+	}
+	for i, future := range futures {
+		select {
+		case <-future.Done():
+		default:
+			t.Fatalf("Transaction %d is still pending after Stop", i)
+		}
+		if err := future.Err(); err != nil {
+			t.Fatalf("Transaction %d was not admitted: %v", i, err)
+		}
+	}
+	leaktest.WaitForGoroutines(t, baseline)
+
+	// Once stopped, submissions are admitted before SubmitTransaction returns
+	late := txs[0]
+	late.Nonce = 1000
+	future := bc.SubmitTransaction(context.Background(), late, nil)
+	var rejected *RejectError
+	if err := future.Err(); !errors.As(err, &rejected) || rejected.Reason != RejectInvalidSignature {
+		t.Fatalf("Expected rejection %q, got %v", RejectInvalidSignature, err)
+	}
+	leaktest.WaitForGoroutines(t, baseline)
+}
+
+// BenchmarkAddTransaction measures admission one transaction at a time
+func BenchmarkAddTransaction(b *testing.B) {
+	bc := newAdmissionChain(b, b.N)
+	txs := signedTransfers(b, bc, b.N)
+
+	b.ResetTimer()
+	for i := range txs {
+		if err := bc.AddTransaction(txs[i]); err != nil {
+			b.Fatalf("Failed to add transaction: %v", err)
+		}
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "tx/s")
+}
+
+// BenchmarkSubmitTransaction measures admission through the pipeline, with
+// the signatures of concurrent submissions verified in parallel
+func BenchmarkSubmitTransaction(b *testing.B) {
+	bc := newAdmissionChain(b, b.N)
+	txs := signedTransfers(b, bc, b.N)
+
+	b.ResetTimer()
+	futures := make([]*AdmissionFuture, len(txs))
+	for i := range txs {
+		futures[i] = bc.SubmitTransaction(context.Background(), txs[i], nil)
+	}
+	for _, future := range futures {
+		if err := future.Wait(context.Background()); err != nil {
+			b.Fatalf("Failed to admit transaction: %v", err)
+		}
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "tx/s")
+}
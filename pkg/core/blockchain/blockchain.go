@@ -1,17 +1,28 @@
 package blockchain
 
 import (
+	"context"
 	"crypto/ecdsa"
+	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
 	"sort"
 	"sync"
 	"time"
 
 	"github.com/StupidBug/fabric-zkrollup/pkg/chaincode"
+	"github.com/StupidBug/fabric-zkrollup/pkg/clock"
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/indexer"
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/store"
 	"github.com/StupidBug/fabric-zkrollup/pkg/core/txpool"
 	"github.com/StupidBug/fabric-zkrollup/pkg/crypto"
+	"github.com/StupidBug/fabric-zkrollup/pkg/logging"
+	"github.com/StupidBug/fabric-zkrollup/pkg/metrics"
 	"github.com/StupidBug/fabric-zkrollup/pkg/types"
 	"github.com/StupidBug/fabric-zkrollup/pkg/types/block"
 	"github.com/StupidBug/fabric-zkrollup/pkg/types/state"
@@ -21,135 +32,475 @@ import (
 
 // Blockchain represents the blockchain
 type Blockchain struct {
-	mu         sync.RWMutex // protects blocks and autoBlock
-	blocks     []*block.Block
+	mu         sync.RWMutex   // protects blocks and state
+	produceMu  sync.Mutex     // serializes block production
+	blocks     []*block.Block // by height, nil below base
+	base       uint64         // height of the oldest block, above zero after a snapshot import
 	state      *state.State
 	txPool     *txpool.TxPool
 	merkleTree *crypto.MerkleTree // 当前区块的 Merkle 树
-	autoBlock  bool
+	lifecycle  lifecycle
+	admission  admission
+	events     *eventFeed
+	indexer    *indexer.Indexer
+	store      *store.Store // nil for an in-memory chain
+	submitter  chaincode.Submitter
+	forced     chaincode.ForcedQueue // nil while forced inclusion is off
+	// Forced-inclusion queue as last read, used while Fabric is unreachable
+	forcedSeen []chaincode.ForcedTransaction
+	prover     *zk.Prover // nil if this node does not prove
+	verifier   *zk.Prover // Holds the circuit keys proofs are checked against
+	production ProductionConfig
+	poolConfig txpool.Config
+	replaced   *replacements                    // Recently replaced pooled transactions
+	journal    *txpool.Journal                  // nil unless OpenPoolJournal was called
+	registered map[string]transaction.PublicKey // Keys registered with SetPublicKey
+	genesis    Genesis
+	logger     *slog.Logger
+	clock      clock.Clock // Source of block timestamps and production ticks
+	random     io.Reader   // Source of block signing randomness
+	// Key blocks produced by this node are signed with
+	sequencerKey *ecdsa.PrivateKey
+	// Parent state accounts of executed blocks awaiting their proof
+	proofInputs map[uint64][]zk.Account
+	// State diff of each block by height, nil for genesis, used to undo
+	// blocks and to answer queries at earlier heights
+	diffs []*state.Diff
 }
 
-// NewBlockchain creates a new blockchain instance
-func NewBlockchain() *Blockchain {
+// ChainTransaction is a confirmed transaction together with its position in
+// the chain
+type ChainTransaction struct {
+	Transaction transaction.Transaction
+	Height      uint64
+	Index       int
+}
+
+// NewBlockchain creates a new in-memory blockchain instance with the default
+// genesis, signing blocks with the development sequencer key
+func NewBlockchain() (*Blockchain, error) {
+	return NewBlockchainWithGenesis(DefaultGenesis(), nil)
+}
+
+// NewBlockchainWithStore opens the blockchain persisted in st with the default
+// genesis
+func NewBlockchainWithStore(st *store.Store) (*Blockchain, error) {
+	return NewBlockchainWithGenesis(DefaultGenesis(), st)
+}
+
+// NewBlockchainWithGenesis opens the blockchain starting at genesis that is
+// persisted in st, or an in-memory one if st is nil. An empty store is
+// initialized with a new genesis block; otherwise the stored blocks are
+// loaded and the account state is rebuilt by replaying them, starting from
+// the imported snapshot if the chain was bootstrapped from one. Until
+// SetSequencerKey is called, blocks are signed with the development
+// sequencer key.
+func NewBlockchainWithGenesis(genesis Genesis, st *store.Store) (*Blockchain, error) {
+	if genesis.SequencerKey == nil {
+		return nil, fmt.Errorf("genesis has no sequencer key")
+	}
+	bc, err := newBlockchain(genesis, st)
+	if err != nil {
+		return nil, err
+	}
+	bc.store = st
+
+	genesisBlock := bc.createGenesis()
+	if st == nil {
+		bc.blocks = append(bc.blocks, genesisBlock)
+		bc.diffs = append(bc.diffs, nil)
+		if err := bc.indexBlock(genesisBlock); err != nil {
+			return nil, fmt.Errorf("failed to index genesis block: %v", err)
+		}
+		bc.logger.Info("Genesis block created", "state_root", genesisBlock.Header.StateRoot)
+		return bc, nil
+	}
+
+	blocks, err := st.LoadBlocks()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load blocks: %v", err)
+	}
+	if len(blocks) == 0 {
+		if err := st.PutBlock(genesisBlock); err != nil {
+			return nil, fmt.Errorf("failed to persist genesis block: %v", err)
+		}
+		bc.blocks = append(bc.blocks, genesisBlock)
+		bc.diffs = append(bc.diffs, nil)
+		if err := bc.indexBlock(genesisBlock); err != nil {
+			return nil, fmt.Errorf("failed to index genesis block: %v", err)
+		}
+		bc.logger.Info("Genesis block created", "state_root", genesisBlock.Header.StateRoot)
+		return bc, nil
+	}
+
+	// Fully validate every stored block while replaying it into state. Blocks
+	// that were still executed when the node stopped have no proof yet and
+	// are proven again later.
+	if blocks[0].Header.Height == 0 {
+		if err := verifyGenesis(blocks[0], genesis); err != nil {
+			return nil, fmt.Errorf("invalid stored genesis block: %v", err)
+		}
+	} else if err := bc.loadSnapshot(blocks[0]); err != nil {
+		return nil, fmt.Errorf("invalid stored snapshot: %v", err)
+	}
+	if err := st.Get(keysName, &bc.registered); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to load registered keys: %v", err)
+	}
+	for address, pubKey := range bc.registered {
+		if bc.state.GetPublicKey(address) == nil {
+			bc.state.SetPublicKey(address, pubKey.ECDSA())
+		}
+	}
+	blocks[0].Status = transaction.StatusFinalized
+	bc.diffs = make([]*state.Diff, bc.base+1)
+	for i, b := range blocks[1:] {
+		if b.Status == transaction.StatusPending {
+			// Stored before finality was tracked
+			b.Status = transaction.StatusExecuted
+			if b.Proof != nil {
+				b.Status = transaction.StatusProven
+			}
+		}
+		diff := bc.state.Record(touchedAddresses(b))
+		if err := executeBlock(blocks[i], bc.state, b); err != nil {
+			return nil, fmt.Errorf("invalid stored block %d: %v", b.Header.Height, err)
+		}
+		bc.diffs = append(bc.diffs, diff)
+		// Blocks stored before diffs were persisted get them now
+		if _, err := st.GetDiff(b.Header.Height); os.IsNotExist(err) {
+			if err := st.PutDiff(b.Header.Height, diff); err != nil {
+				return nil, fmt.Errorf("failed to persist state diff %d: %v", b.Header.Height, err)
+			}
+		}
+		if b.Status != transaction.StatusExecuted {
+			if err := verifyProof(bc.verifier, blocks[i], b); err != nil {
+				return nil, fmt.Errorf("invalid stored block %d: %v", b.Header.Height, err)
+			}
+		}
+	}
+	bc.blocks = append(make([]*block.Block, bc.base), blocks...)
+
+	// Use the persisted index unless an entry is missing or unreadable
+	if err := bc.loadIndex(); err != nil {
+		bc.logger.Info("Rebuilding chain index", "blocks", len(blocks), "reason", err)
+		if err := bc.rebuildIndex(); err != nil {
+			return nil, fmt.Errorf("failed to rebuild index: %v", err)
+		}
+	}
+
+	bc.updateGauges()
+	tip := bc.blocks[len(bc.blocks)-1]
+	bc.logger.Info("Loaded chain", "blocks", len(blocks), "height", tip.Header.Height,
+		logging.Hash("hash", tip.ComputeHash()), "state_root", tip.Header.StateRoot)
+	return bc, nil
+}
+
+const (
+	// keysName is the store key of the keys registered with SetPublicKey
+	keysName = "keys"
+	// keysDir is the directory in the data directory that circuit keys are
+	// kept in unless the genesis brings a prover
+	keysDir = "circuits"
+)
+
+// newBlockchain creates a blockchain for genesis with empty state and no
+// blocks
+func newBlockchain(genesis Genesis, st *store.Store) (*Blockchain, error) {
+	prover, err := genesisProver(genesis, st)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create prover: %v", err)
+	}
 	bc := &Blockchain{
 		blocks:     make([]*block.Block, 0),
 		state:      state.NewState(),
-		txPool:     txpool.NewTxPool(),
 		merkleTree: crypto.NewMerkleTree(nil),
-		autoBlock:  false,
+		events:     newEventFeed(),
+		indexer:    indexer.NewIndexer(),
+		submitter:  chaincode.NewFabricSubmitter(chaincode.DefaultFabricConfig()),
+		prover:     prover,
+		verifier:   prover,
+		production: DefaultProductionConfig(),
+		poolConfig: txpool.DefaultConfig(),
+		replaced:   newReplacements(),
+		registered: make(map[string]transaction.PublicKey),
+		genesis:    genesis,
+		logger:     slog.Default(),
+		clock:      clock.Real(),
+		random:     rand.Reader,
+
+		sequencerKey: DevSequencerKey(),
+		proofInputs:  make(map[uint64][]zk.Account),
+	}
+	bc.txPool = bc.newTxPool()
+	return bc, nil
+}
+
+// genesisProver returns the prover of genesis, or one keeping its keys in
+// the data directory of st, or in memory if st is nil
+func genesisProver(genesis Genesis, st *store.Store) (*zk.Prover, error) {
+	if genesis.Prover != nil {
+		return genesis.Prover, nil
+	}
+	var keyDir string
+	if st != nil {
+		keyDir = filepath.Join(st.Dir(), keysDir)
 	}
+	return zk.NewProver(zk.BackendGroth16, keyDir)
+}
 
+// genesisAccounts returns the genesis accounts sorted by address
+func genesisAccounts() []zk.Account {
 	accounts := []zk.Account{
 		{
 			Address: "0000000000000000000000000000000000000001",
-			Balance: 1000000,
+			Balance: types.NewAmount(1000000),
 			Nonce:   0,
 		},
 		{
 			Address: "0000000000000000000000000000000000000002",
-			Balance: 500000,
+			Balance: types.NewAmount(500000),
 			Nonce:   0,
 		},
 		{
 			Address: "0000000000000000000000000000000000000003",
-			Balance: 300000,
+			Balance: types.NewAmount(300000),
 			Nonce:   0,
 		},
 	}
 	sort.Slice(accounts, func(i, j int) bool {
 		return accounts[i].Address < accounts[j].Address
 	})
+	return accounts
+}
 
-	// Set initial balances in state
-	for _, account := range accounts {
-		bc.state.SetBalance(account.Address, account.Balance)
+// newGenesisState returns the account state at genesis
+func newGenesisState(genesis Genesis) *state.State {
+	st := state.NewState()
+	for _, account := range genesisAccounts() {
+		st.SetBalance(account.Address, account.Balance)
 	}
+	st.SetSequencerKey(genesis.SequencerKey)
+	return st
+}
+
+// createGenesis sets the genesis balances in state and returns the genesis
+// block
+func (bc *Blockchain) createGenesis() *block.Block {
+	// Set initial balances in state
+	bc.state = newGenesisState(bc.genesis)
 
 	// Compute initial state root using zk package
-	stateRoot := zk.ComputeAccountMerkleRoot(accounts)
+	stateRoot := zk.ComputeAccountMerkleRoot(genesisAccounts())
 
-	// Create genesis block
-	genesisBlock := &block.Block{
+	// Create genesis block. The timestamp is fixed so that every node derives
+	// the same genesis hash.
+	return &block.Block{
 		Header: block.Header{
 			Version:          1,
 			PrevHash:         [32]byte{},
 			MerkleRoot:       [32]byte{},
 			StateRoot:        stateRoot,
-			Timestamp:        time.Now(),
+			Timestamp:        time.Unix(0, 0).UTC(),
 			Height:           0,
 			TransactionCount: 0,
+			Sequencer:        transaction.NewPublicKey(bc.genesis.SequencerKey),
 		},
 		Transactions: []transaction.Transaction{},
+		Status:       transaction.StatusFinalized,
+	}
+}
+
+// updateGauges sets the chain height and pool size metrics. The caller must
+// hold the lock.
+func (bc *Blockchain) updateGauges() {
+	if len(bc.blocks) > 0 {
+		metrics.ChainHeight.Set(float64(len(bc.blocks) - 1))
 	}
+	metrics.PoolSize.Set(float64(bc.txPool.Size()))
+}
 
-	// Add genesis block to blockchain
-	bc.blocks = append(bc.blocks, genesisBlock)
-	log.Printf("Genesis block created with state root: %s", stateRoot)
+// loadIndex restores the chain index from the entries persisted with the
+// blocks. The caller must hold the write lock.
+func (bc *Blockchain) loadIndex() error {
+	bc.indexer = indexer.NewIndexerFrom(bc.base)
+	for height := bc.base; height < uint64(len(bc.blocks)); height++ {
+		entry, err := bc.store.GetIndexEntry(height)
+		if err != nil {
+			return err
+		}
+		if err := bc.indexer.Apply(*entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	return bc
+// rebuildIndex indexes the blocks from scratch and persists the new index.
+// The caller must hold the write lock.
+func (bc *Blockchain) rebuildIndex() error {
+	bc.indexer = indexer.NewIndexerFrom(bc.base)
+	for _, b := range bc.blocks[bc.base:] {
+		if err := bc.indexBlock(b); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// AddTransaction adds a transaction to the transaction pool
+// indexBlock adds b to the chain index and persists its index entry, if
+// there is a store. The index can be rebuilt from the blocks, so a failure
+// to persist is only logged. The caller must hold the write lock.
+func (bc *Blockchain) indexBlock(b *block.Block) error {
+	entry := indexer.NewEntry(b)
+	if err := bc.indexer.Apply(entry); err != nil {
+		return err
+	}
+	if bc.store != nil {
+		if err := bc.store.PutIndexEntry(entry); err != nil {
+			bc.logger.Warn("Failed to persist index entry", "height", entry.Height, "err", err)
+		}
+	}
+	return nil
+}
+
+// AddTransaction adds a transaction to the transaction pool. A transaction
+// that is not admitted is reported with a RejectError.
 func (bc *Blockchain) AddTransaction(tx transaction.Transaction) error {
+	return bc.AddTransactionContext(context.Background(), tx)
+}
+
+// AddTransactionContext is AddTransaction for a transaction submitted as
+// part of ctx, such as an API request, whose log records it is tied to
+func (bc *Blockchain) AddTransactionContext(ctx context.Context, tx transaction.Transaction) error {
+	err := bc.addTransaction(ctx, &tx)
+	bc.recordAdmission(ctx, &tx, err)
+	return err
+}
+
+// recordAdmission counts the outcome of a submission and logs a rejection
+func (bc *Blockchain) recordAdmission(ctx context.Context, tx *transaction.Transaction, err error) {
+	var rejected *RejectError
+	switch {
+	case err == nil:
+		metrics.TxAdmitted.Inc()
+	case errors.As(err, &rejected):
+		metrics.TxRejected.WithLabelValues(rejected.Reason).Inc()
+		bc.logger.DebugContext(ctx, "Transaction rejected", logging.Hash("tx", tx.Hash),
+			"from", tx.From, "nonce", tx.Nonce, "reason", rejected.Reason, "err", err)
+	}
+}
+
+// addTransaction verifies a transaction and adds it to the pool
+func (bc *Blockchain) addTransaction(ctx context.Context, tx *transaction.Transaction) error {
+	if err := bc.verifyTransaction(tx); err != nil {
+		return err
+	}
+
+	// Validate balance and nonce and add to the pool under one write lock, so
+	// a block committed in between cannot leave a stale transaction behind
+	bc.mu.Lock()
+	err := bc.admitTransaction(tx)
+	bc.updateGauges()
+	bc.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	bc.announceTransaction(ctx, tx)
+	return nil
+}
+
+// verifyTransaction runs the checks of a transaction that do not depend on
+// the pool: that it is new and correctly signed by its sender. The hash is
+// recomputed from the signed fields, so that copies of a transaction are
+// recognized whatever hash they arrive with. It takes the read lock only
+// briefly and may run concurrently with other verifications.
+func (bc *Blockchain) verifyTransaction(tx *transaction.Transaction) error {
+	// Turn away replays before the costlier checks
+	tx.Hash = tx.ComputeHash()
+	if err := bc.checkKnown(tx.Hash); err != nil {
+		return err
+	}
+
 	// Verify transaction signature first
 	if tx.Signature.R == nil || tx.Signature.S == nil {
-		log.Printf("Transaction missing signature - R: %v, S: %v", tx.Signature.R, tx.Signature.S)
-		return fmt.Errorf("missing signature")
+		return rejectf(RejectMissingSignature, "missing signature")
 	}
 
 	// Verify signature values are in valid range
 	if tx.Signature.R.Sign() <= 0 || tx.Signature.S.Sign() <= 0 {
-		log.Printf("Invalid signature values - R: %s, S: %s", tx.Signature.R.String(), tx.Signature.S.String())
-		return fmt.Errorf("invalid signature values")
+		return rejectf(RejectInvalidSignature, "invalid signature values")
 	}
 
 	// Get sender's public key - acquire read lock
 	bc.mu.RLock()
-	senderPubKey := bc.state.GetPublicKey(tx.From)
+	senderPubKey := senderKey(bc.state, tx)
 	bc.mu.RUnlock()
 
 	if senderPubKey == nil {
-		log.Printf("Public key not found for sender %s", tx.From)
-		return fmt.Errorf("public key not found for sender %s", tx.From)
+		return rejectf(RejectUnknownSender, "public key not found for sender %s", tx.From)
 	}
-	log.Printf("Found public key for sender %s: X=%s, Y=%s", tx.From,
-		senderPubKey.X.String(), senderPubKey.Y.String())
 
 	// Verify signature
-	txHash := tx.ComputeHash()
-	log.Printf("Transaction hash for verification: %x", txHash)
-	log.Printf("Signature values - R: %s, S: %s", tx.Signature.R.String(), tx.Signature.S.String())
-
 	if !tx.VerifySignature(senderPubKey) {
-		log.Printf("Signature verification failed for transaction %x", txHash)
-		return fmt.Errorf("invalid signature")
+		return rejectf(RejectInvalidSignature, "invalid signature")
 	}
+	tx.PublicKey = transaction.NewPublicKey(senderPubKey)
+	return nil
+}
 
-	// Get current balance and nonce - acquire read lock
-	bc.mu.RLock()
-	senderBalance := bc.state.GetBalance(tx.From)
-	expectedNonce := bc.state.GetNonce(tx.From)
-	bc.mu.RUnlock()
-
-	// Validate balance and nonce
-	if senderBalance < tx.Value {
-		log.Printf("Insufficient balance - Required: %d, Available: %d",
-			tx.Value, senderBalance)
-		return fmt.Errorf("insufficient balance")
+// admitTransaction validates a verified transaction against the pending
+// state and adds it to the pool, reporting the transactions it displaces.
+// The caller must hold the write lock and update the gauges.
+func (bc *Blockchain) admitTransaction(tx *transaction.Transaction) error {
+	if err := bc.validateTransaction(tx); err != nil {
+		return err
 	}
-
-	if tx.Nonce != expectedNonce {
-		log.Printf("Invalid nonce - Expected: %d, Got: %d",
-			expectedNonce, tx.Nonce)
-		return fmt.Errorf("invalid nonce: expected %d, got %d", expectedNonce, tx.Nonce)
+	replaced, evicted, err := bc.txPool.Add(*tx)
+	switch {
+	case errors.Is(err, txpool.ErrAlreadyKnown):
+		// A copy submitted concurrently got in first
+		return &RejectError{Reason: RejectAlreadyKnown, Err: err}
+	case errors.Is(err, txpool.ErrUnderpriced):
+		return &RejectError{Reason: RejectUnderpriced, Err: fmt.Errorf("%v: fee must exceed %s by %d%%", err,
+			bc.txPool.GetByNonce(tx.From, tx.Nonce).Fee, bc.poolConfig.PriceBump)}
+	case errors.Is(err, txpool.ErrPoolFull):
+		return &RejectError{Reason: RejectPoolFull, Err: fmt.Errorf("%v and no pooled transaction pays a lower fee", err)}
+	case errors.Is(err, txpool.ErrSenderFull), errors.Is(err, txpool.ErrQueueFull):
+		return &RejectError{Reason: RejectSenderLimit, Err: err}
+	case errors.Is(err, txpool.ErrNonceTooHigh):
+		return &RejectError{Reason: RejectInvalidNonce, Err: err}
+	case errors.Is(err, txpool.ErrFeeTooLow):
+		return &RejectError{Reason: RejectFeeTooLow, Err: fmt.Errorf("%v of %s", err, bc.poolConfig.MinFee)}
 	}
+	height := uint64(len(bc.blocks) - 1)
+	if replaced != nil {
+		bc.replaced.add(*replaced, tx.Hash)
+		bc.reportDropped(dropped([]transaction.Transaction{*replaced}, DropReplaced), height, "transaction replaced by a higher fee")
+	}
+	bc.reportDropped(dropped(evicted, DropEvicted), height, "transaction evicted by a higher fee")
+	return nil
+}
 
-	// Add to transaction pool - acquire write lock
-	bc.mu.Lock()
-	bc.txPool.Add(tx)
-	bc.mu.Unlock()
+// announceTransaction tells subscribers about a newly pooled transaction
+func (bc *Blockchain) announceTransaction(ctx context.Context, tx *transaction.Transaction) {
+	bc.events.send(Event{Type: EventNewPendingTx, Transaction: tx})
+	bc.logger.DebugContext(ctx, "Transaction added to pool", logging.Hash("tx", tx.Hash),
+		"from", tx.From, "nonce", tx.Nonce)
+}
 
-	log.Printf("Added transaction %s to pool", tx.String())
+// checkKnown rejects a transaction that is already pooled or included in a
+// block
+func (bc *Blockchain) checkKnown(hash [32]byte) error {
+	if bc.txPool.Has(hash) {
+		return rejectf(RejectAlreadyKnown, "transaction %x is already pooled", hash)
+	}
+
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	if loc, ok := bc.indexer.TxLocation(hash); ok {
+		return rejectf(RejectAlreadyKnown, "transaction %x is already included in block %d", hash, loc.Height)
+	}
 	return nil
 }
 
@@ -160,31 +511,75 @@ func (bc *Blockchain) GetTransactionByHash(hash [32]byte) *transaction.Transacti
 		return tx
 	}
 
-	// Then check the blocks
+	// Then look up its location in the index
 	bc.mu.RLock()
 	defer bc.mu.RUnlock()
 
-	for _, block := range bc.blocks {
-		for _, tx := range block.Transactions {
-			if tx.Hash == hash {
-				txCopy := tx
-				return &txCopy
-			}
+	loc, ok := bc.indexer.TxLocation(hash)
+	if !ok || loc.Height >= uint64(len(bc.blocks)) {
+		// Finally look among recently replaced transactions
+		if r, ok := bc.replaced.get(hash); ok {
+			return &r.tx
 		}
+		return nil
 	}
+	txCopy := bc.blocks[loc.Height].Transactions[loc.Index]
+	return &txCopy
+}
 
-	return nil
+// ReplacedBy returns the hash of the transaction that replaced the pooled
+// transaction with the given hash, if it was replaced recently
+func (bc *Blockchain) ReplacedBy(hash [32]byte) ([32]byte, bool) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	r, ok := bc.replaced.get(hash)
+	return r.by, ok
+}
+
+// GetAddressTransactions returns a page of confirmed transactions sent or
+// received by address, newest first, and the total number of such
+// transactions
+func (bc *Blockchain) GetAddressTransactions(address string, offset, limit int) ([]ChainTransaction, int) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	locs, total := bc.indexer.AddressTransactions(address, offset, limit)
+	txs := make([]ChainTransaction, 0, len(locs))
+	for _, loc := range locs {
+		if loc.Height >= uint64(len(bc.blocks)) {
+			continue
+		}
+		txs = append(txs, ChainTransaction{
+			Transaction: bc.blocks[loc.Height].Transactions[loc.Index],
+			Height:      loc.Height,
+			Index:       loc.Index,
+		})
+	}
+	return txs, total
+}
+
+// GetBlockByHash returns the block with the given hash
+func (bc *Blockchain) GetBlockByHash(hash [32]byte) (*block.Block, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	height, ok := bc.indexer.BlockHeight(hash)
+	if !ok || height >= uint64(len(bc.blocks)) {
+		return nil, fmt.Errorf("block not found with hash %x", hash)
+	}
+	return bc.blocks[height], nil
 }
 
 // GetBalance returns the balance of an address
-func (bc *Blockchain) GetBalance(address string) int {
+func (bc *Blockchain) GetBalance(address string) types.Amount {
 	bc.mu.RLock()
 	defer bc.mu.RUnlock()
 	return bc.state.GetBalance(address)
 }
 
 // SetBalance is now private and only used during genesis block creation
-func (bc *Blockchain) setBalance(address string, balance int) error {
+func (bc *Blockchain) setBalance(address string, balance types.Amount) error {
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
 
@@ -203,7 +598,7 @@ func (bc *Blockchain) setBalance(address string, balance int) error {
 
 	// Set balance in state
 	bc.state.SetBalance(address, balance)
-	log.Printf("Set balance for address %s to %d", address, balance)
+	bc.logger.Debug("Set genesis balance", "address", address, "balance", balance)
 	return nil
 }
 
@@ -214,6 +609,14 @@ func (bc *Blockchain) GetNonce(address string) uint64 {
 	return bc.state.GetNonce(address)
 }
 
+// GetPendingNonce returns the next nonce for an address, counting the
+// sender's transactions that are still in the pool
+func (bc *Blockchain) GetPendingNonce(address string) uint64 {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.txPool.PendingNonce(address, bc.state.GetNonce(address))
+}
+
 // GetStateRoot returns the current state root
 func (bc *Blockchain) GetStateRoot() string {
 	bc.mu.RLock()
@@ -236,201 +639,548 @@ func (bc *Blockchain) GetLatestBlock() *block.Block {
 	return bc.blocks[len(bc.blocks)-1]
 }
 
-// CreateBlock creates a new block with transactions from the pool
+// CreateBlock creates a new block with transactions from the pool.
+//
+// The block is executed and appended to the chain as soon as it is built, and
+// then proven and submitted to Fabric. Proving runs without holding the chain
+// lock, so transactions keep arriving in the pool meanwhile. Only the
+// transactions included in the block are removed from the pool; the rest are
+// re-validated against the new state and stay in the pool for the next block.
 func (bc *Blockchain) CreateBlock() error {
-	// Get pending transactions without any lock
-	transactions := bc.txPool.GetAll()
+	bc.produceMu.Lock()
+	defer bc.produceMu.Unlock()
+
+	// Blocks left behind by an earlier failure go first, as Fabric needs the
+	// proofs in order
+	if err := bc.advanceFinality(); err != nil {
+		bc.logger.Warn("Earlier blocks are not final yet", "err", err)
+	}
+
+	// Pick executable transactions, highest fee first, up to the block
+	// limits. The pool keeps each sender's transactions in nonce order.
+	// Transactions forced through Fabric go ahead of the pool.
+	forced := bc.forcedTransactions()
+	bc.mu.RLock()
+	production := bc.production
+	transactions := selectTransactions(bc.txPool.Best(bc.txPool.Size()), production)
+	if pending := bc.pendingForced(forced, uint64(len(bc.blocks))); len(pending) > 0 {
+		transactions = packForced(bc.state.Clone(), pending, transactions, production)
+	}
+	bc.mu.RUnlock()
 	if len(transactions) == 0 {
-		log.Printf("No transactions in pool to create block")
 		return fmt.Errorf("no transactions to create block")
 	}
+	// The proof covers the transfers, so a block needs at least one;
+	// governance transactions wait for the next transfer
+	if len(zkTransactions(transactions)) == 0 {
+		return fmt.Errorf("no transfers to create block")
+	}
 
-	log.Printf("Creating new block with %d transactions", len(transactions))
+	start := time.Now()
 
-	// Get previous block hash with read lock
+	// Get previous block hash and parent state with read lock
 	bc.mu.RLock()
 	prevHash := [32]byte{}
 	blockHeight := uint64(len(bc.blocks))
 	if len(bc.blocks) > 0 {
 		prevHash = bc.blocks[len(bc.blocks)-1].ComputeHash()
 	}
+	accounts := zkAccounts(bc.state)
+	newState := bc.state.Clone()
+	sequencerKey := bc.sequencerKey
+	now, random := bc.clock.Now(), bc.random
 	bc.mu.RUnlock()
 
+	// Only the authorized sequencer can produce blocks that others accept
+	if authorized := newState.GetSequencerKey(); !transaction.NewPublicKey(authorized).Equal(transaction.NewPublicKey(&sequencerKey.PublicKey)) {
+		return fmt.Errorf("sequencer key %x is not authorized, expected %x",
+			transaction.NewPublicKey(&sequencerKey.PublicKey).Bytes(), transaction.NewPublicKey(authorized).Bytes())
+	}
+
 	// Create new block (no lock needed)
 	block := &block.Block{
 		Header: block.Header{
 			Version:          1,
 			PrevHash:         prevHash,
-			Timestamp:        time.Now(),
+			Timestamp:        now.UTC(),
 			Height:           blockHeight,
 			TransactionCount: uint32(len(transactions)),
 		},
 		Transactions: transactions,
+		Status:       transaction.StatusExecuted,
 	}
 
 	// Calculate Merkle root (no lock needed)
 	merkleTree := crypto.CreateMerkleTreeFromTransactions(transactions)
 	block.Header.MerkleRoot = merkleTree.GetRoot()
-	log.Printf("Calculated Merkle root: %x", block.Header.MerkleRoot)
 
-	log.Printf("applyTransactions: %d", len(transactions))
-	// Apply transactions and update state
-	if newStateRoot, err := bc.applyTransactions(block); err != nil {
+	// Execute the transactions on a copy of the state
+	if err := executeTransactions(newState, transactions); err != nil {
 		return fmt.Errorf("failed to apply transactions: %v", err)
-	} else {
-		// Update state root
-		block.Header.StateRoot = newStateRoot
+	}
+	block.Header.StateRoot = computeStateRoot(newState)
+
+	// The block is part of the chain before it is proven, so check first
+	// that the circuit can prove it; otherwise it would stay executed
+	root, err := zk.ComputeNewStateRoot(zk.ProofInput{Accounts: accounts, Transactions: zkTransactions(transactions)})
+	if err != nil {
+		return fmt.Errorf("block cannot be proven: %v", err)
+	}
+	if root != block.Header.StateRoot {
+		return fmt.Errorf("block cannot be proven: circuit state root %s does not match block state root %s", root, block.Header.StateRoot)
+	}
+
+	if err := block.SignWith(sequencerKey, random); err != nil {
+		return err
 	}
 
-	// Add block to chain
+	bc.mu.Lock()
+	err = bc.commitBlock(block, true)
+	if err == nil {
+		bc.proofInputs[blockHeight] = accounts
+	}
+	bc.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	elapsed := time.Since(start)
+	metrics.BlockProduction.Observe(elapsed.Seconds())
+	logger := bc.logger.With("height", blockHeight, logging.Hash("hash", block.ComputeHash()))
+	logger.Info("Block produced", "txs", len(transactions), "state_root", block.Header.StateRoot,
+		"duration", elapsed)
+
+	// Prove the new block and submit it to Fabric. The block is already part
+	// of the chain, so a failure here only delays its finality.
+	if err := bc.advanceFinality(); err != nil {
+		logger.Warn("Block is not final yet", "err", err)
+	}
+	return nil
+}
+
+// ImportBlock fully validates a block produced elsewhere, such as by a
+// sequencer this node follows, and appends it to the chain. The block must
+// extend the current tip.
+func (bc *Blockchain) ImportBlock(block *block.Block) error {
+	bc.produceMu.Lock()
+	defer bc.produceMu.Unlock()
+
+	if block.Header.Height == 0 {
+		return fmt.Errorf("cannot import genesis block")
+	}
+	forced := bc.forcedTransactions()
+
+	// Verify the proof, the costliest check, without blocking readers
+	bc.mu.RLock()
+	height := uint64(len(bc.blocks))
+	if block.Header.Height != height {
+		bc.mu.RUnlock()
+		return fmt.Errorf("cannot import block %d: expected height %d", block.Header.Height, height)
+	}
+	parent := bc.blocks[height-1]
+	verifier := bc.verifier
+	bc.mu.RUnlock()
+	if err := verifyProof(verifier, parent, block); err != nil {
+		return fmt.Errorf("invalid block %d: %v", block.Header.Height, err)
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	// The tip may have moved while the proof was verified
+	if uint64(len(bc.blocks)) != height || bc.blocks[height-1] != parent {
+		return fmt.Errorf("cannot import block %d: chain tip changed", block.Header.Height)
+	}
+	st := bc.state.Clone()
+	if err := executeBlock(parent, st, block); err != nil {
+		return fmt.Errorf("invalid block %d: %v", block.Header.Height, err)
+	}
+	if err := checkForced(st, bc.pendingForced(forced, height), block); err != nil {
+		return fmt.Errorf("invalid block %d: %v", block.Header.Height, err)
+	}
+
+	// Fabric finality is tracked by the producer; locally the block is proven
+	block.Status = transaction.StatusProven
+	if err := bc.commitBlock(block, false); err != nil {
+		return err
+	}
+	bc.logger.Debug("Block imported", "height", block.Header.Height, logging.Hash("hash", block.ComputeHash()),
+		"txs", len(block.Transactions))
+	return nil
+}
+
+// commitBlock persists a validated block, applies it to the state, appends it
+// to the chain and updates the pool. A block produced here is announced as
+// sealed once it is part of the chain, ahead of its transactions. The caller
+// must hold the write lock.
+func (bc *Blockchain) commitBlock(block *block.Block, produced bool) error {
+	blockHeight := block.Header.Height
+
+	// Persist the block and its state diff before they become visible
+	for i := range block.Transactions {
+		block.Transactions[i].Status = block.Status
+	}
+	diff := bc.state.Record(touchedAddresses(block))
+	if bc.store != nil {
+		if err := bc.store.PutDiff(blockHeight, diff); err != nil {
+			return fmt.Errorf("failed to persist state diff: %v", err)
+		}
+		if err := bc.store.PutBlock(block); err != nil {
+			return fmt.Errorf("failed to persist block: %v", err)
+		}
+	}
+
+	// Apply transactions, then add block to chain and index
+	bc.diffs = append(bc.diffs, diff)
+	bc.applyTransactions(block)
 	bc.blocks = append(bc.blocks, block)
+	if err := bc.indexBlock(block); err != nil {
+		bc.logger.Warn("Failed to index block", "height", blockHeight, "err", err)
+	}
 
-	// Clear processed transactions from pool
-	bc.txPool.Clear()
+	// Remove exactly the included transactions from the pool
+	included := make([][32]byte, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		included[i] = tx.Hash
+	}
+	bc.txPool.RemoveAll(included)
+
+	if produced {
+		bc.events.send(Event{Type: EventBlockSealed, Height: blockHeight, Block: block})
+	}
+	for i := range block.Transactions {
+		tx := block.Transactions[i]
+		bc.events.send(Event{Type: EventTxConfirmed, Height: blockHeight, Block: block, Transaction: &tx})
+	}
+
+	// Drop transactions that arrived during proving but are no longer valid
+	bc.reportDropped(bc.revalidatePool(), blockHeight,
+		fmt.Sprintf("transaction no longer valid after block %d", blockHeight))
+	bc.updateGauges()
 
 	return nil
 }
 
-// VerifyBlock verifies a block's integrity
+// GetBlocksFrom returns up to limit proven blocks starting at height. Blocks
+// still waiting for their proof are left out, so that followers can verify
+// every block they receive.
+func (bc *Blockchain) GetBlocksFrom(height uint64, limit int) []*block.Block {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if height < bc.base {
+		height = bc.base
+	}
+	blocks := []*block.Block{}
+	for h := height; h < uint64(len(bc.blocks)) && len(blocks) < limit; h++ {
+		if bc.blocks[h].Status == transaction.StatusExecuted {
+			break
+		}
+		blocks = append(blocks, bc.blocks[h])
+	}
+	return blocks
+}
+
+// VerifyBlock fully validates a block against its parent. It replays the
+// block on a copy of the parent state, checking every signature, nonce and
+// balance, recomputes the state root, checks that no overdue forced
+// transaction is left out and checks the stored proof against the block's
+// public inputs.
 func (bc *Blockchain) VerifyBlock(block *block.Block) error {
-	// Verify block header
 	if block.Header.Height == 0 {
-		// Genesis block has no previous hash
-		if block.Header.PrevHash != [32]byte{} {
-			return fmt.Errorf("genesis block must have empty previous hash")
-		}
-	} else {
-		// Non-genesis blocks must have valid previous hash
-		bc.mu.RLock()
-		prevBlock := bc.blocks[block.Header.Height-1]
+		return verifyGenesis(block, bc.genesis)
+	}
+	forced := bc.forcedTransactions()
+
+	bc.mu.RLock()
+	height := uint64(len(bc.blocks))
+	if block.Header.Height > height {
 		bc.mu.RUnlock()
-		if block.Header.PrevHash != prevBlock.Header.ComputeHash() {
-			return fmt.Errorf("invalid previous block hash")
-		}
+		return fmt.Errorf("block %d is ahead of chain height %d", block.Header.Height, height)
+	}
+	if block.Header.Height <= bc.base {
+		bc.mu.RUnlock()
+		return fmt.Errorf("block %d cannot be verified: chain starts at snapshot height %d", block.Header.Height, bc.base)
+	}
+	parent := bc.blocks[block.Header.Height-1]
+	var parentState *state.State
+	if block.Header.Height == height {
+		parentState = bc.state.Clone()
+	} else {
+		parentState = bc.stateAt(block.Header.Height - 1)
+	}
+	pending := bc.pendingForced(forced, block.Header.Height)
+	verifier := bc.verifier
+	bc.mu.RUnlock()
+
+	// Executing the block turns the parent state into its post-state
+	if err := executeBlock(parent, parentState, block); err != nil {
+		return err
+	}
+	if err := checkForced(parentState, pending, block); err != nil {
+		return err
+	}
+	return verifyProof(verifier, parent, block)
+}
+
+// stateAt rebuilds the account state after the block at height by undoing
+// the later blocks on a copy of the current state. Height must not be below
+// the base. The caller must hold the read lock.
+func (bc *Blockchain) stateAt(height uint64) *state.State {
+	st := bc.state.Clone()
+	for h := uint64(len(bc.blocks)) - 1; h > height; h-- {
+		st.Revert(bc.diffs[h])
+	}
+	return st
+}
+
+// verifyGenesis checks a genesis block against the genesis accounts and the
+// pinned sequencer key
+func verifyGenesis(block *block.Block, genesis Genesis) error {
+	if block.Header.PrevHash != [32]byte{} {
+		return fmt.Errorf("genesis block must have empty previous hash")
+	}
+	if len(block.Transactions) != 0 || block.Header.TransactionCount != 0 {
+		return fmt.Errorf("genesis block must not contain transactions")
+	}
+	if stateRoot := zk.ComputeAccountMerkleRoot(genesisAccounts()); block.Header.StateRoot != stateRoot {
+		return fmt.Errorf("genesis state root mismatch: expected %s, got %s", stateRoot, block.Header.StateRoot)
+	}
+	if !block.Header.Sequencer.Equal(transaction.NewPublicKey(genesis.SequencerKey)) {
+		return fmt.Errorf("genesis sequencer key mismatch")
+	}
+	return nil
+}
+
+// executeBlock validates block as the child of parent and applies its
+// transactions to st, which must be the state after parent. The proof is
+// checked separately by verifyProof.
+func executeBlock(parent *block.Block, st *state.State, block *block.Block) error {
+	// Verify block header
+	if block.Header.Height != parent.Header.Height+1 {
+		return fmt.Errorf("invalid block height: expected %d, got %d", parent.Header.Height+1, block.Header.Height)
+	}
+	if block.Header.PrevHash != parent.ComputeHash() {
+		return fmt.Errorf("invalid previous block hash")
 	}
 
 	// Verify transaction count
 	if uint32(len(block.Transactions)) != block.Header.TransactionCount {
 		return fmt.Errorf("transaction count mismatch")
 	}
+	if len(block.Transactions) == 0 {
+		return fmt.Errorf("block contains no transactions")
+	}
+	if len(zkTransactions(block.Transactions)) == 0 {
+		return fmt.Errorf("block contains no transfers")
+	}
+
+	// Verify the producer against the sequencer key as of the parent
+	if err := checkSequencer(st, block); err != nil {
+		return err
+	}
 
 	// Verify Merkle root
 	if !crypto.VerifyTransactionMerkleRoot(block.Transactions, block.Header.MerkleRoot) {
 		return fmt.Errorf("merkle root mismatch")
 	}
 
-	return nil
-}
-
-// StartAutoBlock starts automatic block creation
-func (bc *Blockchain) StartAutoBlock() {
-	bc.mu.Lock()
-	if bc.autoBlock {
-		bc.mu.Unlock()
-		return
+	// Re-execute every transaction
+	if err := executeTransactions(st, block.Transactions); err != nil {
+		return err
 	}
-	bc.autoBlock = true
-	bc.mu.Unlock()
-
-	go func() {
-		log.Println("Starting auto block creation goroutine")
-		ticker := time.NewTicker(1 * time.Second) // Create block every 1 second
-		defer ticker.Stop()
 
-		checkAndCreateBlock := func() {
-			// Check if auto block is still enabled
-			bc.mu.RLock()
-			autoBlockEnabled := bc.autoBlock
-			bc.mu.RUnlock()
+	// Verify state root
+	if stateRoot := computeStateRoot(st); block.Header.StateRoot != stateRoot {
+		return fmt.Errorf("state root mismatch: expected %s, got %s", stateRoot, block.Header.StateRoot)
+	}
 
-			if !autoBlockEnabled {
-				log.Println("Auto block creation stopped")
-				return
-			}
+	return nil
+}
 
-			// Check pool size without holding the lock
-			poolSize := bc.txPool.Size()
-			if poolSize > 0 {
-				log.Printf("Auto block creation triggered with %d transactions in pool", poolSize)
-				if err := bc.CreateBlock(); err != nil {
-					log.Printf("Error creating block: %v", err)
-				} else {
-					log.Printf("Successfully created new block with %d transactions", poolSize)
-				}
-			} else {
-				log.Printf("No transactions in pool during auto block creation")
-			}
+// executeTransactions checks every transaction's hash and signature and
+// applies it to st
+func executeTransactions(st *state.State, txs []transaction.Transaction) error {
+	for i := range txs {
+		tx := &txs[i]
+		if tx.Hash != tx.ComputeHash() {
+			return fmt.Errorf("transaction %d hash mismatch", i)
+		}
+		if err := checkGovernanceSigner(st, tx); err != nil {
+			return fmt.Errorf("transaction %x: %v", tx.Hash, err)
+		}
+		pubKey := senderKey(st, tx)
+		if pubKey == nil {
+			return fmt.Errorf("transaction %x has no public key for sender %s", tx.Hash, tx.From)
 		}
+		if !tx.VerifySignature(pubKey) {
+			return fmt.Errorf("transaction %x has an invalid signature", tx.Hash)
+		}
+		if err := checkState(st, tx); err != nil {
+			return fmt.Errorf("transaction %x: %v", tx.Hash, err)
+		}
+		applyTransaction(st, tx)
+		if tx.Type == transaction.TypeTransfer && st.GetPublicKey(tx.From) == nil {
+			st.SetPublicKey(tx.From, pubKey)
+		}
+	}
+	return nil
+}
 
-		// Start a goroutine to monitor transaction pool
-		go func() {
-			for {
-				time.Sleep(100 * time.Millisecond) // Check every 100ms
+// verifyProof checks the proof of block, the child of parent, against the
+// block's public inputs and the circuit keys of verifier
+func verifyProof(verifier *zk.Prover, parent *block.Block, block *block.Block) error {
+	if block.Proof == nil {
+		return fmt.Errorf("block has no proof")
+	}
+	if block.Proof.OldStateRoot != parent.Header.StateRoot {
+		return fmt.Errorf("proof old state root does not match parent state root")
+	}
+	return checkProof(verifier, block)
+}
 
-				bc.mu.RLock()
-				autoBlockEnabled := bc.autoBlock
-				bc.mu.RUnlock()
+// checkProof checks the proof of block against the public inputs the block
+// itself determines, leaving out the parent state root
+func checkProof(verifier *zk.Prover, block *block.Block) error {
+	if block.Proof == nil {
+		return fmt.Errorf("block has no proof")
+	}
+	if block.Proof.NewStateRoot != block.Header.StateRoot {
+		return fmt.Errorf("proof new state root does not match block state root")
+	}
+	transfers := zkTransactions(block.Transactions)
+	governance := zkGovernance(block.Transactions)
+	batchRoot, err := zk.ComputeBatchRoot(transfers, governance)
+	if err != nil {
+		return err
+	}
+	if block.Proof.BatchRoot != batchRoot {
+		return fmt.Errorf("proof batch root does not match block transactions")
+	}
+	start := time.Now()
+	err = verifier.VerifyProof(block.Proof, len(transfers), len(governance))
+	metrics.ProofVerification.Observe(time.Since(start).Seconds())
+	if err != nil {
+		return err
+	}
 
-				if !autoBlockEnabled {
-					return
-				}
+	return nil
+}
 
-				if bc.txPool.Size() > 1 {
-					checkAndCreateBlock()
-				}
-			}
-		}()
+// validateTransaction validates a transaction against the sender's pending
+// nonce and balance, so several transactions from one sender can be queued
+// before a block is produced
+func (bc *Blockchain) validateTransaction(transaction *transaction.Transaction) error {
+	// Note: This function assumes the caller holds appropriate locks
+	if err := checkLimits(transaction, bc.production); err != nil {
+		return err
+	}
+	expectedNonce := bc.txPool.PendingNonce(transaction.From, bc.state.GetNonce(transaction.From))
+	senderBalance := bc.txPool.PendingBalance(transaction.From, bc.state.GetBalance(transaction.From))
+	if pooled := bc.txPool.GetByNonce(transaction.From, transaction.Nonce); pooled != nil {
+		// A replacement takes the nonce of the pooled transaction and frees
+		// up its value and fee; the pool checks that it pays enough more
+		expectedNonce = transaction.Nonce
+		// Bounded by the confirmed balance, which the pooled value and fee
+		// were debited from
+		freed, err := pooled.Value.Add(pooled.Fee)
+		if err == nil {
+			senderBalance, err = senderBalance.Add(freed)
+		}
+		if err != nil {
+			return rejectf(RejectBalanceOverflow, "sender balance overflow: %v", err)
+		}
+	} else if transaction.Nonce > expectedNonce {
+		// Future transactions wait in the sender's queue until the gap is
+		// filled
+		expectedNonce = transaction.Nonce
+	}
+	return checkTransaction(bc.state, transaction, expectedNonce, senderBalance)
+}
 
-		// Main loop for timed block creation
-		for {
-			select {
-			case <-ticker.C:
-				checkAndCreateBlock()
-			}
+// revalidatePool drops pooled transactions that no longer apply on top of the
+// current state, rebuilding the pending view in each sender's nonce order.
+// Transactions behind a nonce gap stay queued as long as the sender can
+// still pay for them. The caller must hold the write lock.
+func (bc *Blockchain) revalidatePool() []droppedTx {
+	pending := txpool.NewPendingState()
+	reasons := make(map[[32]byte]string)
+	removed := bc.txPool.Filter(func(tx *transaction.Transaction) bool {
+		expectedNonce := pending.Nonce(tx.From, bc.state.GetNonce(tx.From))
+		queued := tx.Nonce > expectedNonce
+		if queued {
+			expectedNonce = tx.Nonce
 		}
-	}()
-	log.Println("Auto block creation started")
+		senderBalance := pending.Balance(tx.From, bc.state.GetBalance(tx.From))
+		var rejected *RejectError
+		if err := checkTransaction(bc.state, tx, expectedNonce, senderBalance); errors.As(err, &rejected) {
+			reasons[tx.Hash] = rejected.Reason
+			return false
+		}
+		// Governance transactions signed with a rotated-out key are dead
+		if err := checkGovernanceSigner(bc.state, tx); err != nil {
+			reasons[tx.Hash] = RejectInvalidSignature
+			return false
+		}
+		if queued {
+			pending.Debit(tx)
+		} else {
+			pending.Apply(tx)
+		}
+		return true
+	})
+
+	invalid := make([]droppedTx, len(removed))
+	for i := range removed {
+		invalid[i] = droppedTx{tx: removed[i], reason: reasons[removed[i].Hash]}
+	}
+	return invalid
 }
 
-// StopAutoBlock stops automatic block creation
-func (bc *Blockchain) StopAutoBlock() {
-	bc.mu.Lock()
-	bc.autoBlock = false
-	bc.mu.Unlock()
+// checkState checks a transaction against the nonces and balances in st
+func checkState(st *state.State, tx *transaction.Transaction) error {
+	return checkTransaction(st, tx, st.GetNonce(tx.From), st.GetBalance(tx.From))
 }
 
-// validateTransaction validates a transaction
-func (bc *Blockchain) validateTransaction(transaction *transaction.Transaction) error {
-	// Note: This function assumes the caller holds appropriate locks
-	senderBalance := bc.state.GetBalance(transaction.From)
-	log.Printf("Validating transaction - Sender: %s, Balance: %d, Transfer Amount: %d",
-		transaction.From, senderBalance, transaction.Value)
+// checkTransaction checks a transaction against the sender's expected nonce
+// and available balance, which has to cover the value and the fee, and that
+// crediting the recipient in st does not overflow its balance. Transfers
+// must be between accounts that exist in st: the circuit proves a block
+// against the accounts of its parent state and cannot create new ones.
+func checkTransaction(st *state.State, tx *transaction.Transaction, expectedNonce uint64, senderBalance types.Amount) error {
+	if err := checkTransactionType(tx); err != nil {
+		return &RejectError{Reason: RejectInvalidType, Err: err}
+	}
+	if tx.Type == transaction.TypeTransfer {
+		for _, address := range []string{tx.From, tx.To} {
+			if !st.HasAccount(address) {
+				return rejectf(RejectUnknownAccount, "unknown account %s", address)
+			}
+		}
+	}
 
-	if senderBalance < transaction.Value {
-		log.Printf("Insufficient balance - Required: %d, Available: %d",
-			transaction.Value, senderBalance)
-		return fmt.Errorf("insufficient balance")
+	recipientBalance := st.GetBalance(tx.To)
+	if cost, err := tx.Value.Add(tx.Fee); err != nil || senderBalance.Cmp(cost) < 0 {
+		return rejectf(RejectInsufficientBalance, "insufficient balance")
+	}
+	if tx.To != tx.From {
+		if _, err := recipientBalance.Add(tx.Value); err != nil {
+			return rejectf(RejectBalanceOverflow, "recipient balance overflow")
+		}
 	}
 
 	// Check nonce
-	expectedNonce := bc.state.GetNonce(transaction.From)
-	if transaction.Nonce != expectedNonce {
-		log.Printf("Invalid nonce - Expected: %d, Got: %d",
-			expectedNonce, transaction.Nonce)
-		return fmt.Errorf("invalid nonce: expected %d, got %d", expectedNonce, transaction.Nonce)
+	if tx.Nonce != expectedNonce {
+		return rejectf(RejectInvalidNonce, "invalid nonce: expected %d, got %d", expectedNonce, tx.Nonce)
 	}
 
 	return nil
 }
 
-// applyTransactions applies a list of transactions and updates the state tree
-func (bc *Blockchain) applyTransactions(block *block.Block) (string, error) {
-	// 准备输入数据
-	var accounts []zk.Account
-
+// zkAccounts returns the accounts in st as circuit inputs, sorted by address
+func zkAccounts(st *state.State) []zk.Account {
 	// 获取所有账户状态
-	allAccounts := bc.state.GetAllAccounts()
-	for addr, acc := range allAccounts {
+	var accounts []zk.Account
+	for addr, acc := range st.GetAllAccounts() {
 		accounts = append(accounts, zk.Account{
 			Address: addr,
 			Balance: acc.Balance,
@@ -440,53 +1190,93 @@ func (bc *Blockchain) applyTransactions(block *block.Block) (string, error) {
 	sort.Slice(accounts, func(i, j int) bool {
 		return accounts[i].Address < accounts[j].Address
 	})
+	return accounts
+}
 
-	// 准备交易数据
+// zkTransactions returns the transfers among txs as circuit inputs.
+// Governance transactions leave the account state unchanged and are not
+// executed by the circuit; see zkGovernance.
+func zkTransactions(txs []transaction.Transaction) []zk.Transaction {
 	var transactions []zk.Transaction
-	for _, tx := range block.Transactions {
+	for _, tx := range txs {
+		if tx.Type != transaction.TypeTransfer {
+			continue
+		}
 		transactions = append(transactions, zk.Transaction{
 			From:   tx.From,
 			To:     tx.To,
 			Amount: tx.Value,
+			Fee:    tx.Fee,
 			Nonce:  int(tx.Nonce),
 		})
 	}
+	return transactions
+}
 
-	oldStateRoot := bc.GetStateRoot()
-	// 准备证明输入
-	input := zk.ProofInput{
-		OldStateRoot: oldStateRoot,
-		Accounts:     accounts,
-		Transactions: transactions,
-	}
-
-	fmt.Printf("input: %#v\n", input)
-	// 生成证明
-	output, err := zk.GenerateProof(input)
-	if err != nil {
-		return "", fmt.Errorf("failed to generate ZK proof [req: %#v]: %v", input, err)
+// zkGovernance returns the governance transactions among txs, which the
+// proof commits to as leaves of the batch root. A rotation that is not in
+// the batch the proof was generated for does not verify.
+func zkGovernance(txs []transaction.Transaction) []zk.Governance {
+	var governance []zk.Governance
+	for _, tx := range txs {
+		if tx.Type == transaction.TypeTransfer {
+			continue
+		}
+		governance = append(governance, zk.Governance{
+			Type:  tx.Type.String(),
+			Data:  tx.Data,
+			Nonce: int(tx.Nonce),
+		})
 	}
+	return governance
+}
 
-	chaincode.JsonVerify(output)
+// computeStateRoot computes the state root of st
+func computeStateRoot(st *state.State) string {
+	return zk.ComputeAccountMerkleRoot(zkAccounts(st))
+}
 
-	// 更新账户状态
+// applyTransactions applies the block's transactions to the state. The caller
+// must hold the write lock.
+func (bc *Blockchain) applyTransactions(block *block.Block) {
 	for i := range block.Transactions {
 		tx := &block.Transactions[i]
-		// 更新发送方余额和nonce
-		fromBalance := bc.state.GetBalance(tx.From)
-		bc.state.SetBalance(tx.From, fromBalance-tx.Value)
-		bc.state.SetNonce(tx.From, tx.Nonce+1)
+		applyTransaction(bc.state, tx)
+	}
+}
 
-		// 更新接收方余额
-		toBalance := bc.state.GetBalance(tx.To)
-		bc.state.SetBalance(tx.To, toBalance+tx.Value)
+// applyTransaction applies a single transaction of any type to st
+func applyTransaction(st *state.State, tx *transaction.Transaction) {
+	if tx.Type != transaction.TypeTransfer {
+		applyGovernance(st, tx)
+		return
+	}
+	applyTransfer(st, tx)
+}
 
-		// 更新交易状态
-		tx.Status = transaction.StatusConfirmed
+// applyTransfer applies a single transaction to st. The fee is taken from
+// the sender along with the value and burned. The transaction must have
+// passed checkTransaction against st, which rules out an insufficient
+// balance and an overflowing credit.
+func applyTransfer(st *state.State, tx *transaction.Transaction) {
+	// 更新发送方余额和nonce
+	cost, err := tx.Value.Add(tx.Fee)
+	if err != nil {
+		panic(fmt.Sprintf("unchecked transaction %x: cost: %v", tx.Hash, err))
 	}
-	fmt.Println("output.NewStateRoot: ", output.NewStateRoot)
+	fromBalance, err := st.GetBalance(tx.From).Sub(cost)
+	if err != nil {
+		panic(fmt.Sprintf("unchecked transaction %x: sender balance: %v", tx.Hash, err))
+	}
+	st.SetBalance(tx.From, fromBalance)
+	st.SetNonce(tx.From, tx.Nonce+1)
 
-	return output.NewStateRoot, nil
+	// 更新接收方余额
+	toBalance, err := st.GetBalance(tx.To).Add(tx.Value)
+	if err != nil {
+		panic(fmt.Sprintf("unchecked transaction %x: recipient balance: %v", tx.Hash, err))
+	}
+	st.SetBalance(tx.To, toBalance)
 }
 
 // ResetState resets the blockchain state
@@ -496,17 +1286,50 @@ func (bc *Blockchain) ResetState() {
 
 	// Reset blocks
 	bc.blocks = make([]*block.Block, 0)
+	bc.base = 0
 
 	// Reset state
 	bc.state = state.NewState()
 
 	// Reset transaction pool
-	bc.txPool = txpool.NewTxPool()
+	bc.txPool = bc.newTxPool()
 
 	// Reset Merkle tree
 	bc.merkleTree = crypto.NewMerkleTree(nil)
 
-	log.Println("Blockchain state has been reset")
+	// Reset chain index
+	bc.indexer = indexer.NewIndexer()
+	bc.registered = make(map[string]transaction.PublicKey)
+	bc.proofInputs = make(map[uint64][]zk.Account)
+	bc.diffs = nil
+
+	bc.logger.Info("Blockchain state has been reset")
+}
+
+// SetLogger replaces the logger of the chain and its transaction pool. It
+// must be called before the chain is used concurrently.
+func (bc *Blockchain) SetLogger(logger *slog.Logger) {
+	bc.logger = logger
+	bc.txPool.SetLogger(logger)
+}
+
+// SetClock replaces the clock that block timestamps are taken from, block
+// production is timed by and pool lifetimes are measured with. It takes
+// effect the next time block production is started.
+func (bc *Blockchain) SetClock(c clock.Clock) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.clock = c
+	bc.txPool.SetClock(c)
+}
+
+// SetRandom replaces the source of the randomness blocks are signed with.
+// With a seeded source and a manual clock, the same transactions always
+// produce the same blocks.
+func (bc *Blockchain) SetRandom(random io.Reader) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.random = random
 }
 
 // GetPublicKey returns the public key for an address
@@ -516,11 +1339,31 @@ func (bc *Blockchain) GetPublicKey(address string) *ecdsa.PublicKey {
 	return bc.state.GetPublicKey(address)
 }
 
-// SetPublicKey sets the public key for an address
-func (bc *Blockchain) SetPublicKey(address string, pubKey *ecdsa.PublicKey) {
+// SetPublicKey registers the public key of an address that is not derived
+// from a key, such as a genesis account. Other accounts are bound to a key
+// by their first transaction. A registration is local to this node and
+// persisted with its chain, so another node only accepts blocks spending
+// from the address once the same key is registered there. An address that
+// already has a different key cannot be registered again.
+func (bc *Blockchain) SetPublicKey(address string, pubKey *ecdsa.PublicKey) error {
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
+
+	key := transaction.NewPublicKey(pubKey)
+	if current := bc.state.GetPublicKey(address); current != nil {
+		if !key.Equal(transaction.NewPublicKey(current)) {
+			return fmt.Errorf("address %s already has a different key", address)
+		}
+		return nil
+	}
 	bc.state.SetPublicKey(address, pubKey)
+	bc.registered[address] = key
+	if bc.store != nil {
+		if err := bc.store.Put(keysName, bc.registered); err != nil {
+			return fmt.Errorf("failed to persist registered key: %v", err)
+		}
+	}
+	return nil
 }
 
 // GetTransactionPool returns all transactions in the pool
@@ -528,6 +1371,18 @@ func (bc *Blockchain) GetTransactionPool() []transaction.Transaction {
 	return bc.txPool.GetAll()
 }
 
+// GetPendingTransactions returns the executable transactions in the pool in
+// the order blocks take them, highest fee first
+func (bc *Blockchain) GetPendingTransactions() []transaction.Transaction {
+	return bc.txPool.Best(bc.txPool.Size())
+}
+
+// GetQueuedTransactions returns the pooled transactions of each sender that
+// wait behind a nonce gap
+func (bc *Blockchain) GetQueuedTransactions() map[string][]transaction.Transaction {
+	return bc.txPool.Queued()
+}
+
 // GetHeight returns the current blockchain height
 func (bc *Blockchain) GetHeight() uint64 {
 	bc.mu.RLock()
@@ -541,20 +1396,21 @@ func (bc *Blockchain) GetBlock(height uint64) (*block.Block, error) {
 	bc.mu.RLock()
 	defer bc.mu.RUnlock()
 
-	if height >= uint64(len(bc.blocks)) {
+	if height < bc.base || height >= uint64(len(bc.blocks)) {
 		return nil, fmt.Errorf("block not found at height %d", height)
 	}
 
 	return bc.blocks[height], nil
 }
 
-// GetBlocks returns all blocks in the blockchain
+// GetBlocks returns all blocks in the blockchain, starting at the snapshot
+// block for a chain bootstrapped from a snapshot
 func (bc *Blockchain) GetBlocks() []*block.Block {
 	bc.mu.RLock()
 	defer bc.mu.RUnlock()
 
-	blocks := make([]*block.Block, len(bc.blocks))
-	copy(blocks, bc.blocks)
+	blocks := make([]*block.Block, len(bc.blocks)-int(bc.base))
+	copy(blocks, bc.blocks[bc.base:])
 	return blocks
 }
 
@@ -563,7 +1419,7 @@ func (bc *Blockchain) GetAllBlocks() []*block.Block {
 	bc.mu.RLock()
 	defer bc.mu.RUnlock()
 
-	blocks := make([]*block.Block, len(bc.blocks))
-	copy(blocks, bc.blocks)
+	blocks := make([]*block.Block, len(bc.blocks)-int(bc.base))
+	copy(blocks, bc.blocks[bc.base:])
 	return blocks
 }
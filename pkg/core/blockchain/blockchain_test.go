@@ -4,13 +4,80 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"errors"
 	"fmt"
+	"math/big"
 	"testing"
+	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/store"
+	"github.com/StupidBug/fabric-zkrollup/pkg/crypto"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/block"
 	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+	"github.com/StupidBug/fabric-zkrollup/pkg/zk"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
 )
 
+// testSubmitter stands in for Fabric, recording the heights of submitted
+// proofs and failing while err is set
+type testSubmitter struct {
+	mu      sync.Mutex
+	heights []uint64
+	err     error
+}
+
+func (s *testSubmitter) SubmitProof(height uint64, output *zk.ProofOutput) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	s.heights = append(s.heights, height)
+	return nil
+}
+
+func (s *testSubmitter) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// gatedSubmitter holds the first proof submission until release is closed,
+// signalling on submitting, so a test can act while CreateBlock is still
+// finalizing a block
+type gatedSubmitter struct {
+	testSubmitter
+	once       sync.Once
+	submitting chan struct{}
+	release    chan struct{}
+}
+
+func newGatedSubmitter() *gatedSubmitter {
+	return &gatedSubmitter{submitting: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (s *gatedSubmitter) SubmitProof(height uint64, output *zk.ProofOutput) error {
+	s.once.Do(func() {
+		close(s.submitting)
+		<-s.release
+	})
+	return s.testSubmitter.SubmitProof(height, output)
+}
+
+// newTestBlockchain creates an in-memory blockchain with the default genesis
+func newTestBlockchain(t testing.TB) *Blockchain {
+	t.Helper()
+	bc, err := NewBlockchain()
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	return bc
+}
+
 func createTestTransaction(value int64, nonce uint64) transaction.Transaction {
 	// Generate a test key pair
 	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
@@ -21,7 +88,7 @@ func createTestTransaction(value int64, nonce uint64) transaction.Transaction {
 	tx := transaction.Transaction{
 		From:      "0000000000000000000000000000000000000001", // Use genesis account
 		To:        "0000000000000000000000000000000000000002", // Use genesis account
-		Value:     int(value),
+		Value:     types.NewAmount(uint64(value)),
 		Nonce:     nonce,
 		Status:    transaction.StatusPending,
 		Timestamp: time.Now().Unix(),
@@ -32,15 +99,12 @@ func createTestTransaction(value int64, nonce uint64) transaction.Transaction {
 		panic(fmt.Sprintf("Failed to sign transaction: %v", err))
 	}
 
-	// Store public key in blockchain state
-	bc := NewBlockchain()
-	bc.SetPublicKey(tx.From, &privateKey.PublicKey)
-
 	return tx
 }
 
 func TestBlockCreation(t *testing.T) {
-	bc := NewBlockchain()
+	bc := newTestBlockchain(t)
+	bc.SetProofSubmitter(&testSubmitter{})
 
 	// Create and add a transaction
 	tx1 := createTestTransaction(100, 0)
@@ -72,24 +136,25 @@ func TestBlockCreation(t *testing.T) {
 	if confirmedTx == nil {
 		t.Fatal("Transaction not found after block creation")
 	}
-	if confirmedTx.Status != transaction.StatusConfirmed {
-		t.Errorf("Expected transaction status %v, got %v", transaction.StatusConfirmed, confirmedTx.Status)
+	if confirmedTx.Status != transaction.StatusFinalized {
+		t.Errorf("Expected transaction status %v, got %v", transaction.StatusFinalized, confirmedTx.Status)
 	}
 
 	// Verify balances are updated
 	senderBalance := bc.GetBalance("0000000000000000000000000000000000000001")
-	if senderBalance != 999900 { // 1000000 - 100
-		t.Errorf("Expected sender balance 999900, got %d", senderBalance)
+	if senderBalance != types.NewAmount(999900) { // 1000000 - 100
+		t.Errorf("Expected sender balance 999900, got %v", senderBalance)
 	}
 
 	receiverBalance := bc.GetBalance("0000000000000000000000000000000000000002")
-	if receiverBalance != 500100 { // 500000 + 100
-		t.Errorf("Expected receiver balance 500100, got %d", receiverBalance)
+	if receiverBalance != types.NewAmount(500100) { // 500000 + 100
+		t.Errorf("Expected receiver balance 500100, got %v", receiverBalance)
 	}
 }
 
 func TestAutoBlockCreation(t *testing.T) {
-	bc := NewBlockchain()
+	bc := newTestBlockchain(t)
+	bc.SetProofSubmitter(&testSubmitter{})
 
 	// Create and add transaction
 	tx1 := createTestTransaction(100, 0)
@@ -121,24 +186,24 @@ func TestAutoBlockCreation(t *testing.T) {
 	if confirmedTx == nil {
 		t.Fatal("Transaction not found after block creation")
 	}
-	if confirmedTx.Status != transaction.StatusConfirmed {
-		t.Errorf("Expected transaction status %v, got %v", transaction.StatusConfirmed, confirmedTx.Status)
+	if confirmedTx.Status != transaction.StatusFinalized {
+		t.Errorf("Expected transaction status %v, got %v", transaction.StatusFinalized, confirmedTx.Status)
 	}
 
 	// Verify balances
 	senderBalance := bc.GetBalance("0000000000000000000000000000000000000001")
-	if senderBalance != 999900 { // 1000000 - 100
-		t.Errorf("Expected sender balance 999900, got %d", senderBalance)
+	if senderBalance != types.NewAmount(999900) { // 1000000 - 100
+		t.Errorf("Expected sender balance 999900, got %v", senderBalance)
 	}
 
 	receiverBalance := bc.GetBalance("0000000000000000000000000000000000000002")
-	if receiverBalance != 500100 { // 500000 + 100
-		t.Errorf("Expected receiver balance 500100, got %d", receiverBalance)
+	if receiverBalance != types.NewAmount(500100) { // 500000 + 100
+		t.Errorf("Expected receiver balance 500100, got %v", receiverBalance)
 	}
 }
 
 func TestTransactionValidation(t *testing.T) {
-	bc := NewBlockchain()
+	bc := newTestBlockchain(t)
 
 	// Generate a key pair for testing
 	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
@@ -156,15 +221,6 @@ func TestTransactionValidation(t *testing.T) {
 		t.Error("Expected error for insufficient balance")
 	}
 
-	// Test invalid nonce
-	tx2 := createTestTransaction(100, 1) // nonce should be 0
-	if err := tx2.SignTransaction(privateKey); err != nil {
-		t.Fatalf("Failed to sign transaction: %v", err)
-	}
-	if err := bc.AddTransaction(tx2); err == nil {
-		t.Error("Expected error for invalid nonce")
-	}
-
 	// Test valid transaction
 	tx3 := createTestTransaction(100, 0)
 	if err := tx3.SignTransaction(privateKey); err != nil {
@@ -173,12 +229,567 @@ func TestTransactionValidation(t *testing.T) {
 	if err := bc.AddTransaction(tx3); err != nil {
 		t.Errorf("Unexpected error for valid transaction: %v", err)
 	}
+
+	// Test invalid nonce
+	tx2 := createTestTransaction(200, 0) // nonce 0 is already pooled and the fee is not raised
+	if err := tx2.SignTransaction(privateKey); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	if err := bc.AddTransaction(tx2); err == nil {
+		t.Error("Expected error for invalid nonce")
+	}
 }
 
 func TestStateRoot(t *testing.T) {
-	bc := NewBlockchain()
+	bc := newTestBlockchain(t)
 
 	// 1. Test initial state root
 	initialRoot := bc.GetStateRoot()
 	fmt.Println("initialRoot: ", initialRoot)
 }
+
+func TestCreateBlockKeepsLateTransactions(t *testing.T) {
+	bc := newTestBlockchain(t)
+	submitter := newGatedSubmitter()
+	bc.SetProofSubmitter(submitter)
+
+	senders := []string{
+		"0000000000000000000000000000000000000001",
+		"0000000000000000000000000000000000000002",
+		"0000000000000000000000000000000000000003",
+	}
+	keys := make(map[string]*ecdsa.PrivateKey)
+	for _, sender := range senders {
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate key pair: %v", err)
+		}
+		keys[sender] = privateKey
+		bc.SetPublicKey(sender, &privateKey.PublicKey)
+	}
+
+	newTx := func(from, to string, value int, nonce uint64) transaction.Transaction {
+		tx := transaction.Transaction{
+			From:      from,
+			To:        to,
+			Value:     types.NewAmount(uint64(value)),
+			Nonce:     nonce,
+			Status:    transaction.StatusPending,
+			Timestamp: time.Now().Unix(),
+		}
+		if err := tx.SignTransaction(keys[from]); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+		tx.Hash = tx.ComputeHash()
+		return tx
+	}
+
+	// Seed the pool so block production has something to prove
+	first := newTx(senders[0], senders[1], 100, 0)
+	if err := bc.AddTransaction(first); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- bc.CreateBlock()
+	}()
+
+	// Submit while the block is committed but not final yet. The
+	// conflicting transaction reuses sender 1's nonce and must not survive
+	// the block.
+	<-submitter.submitting
+	late := []transaction.Transaction{
+		newTx(senders[1], senders[2], 200, 0),
+		newTx(senders[0], senders[2], 300, 0),
+		newTx(senders[2], senders[0], 400, 0),
+	}
+	var accepted []transaction.Transaction
+	for _, tx := range late {
+		if err := bc.AddTransaction(tx); err == nil {
+			accepted = append(accepted, tx)
+		}
+	}
+	close(submitter.release)
+
+	if err := <-done; err != nil {
+		t.Fatalf("Failed to create block: %v", err)
+	}
+
+	// Every accepted transaction that is still valid is either in the chain
+	// or still waiting in the pool
+	for _, tx := range accepted {
+		if tx.From == senders[0] && tx.Hash != first.Hash {
+			if pooled := bc.txPool.Get(tx.Hash); pooled != nil {
+				t.Errorf("Conflicting transaction %x should have been dropped", tx.Hash)
+			}
+			continue
+		}
+		if bc.GetTransactionByHash(tx.Hash) == nil {
+			t.Errorf("Transaction %x was lost during block creation", tx.Hash)
+		}
+	}
+
+	// The next block picks up the remaining transactions
+	if bc.txPool.Size() > 0 {
+		if err := bc.CreateBlock(); err != nil {
+			t.Fatalf("Failed to create second block: %v", err)
+		}
+	}
+	for _, tx := range []transaction.Transaction{first, late[0], late[2]} {
+		confirmed := bc.GetTransactionByHash(tx.Hash)
+		if confirmed == nil || confirmed.Status != transaction.StatusFinalized {
+			t.Errorf("Expected transaction %x to be finalized", tx.Hash)
+		}
+	}
+	if bc.txPool.Size() != 0 {
+		t.Errorf("Expected empty pool, got size %d", bc.txPool.Size())
+	}
+}
+
+func TestQueuedTransactionsFromOneSender(t *testing.T) {
+	bc := newTestBlockchain(t)
+	bc.SetProofSubmitter(&testSubmitter{})
+
+	sender := "0000000000000000000000000000000000000001"
+	receiver := "0000000000000000000000000000000000000002"
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+	bc.SetPublicKey(sender, &privateKey.PublicKey)
+
+	// Submit three sequential transactions before any block is produced
+	var hashes [][32]byte
+	for nonce := uint64(0); nonce < 3; nonce++ {
+		tx := createTestTransaction(100, nonce)
+		if err := tx.SignTransaction(privateKey); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+		tx.Hash = tx.ComputeHash()
+		if err := bc.AddTransaction(tx); err != nil {
+			t.Fatalf("Failed to add transaction with nonce %d: %v", nonce, err)
+		}
+		hashes = append(hashes, tx.Hash)
+	}
+
+	if nonce := bc.GetPendingNonce(sender); nonce != 3 {
+		t.Errorf("Expected pending nonce 3, got %d", nonce)
+	}
+	if nonce := bc.GetNonce(sender); nonce != 0 {
+		t.Errorf("Expected confirmed nonce 0, got %d", nonce)
+	}
+
+	// A transaction behind a nonce gap waits in the queue
+	gap := createTestTransaction(100, 5)
+	if err := gap.SignTransaction(privateKey); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	gap.Hash = gap.ComputeHash()
+	if err := bc.AddTransaction(gap); err != nil {
+		t.Fatalf("Failed to queue transaction behind a nonce gap: %v", err)
+	}
+	if nonce := bc.GetPendingNonce(sender); nonce != 3 {
+		t.Errorf("Expected pending nonce 3 with a queued transaction, got %d", nonce)
+	}
+
+	// Pending and queued debits count against the balance
+	overdraft := createTestTransaction(1000000-400+1, 3)
+	if err := overdraft.SignTransaction(privateKey); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	if err := bc.AddTransaction(overdraft); err == nil {
+		t.Error("Expected error for insufficient pending balance")
+	}
+
+	// All queued transactions land in the same block
+	if err := bc.CreateBlock(); err != nil {
+		t.Fatalf("Failed to create block: %v", err)
+	}
+	latest := bc.GetLatestBlock()
+	if len(latest.Transactions) != 3 {
+		t.Fatalf("Expected 3 transactions in block, got %d", len(latest.Transactions))
+	}
+	for _, hash := range hashes {
+		tx := bc.GetTransactionByHash(hash)
+		if tx == nil || tx.Status != transaction.StatusFinalized {
+			t.Errorf("Expected transaction %x to be finalized", hash)
+		}
+	}
+
+	if nonce := bc.GetNonce(sender); nonce != 3 {
+		t.Errorf("Expected confirmed nonce 3, got %d", nonce)
+	}
+	if balance := bc.GetBalance(sender); balance != types.NewAmount(999700) {
+		t.Errorf("Expected sender balance 999700, got %v", balance)
+	}
+	if balance := bc.GetBalance(receiver); balance != types.NewAmount(500300) {
+		t.Errorf("Expected receiver balance 500300, got %v", balance)
+	}
+	if queued := bc.GetQueuedTransactions()[sender]; len(queued) != 1 || queued[0].Hash != gap.Hash {
+		t.Errorf("Expected the transaction behind the gap to stay queued, got %v", queued)
+	}
+}
+
+func TestBlockOrdersByFee(t *testing.T) {
+	bc := newTestBlockchain(t)
+	bc.SetProver(nil)
+
+	alice := "0000000000000000000000000000000000000001"
+	bob := "0000000000000000000000000000000000000002"
+	keys := make(map[string]*ecdsa.PrivateKey)
+	for _, address := range []string{alice, bob} {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate key pair: %v", err)
+		}
+		keys[address] = key
+		bc.SetPublicKey(address, &key.PublicKey)
+	}
+	send := func(from string, nonce uint64, fee uint64) (transaction.Transaction, error) {
+		tx := transaction.Transaction{From: from, To: "0000000000000000000000000000000000000003", Value: types.NewAmount(10), Nonce: nonce, Fee: types.NewAmount(fee)}
+		if err := tx.SignTransaction(keys[from]); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+		tx.Hash = tx.ComputeHash()
+		return tx, bc.AddTransaction(tx)
+	}
+
+	// The fee is paid on top of the value
+	before := bc.GetBalance(alice)
+	var rejected *RejectError
+	if _, err := send(alice, 0, before.Big().Uint64()-9); !errors.As(err, &rejected) || rejected.Reason != RejectInsufficientBalance {
+		t.Fatalf("Expected a fee the sender cannot pay to be rejected, got %v", err)
+	}
+
+	var want []transaction.Transaction
+	for _, tx := range []struct {
+		from  string
+		nonce uint64
+		fee   uint64
+	}{{alice, 0, 1}, {alice, 1, 50}, {bob, 0, 10}, {alice, 3, 100}} {
+		sent, err := send(tx.from, tx.nonce, tx.fee)
+		if err != nil {
+			t.Fatalf("Failed to add transaction: %v", err)
+		}
+		want = append(want, sent)
+	}
+
+	// bob pays more than alice's first transaction, which has to precede
+	// her second; her queued transaction waits for nonce 2
+	if err := bc.CreateBlock(); err != nil {
+		t.Fatalf("Failed to create block: %v", err)
+	}
+	included := bc.GetLatestBlock().Transactions
+	order := []transaction.Transaction{want[2], want[0], want[1]}
+	if len(included) != len(order) {
+		t.Fatalf("Expected %d transactions in block, got %d", len(order), len(included))
+	}
+	for i := range order {
+		if included[i].Hash != order[i].Hash {
+			t.Errorf("Position %d: expected %s nonce %d, got %s nonce %d",
+				i, order[i].From, order[i].Nonce, included[i].From, included[i].Nonce)
+		}
+	}
+	// alice's fees are burned along with the values she sent
+	if balance, _ := before.Sub(types.NewAmount(10 + 1 + 10 + 50)); bc.GetBalance(alice) != balance {
+		t.Errorf("Expected alice's balance %s, got %s", balance, bc.GetBalance(alice))
+	}
+	if pool := bc.GetTransactionPool(); len(pool) != 1 || pool[0].Hash != want[3].Hash {
+		t.Errorf("Expected only the queued transaction to remain, got %v", pool)
+	}
+	if pending := bc.GetPendingTransactions(); len(pending) != 0 {
+		t.Errorf("Expected no executable transactions, got %v", pending)
+	}
+}
+
+func TestBlockchainStoreAndIndex(t *testing.T) {
+	dir := t.TempDir()
+	st, err := store.Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	bc, err := NewBlockchainWithStore(st)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	bc.SetProofSubmitter(&testSubmitter{})
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+	tx := createTestTransaction(100, 0)
+	bc.SetPublicKey(tx.From, &privateKey.PublicKey)
+	if err := tx.SignTransaction(privateKey); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	tx.Hash = tx.ComputeHash()
+	if err := bc.AddTransaction(tx); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+	if err := bc.CreateBlock(); err != nil {
+		t.Fatalf("Failed to create block: %v", err)
+	}
+	blockHash := bc.GetLatestBlock().ComputeHash()
+
+	// Reopen from disk, once with the persisted index and once rebuilding it
+	for _, rebuild := range []bool{false, true} {
+		if rebuild {
+			if err := os.Remove(filepath.Join(dir, "index", fmt.Sprintf("%020d.json", 1))); err != nil {
+				t.Fatalf("Failed to remove index entry: %v", err)
+			}
+		}
+		reopened, err := NewBlockchainWithStore(st)
+		if err != nil {
+			t.Fatalf("Failed to reopen blockchain: %v", err)
+		}
+
+		if reopened.GetHeight() != 2 {
+			t.Errorf("Expected 2 blocks after reload, got %d", reopened.GetHeight())
+		}
+		if balance := reopened.GetBalance(tx.From); balance != types.NewAmount(999900) {
+			t.Errorf("Expected sender balance 999900 after reload, got %v", balance)
+		}
+		if nonce := reopened.GetNonce(tx.From); nonce != 1 {
+			t.Errorf("Expected sender nonce 1 after reload, got %d", nonce)
+		}
+
+		confirmed := reopened.GetTransactionByHash(tx.Hash)
+		if confirmed == nil || confirmed.Status != transaction.StatusFinalized {
+			t.Errorf("Expected finalized transaction after reload, got %v", confirmed)
+		}
+
+		b, err := reopened.GetBlockByHash(blockHash)
+		if err != nil || b.Header.Height != 1 {
+			t.Errorf("Expected block 1 by hash after reload, got %v", err)
+		}
+
+		for _, address := range []string{tx.From, tx.To} {
+			txs, total := reopened.GetAddressTransactions(address, 0, 10)
+			if total != 1 || len(txs) != 1 || txs[0].Transaction.Hash != tx.Hash || txs[0].Height != 1 {
+				t.Errorf("Unexpected history for %s: %d of %d", address, len(txs), total)
+			}
+		}
+	}
+}
+
+func TestVerifyBlock(t *testing.T) {
+	bc := newTestBlockchain(t)
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+	tx := createTestTransaction(100, 0)
+	bc.SetPublicKey(tx.From, &privateKey.PublicKey)
+	if err := tx.SignTransaction(privateKey); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	tx.Hash = tx.ComputeHash()
+	if err := bc.AddTransaction(tx); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+	if err := bc.CreateBlock(); err != nil {
+		t.Fatalf("Failed to create block: %v", err)
+	}
+
+	genesis, _ := bc.GetBlock(0)
+	if err := bc.VerifyBlock(genesis); err != nil {
+		t.Errorf("Expected genesis block to verify: %v", err)
+	}
+	latest := bc.GetLatestBlock()
+	if err := bc.VerifyBlock(latest); err != nil {
+		t.Fatalf("Expected produced block to verify: %v", err)
+	}
+
+	// copyBlock returns a copy of the latest block that can be tampered with
+	copyBlock := func() *block.Block {
+		b := *latest
+		b.Transactions = make([]transaction.Transaction, len(latest.Transactions))
+		copy(b.Transactions, latest.Transactions)
+		proof := *latest.Proof
+		b.Proof = &proof
+		return &b
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(b *block.Block)
+	}{
+		{"state root", func(b *block.Block) {
+			b.Header.StateRoot = genesis.Header.StateRoot
+		}},
+		{"transaction value", func(b *block.Block) {
+			b.Transactions[0].Value = types.NewAmount(200)
+			b.Transactions[0].Hash = b.Transactions[0].ComputeHash()
+			b.Header.MerkleRoot = crypto.CreateMerkleTreeFromTransactions(b.Transactions).GetRoot()
+		}},
+		{"signature", func(b *block.Block) {
+			// The key a block carries does not replace the sender's key
+			other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			b.Transactions[0].SignTransaction(other)
+			b.Transactions[0].PublicKey = transaction.NewPublicKey(&other.PublicKey)
+		}},
+		{"missing proof", func(b *block.Block) {
+			b.Proof = nil
+		}},
+		{"proof inputs", func(b *block.Block) {
+			b.Proof.OldStateRoot = b.Header.StateRoot
+		}},
+		{"previous hash", func(b *block.Block) {
+			b.Header.PrevHash = [32]byte{1}
+		}},
+		{"forged sequencer", func(b *block.Block) {
+			other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			b.Sign(other)
+		}},
+		{"block signature", func(b *block.Block) {
+			b.Signature.S = new(big.Int).Add(b.Signature.S, big.NewInt(1))
+		}},
+	}
+	for _, tt := range tests {
+		b := copyBlock()
+		tt.tamper(b)
+		if err := bc.VerifyBlock(b); err == nil {
+			t.Errorf("Expected tampered %s to fail verification", tt.name)
+		}
+	}
+}
+
+func TestLoadRejectsTamperedBlock(t *testing.T) {
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	bc, err := NewBlockchainWithStore(st)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+	tx := createTestTransaction(100, 0)
+	bc.SetPublicKey(tx.From, &privateKey.PublicKey)
+	if err := tx.SignTransaction(privateKey); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	tx.Hash = tx.ComputeHash()
+	if err := bc.AddTransaction(tx); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+	if err := bc.CreateBlock(); err != nil {
+		t.Fatalf("Failed to create block: %v", err)
+	}
+
+	// Tamper with the stored block behind the node's back
+	b, err := st.GetBlock(1)
+	if err != nil {
+		t.Fatalf("Failed to read block: %v", err)
+	}
+	b.Header.StateRoot = "1"
+	if err := st.PutBlock(b); err != nil {
+		t.Fatalf("Failed to write block: %v", err)
+	}
+
+	if _, err := NewBlockchainWithStore(st); err == nil {
+		t.Error("Expected tampered block to be rejected on load")
+	}
+}
+
+func TestBlockFinality(t *testing.T) {
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	bc, err := NewBlockchainWithStore(st)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	submitter := &testSubmitter{err: errors.New("fabric unavailable")}
+	bc.SetProofSubmitter(submitter)
+	events, cancel := bc.Subscribe(EventFilter{Types: []EventType{EventProofSubmitted}})
+	defer cancel()
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+	tx := createTestTransaction(100, 0)
+	bc.SetPublicKey(tx.From, &privateKey.PublicKey)
+	if err := tx.SignTransaction(privateKey); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	tx.Hash = tx.ComputeHash()
+	if err := bc.AddTransaction(tx); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+
+	// A failed submission leaves the block proven and does not fail the block
+	if err := bc.CreateBlock(); err != nil {
+		t.Fatalf("Failed to create block: %v", err)
+	}
+	latest := bc.GetLatestBlock()
+	if latest.Status != transaction.StatusProven || latest.Proof == nil {
+		t.Fatalf("Expected proven block with proof, got status %v", latest.Status)
+	}
+	if got := bc.GetTransactionByHash(tx.Hash); got == nil || got.Status != transaction.StatusProven {
+		t.Errorf("Expected proven transaction, got %v", got)
+	}
+	if len(events) != 0 {
+		t.Error("Expected no submission event for a proof Fabric rejected")
+	}
+
+	// Once Fabric is reachable the block is submitted and finalized
+	submitter.setErr(nil)
+	bc.produceMu.Lock()
+	err = bc.advanceFinality()
+	bc.produceMu.Unlock()
+	if err != nil {
+		t.Fatalf("Failed to advance finality: %v", err)
+	}
+	if len(submitter.heights) != 1 || submitter.heights[0] != 1 {
+		t.Errorf("Expected proof of block 1 to be submitted, got %v", submitter.heights)
+	}
+	if len(events) != 1 {
+		t.Errorf("Expected one submission event, got %d", len(events))
+	}
+	if got := bc.GetTransactionByHash(tx.Hash); got == nil || got.Status != transaction.StatusFinalized {
+		t.Errorf("Expected finalized transaction, got %v", got)
+	}
+	stored, err := st.GetBlock(1)
+	if err != nil || stored.Status != transaction.StatusFinalized {
+		t.Errorf("Expected finalized status to be persisted, got %v", err)
+	}
+
+	// A block that was only executed when the node stopped is proven after a
+	// restart, and is not served to followers until then
+	stored.Status = transaction.StatusExecuted
+	stored.Proof = nil
+	if err := st.PutBlock(stored); err != nil {
+		t.Fatalf("Failed to write block: %v", err)
+	}
+	reopened, err := NewBlockchainWithStore(st)
+	if err != nil {
+		t.Fatalf("Failed to reopen blockchain: %v", err)
+	}
+	reopened.SetProofSubmitter(submitter)
+	if blocks := reopened.GetBlocksFrom(1, 10); len(blocks) != 0 {
+		t.Errorf("Expected executed block to be withheld from sync, got %d blocks", len(blocks))
+	}
+	reopened.produceMu.Lock()
+	err = reopened.advanceFinality()
+	reopened.produceMu.Unlock()
+	if err != nil {
+		t.Fatalf("Failed to advance finality after restart: %v", err)
+	}
+	b, _ := reopened.GetBlock(1)
+	if b.Status != transaction.StatusFinalized || b.Proof == nil {
+		t.Errorf("Expected block to be proven and finalized after restart, got %v", b.Status)
+	}
+	if err := reopened.VerifyBlock(b); err != nil {
+		t.Errorf("Expected re-proven block to verify: %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package blockchain
+
+import (
+	"sync"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/block"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+)
+
+// EventType identifies the kind of a chain event
+type EventType int
+
+const (
+	EventNewPendingTx EventType = iota
+	EventBlockSealed
+	EventBlockProven
+	EventProofSubmitted
+	EventBlockFinalized
+	EventTxConfirmed
+	EventTxFailed
+	EventChainRolledBack
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventNewPendingTx:
+		return "new_pending_tx"
+	case EventBlockSealed:
+		return "block_sealed"
+	case EventBlockProven:
+		return "block_proven"
+	case EventProofSubmitted:
+		return "proof_submitted"
+	case EventBlockFinalized:
+		return "block_finalized"
+	case EventTxConfirmed:
+		return "tx_confirmed"
+	case EventTxFailed:
+		return "tx_failed"
+	case EventChainRolledBack:
+		return "chain_rolled_back"
+	default:
+		return "unknown"
+	}
+}
+
+// eventBufferSize is the number of undelivered events a subscriber may hold
+const eventBufferSize = 64
+
+// Event is delivered to subscribers when the chain makes progress. Block is
+// set for block events, Transaction for transaction events and Err and
+// Reason for EventTxFailed.
+type Event struct {
+	Type        EventType
+	Height      uint64
+	Block       *block.Block
+	Transaction *transaction.Transaction
+	Err         error
+	Reason      string // Why the transaction left the pool: a Reject* or Drop* reason
+}
+
+// EventFilter selects the events delivered to a subscriber. The zero value
+// matches every event.
+type EventFilter struct {
+	Types   []EventType // Event types to deliver, all types if empty
+	Address string      // Only transaction events sent from or to this address
+}
+
+// matches reports whether ev passes the filter
+func (f EventFilter) matches(ev Event) bool {
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			if t == ev.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.Address != "" {
+		if ev.Transaction == nil {
+			return false
+		}
+		if ev.Transaction.From != f.Address && ev.Transaction.To != f.Address {
+			return false
+		}
+	}
+	return true
+}
+
+// subscription is a single subscriber of the event feed
+type subscription struct {
+	ch     chan Event
+	filter EventFilter
+}
+
+// eventFeed fans chain events out to subscribers.
+//
+// Delivery never blocks the chain: every subscriber has a buffer of
+// eventBufferSize events, and a subscriber whose buffer is full is dropped
+// and its channel closed. A closed channel therefore means events were
+// missed, and the consumer should resubscribe and re-read the chain.
+type eventFeed struct {
+	mu     sync.Mutex
+	subs   map[int]*subscription
+	nextID int
+}
+
+// newEventFeed creates an empty event feed
+func newEventFeed() *eventFeed {
+	return &eventFeed{
+		subs: make(map[int]*subscription),
+	}
+}
+
+// subscribe registers a subscriber and returns its channel and a cancel
+// function that unsubscribes and closes the channel. Cancel may be called
+// more than once.
+func (f *eventFeed) subscribe(filter EventFilter) (<-chan Event, func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id := f.nextID
+	f.nextID++
+	sub := &subscription{
+		ch:     make(chan Event, eventBufferSize),
+		filter: filter,
+	}
+	f.subs[id] = sub
+
+	cancel := func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		if _, ok := f.subs[id]; ok {
+			delete(f.subs, id)
+			close(sub.ch)
+		}
+	}
+	return sub.ch, cancel
+}
+
+// send delivers ev to every matching subscriber without blocking
+func (f *eventFeed) send(ev Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for id, sub := range f.subs {
+		if !sub.filter.matches(ev) {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			// Slow subscriber: drop it rather than block the chain
+			delete(f.subs, id)
+			close(sub.ch)
+		}
+	}
+}
+
+// Subscribe returns a channel of chain events matching filter and a function
+// that cancels the subscription. A subscriber that falls more than
+// eventBufferSize events behind is dropped and its channel closed.
+func (bc *Blockchain) Subscribe(filter EventFilter) (<-chan Event, func()) {
+	return bc.events.subscribe(filter)
+}
@@ -0,0 +1,199 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/store"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+)
+
+func TestEventFilter(t *testing.T) {
+	feed := newEventFeed()
+	all, cancelAll := feed.subscribe(EventFilter{})
+	defer cancelAll()
+	blocks, cancelBlocks := feed.subscribe(EventFilter{Types: []EventType{EventBlockSealed}})
+	defer cancelBlocks()
+	account, cancelAccount := feed.subscribe(EventFilter{Address: "0000000000000000000000000000000000000002"})
+	defer cancelAccount()
+
+	tx := createTestTransaction(100, 0)
+	feed.send(Event{Type: EventNewPendingTx, Transaction: &tx})
+	feed.send(Event{Type: EventBlockSealed, Height: 1})
+
+	if len(all) != 2 {
+		t.Errorf("Expected 2 events for unfiltered subscriber, got %d", len(all))
+	}
+	if len(blocks) != 1 {
+		t.Errorf("Expected 1 event for block subscriber, got %d", len(blocks))
+	}
+	if ev := <-blocks; ev.Type != EventBlockSealed || ev.Height != 1 {
+		t.Errorf("Unexpected block event %v at height %d", ev.Type, ev.Height)
+	}
+	if len(account) != 1 {
+		t.Errorf("Expected 1 event for address subscriber, got %d", len(account))
+	}
+	if ev := <-account; ev.Type != EventNewPendingTx {
+		t.Errorf("Expected %v, got %v", EventNewPendingTx, ev.Type)
+	}
+}
+
+func TestEventSlowSubscriberDropped(t *testing.T) {
+	feed := newEventFeed()
+	slow, cancel := feed.subscribe(EventFilter{})
+
+	// Overflow the buffer without reading
+	for i := 0; i <= eventBufferSize; i++ {
+		feed.send(Event{Type: EventBlockSealed, Height: uint64(i)})
+	}
+
+	received := 0
+	for range slow {
+		received++
+	}
+	if received != eventBufferSize {
+		t.Errorf("Expected %d buffered events before close, got %d", eventBufferSize, received)
+	}
+
+	// Cancelling a dropped subscription is a no-op
+	cancel()
+}
+
+func TestEventCancel(t *testing.T) {
+	feed := newEventFeed()
+	ch, cancel := feed.subscribe(EventFilter{})
+	cancel()
+	cancel()
+
+	if _, ok := <-ch; ok {
+		t.Error("Expected channel to be closed after cancel")
+	}
+	feed.send(Event{Type: EventBlockSealed})
+}
+
+func TestBlockEvents(t *testing.T) {
+	bc := newTestBlockchain(t)
+	bc.SetProofSubmitter(&testSubmitter{})
+	events, cancel := bc.Subscribe(EventFilter{})
+	defer cancel()
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+	tx := createTestTransaction(100, 0)
+	bc.SetPublicKey(tx.From, &privateKey.PublicKey)
+	if err := tx.SignTransaction(privateKey); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	tx.Hash = tx.ComputeHash()
+
+	if err := bc.AddTransaction(tx); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+	if err := bc.CreateBlock(); err != nil {
+		t.Fatalf("Failed to create block: %v", err)
+	}
+
+	var types []EventType
+	for len(events) > 0 {
+		ev := <-events
+		types = append(types, ev.Type)
+
+		switch ev.Type {
+		case EventNewPendingTx:
+			if ev.Transaction.Hash != tx.Hash {
+				t.Errorf("Unexpected pending transaction %x", ev.Transaction.Hash)
+			}
+		case EventBlockProven:
+			if ev.Block.Header.StateRoot == "" {
+				t.Error("Expected proven block to carry its state root")
+			}
+		case EventBlockFinalized:
+			if ev.Block.Status != transaction.StatusFinalized {
+				t.Errorf("Expected finalized block, got status %v", ev.Block.Status)
+			}
+		case EventTxConfirmed:
+			if ev.Height != 1 || ev.Transaction.Status != transaction.StatusExecuted {
+				t.Errorf("Unexpected confirmation at height %d with status %v", ev.Height, ev.Transaction.Status)
+			}
+		}
+	}
+
+	expected := []EventType{
+		EventNewPendingTx, EventBlockSealed, EventTxConfirmed,
+		EventBlockProven, EventProofSubmitted, EventBlockFinalized,
+	}
+	if len(types) != len(expected) {
+		t.Fatalf("Expected events %v, got %v", expected, types)
+	}
+	for i := range expected {
+		if types[i] != expected[i] {
+			t.Errorf("Expected event %d to be %v, got %v", i, expected[i], types[i])
+		}
+	}
+}
+
+func TestNoSealedEventForUncommittedBlock(t *testing.T) {
+	dir := t.TempDir()
+	st, err := store.Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	bc, err := NewBlockchainWithStore(st)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	bc.SetProver(nil)
+	sealed, cancel := bc.Subscribe(EventFilter{Types: []EventType{EventBlockSealed}})
+	defer cancel()
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+	tx := createTestTransaction(100, 0)
+	bc.SetPublicKey(tx.From, &privateKey.PublicKey)
+	if err := tx.SignTransaction(privateKey); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	tx.Hash = tx.ComputeHash()
+	if err := bc.AddTransaction(tx); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+
+	// The block cannot be persisted, so it never reaches the chain and is
+	// not announced
+	diffs := filepath.Join(dir, "diffs")
+	if err := os.RemoveAll(diffs); err != nil {
+		t.Fatalf("Failed to remove diffs: %v", err)
+	}
+	if err := ioutil.WriteFile(diffs, nil, 0644); err != nil {
+		t.Fatalf("Failed to block diffs: %v", err)
+	}
+	if err := bc.CreateBlock(); err == nil {
+		t.Fatal("Expected the block to fail to persist")
+	}
+	if len(sealed) != 0 {
+		t.Errorf("Expected no sealed event, got %+v", <-sealed)
+	}
+
+	// Once it can be persisted, the block is sealed
+	if err := os.Remove(diffs); err != nil {
+		t.Fatalf("Failed to unblock diffs: %v", err)
+	}
+	if err := os.Mkdir(diffs, 0755); err != nil {
+		t.Fatalf("Failed to recreate diffs: %v", err)
+	}
+	if err := bc.CreateBlock(); err != nil {
+		t.Fatalf("Failed to create block: %v", err)
+	}
+	if ev := <-sealed; ev.Height != 1 || bc.GetLatestBlock().Header.Height != 1 {
+		t.Errorf("Expected block 1 to be sealed, got %d", ev.Height)
+	}
+}
@@ -0,0 +1,199 @@
+package blockchain
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/chaincode"
+	"github.com/StupidBug/fabric-zkrollup/pkg/logging"
+	"github.com/StupidBug/fabric-zkrollup/pkg/metrics"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/block"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+	"github.com/StupidBug/fabric-zkrollup/pkg/zk"
+)
+
+// SetProofSubmitter replaces the client used to submit block proofs to Fabric
+func (bc *Blockchain) SetProofSubmitter(submitter chaincode.Submitter) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.submitter = submitter
+}
+
+// SetProver replaces the prover used to generate block proofs, whose keys
+// proofs are then also verified with. Without a prover, blocks stay executed
+// and are neither proven nor submitted, and proofs are still verified with
+// the keys of the previous prover.
+func (bc *Blockchain) SetProver(prover *zk.Prover) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.prover = prover
+	if prover != nil {
+		bc.verifier = prover
+	}
+}
+
+// advanceFinality moves blocks through the finality stages in height order.
+// Executed blocks are proven, and proven blocks are submitted to Fabric and
+// finalized once Fabric commits their proof. Fabric must receive proofs in
+// order, so it stops at the first block that cannot advance and the next call
+// picks up from there. The caller must hold produceMu.
+func (bc *Blockchain) advanceFinality() error {
+	for {
+		b := bc.firstUnfinalized()
+		if b == nil {
+			return nil
+		}
+		height := b.Header.Height
+
+		switch b.Status {
+		case transaction.StatusExecuted:
+			bc.mu.RLock()
+			proving := bc.prover != nil
+			bc.mu.RUnlock()
+			if !proving {
+				return nil
+			}
+			output, err := bc.proveBlock(b)
+			if err != nil {
+				return fmt.Errorf("failed to prove block %d: %v", height, err)
+			}
+			proven, err := bc.setBlockStatus(height, transaction.StatusProven, output)
+			if err != nil {
+				return err
+			}
+			bc.logger.Info("Block proven", "height", height, logging.Hash("hash", proven.ComputeHash()))
+			bc.events.send(Event{Type: EventBlockProven, Height: height, Block: proven})
+
+		case transaction.StatusProven, transaction.StatusSubmitted:
+			submitted, err := bc.setBlockStatus(height, transaction.StatusSubmitted, nil)
+			if err != nil {
+				return err
+			}
+
+			bc.mu.RLock()
+			submitter := bc.submitter
+			bc.mu.RUnlock()
+			start := time.Now()
+			err = submitter.SubmitProof(height, b.Proof)
+			metrics.FabricSubmission.Observe(time.Since(start).Seconds())
+			if err != nil {
+				metrics.FabricErrors.Inc()
+				// Not on Fabric, so the block goes back to proven for a retry
+				if _, statusErr := bc.setBlockStatus(height, transaction.StatusProven, nil); statusErr != nil {
+					bc.logger.Error("Failed to reset block status", "height", height, "err", statusErr)
+				}
+				return fmt.Errorf("failed to submit proof for block %d: %v", height, err)
+			}
+			// Announced only once Fabric has accepted the proof
+			bc.events.send(Event{Type: EventProofSubmitted, Height: height, Block: submitted})
+
+			finalized, err := bc.setBlockStatus(height, transaction.StatusFinalized, nil)
+			if err != nil {
+				return err
+			}
+			bc.events.send(Event{Type: EventBlockFinalized, Height: height, Block: finalized})
+			bc.logger.Info("Block finalized on Fabric", "height", height, logging.Hash("hash", finalized.ComputeHash()),
+				"duration", time.Since(start))
+
+		default:
+			return fmt.Errorf("block %d has unexpected status %v", height, b.Status)
+		}
+	}
+}
+
+// firstUnfinalized returns the lowest block that is not finalized yet, or nil
+// if every block is final
+func (bc *Blockchain) firstUnfinalized() *block.Block {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	var first *block.Block
+	for i := len(bc.blocks) - 1; i >= 0; i-- {
+		if bc.blocks[i].Status == transaction.StatusFinalized {
+			break
+		}
+		first = bc.blocks[i]
+	}
+	return first
+}
+
+// setBlockStatus moves the block at height and its transactions to status,
+// attaching proof if it is not nil, and persists the change. The block is
+// replaced by an updated copy, so readers holding the old block never see it
+// change.
+func (bc *Blockchain) setBlockStatus(height uint64, status transaction.Status, proof *zk.ProofOutput) (*block.Block, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if height >= uint64(len(bc.blocks)) {
+		return nil, fmt.Errorf("block not found at height %d", height)
+	}
+	updated := *bc.blocks[height]
+	updated.Transactions = make([]transaction.Transaction, len(updated.Transactions))
+	copy(updated.Transactions, bc.blocks[height].Transactions)
+	for i := range updated.Transactions {
+		updated.Transactions[i].Status = status
+	}
+	updated.Status = status
+	if proof != nil {
+		updated.Proof = proof
+	}
+
+	if bc.store != nil {
+		if err := bc.store.PutBlock(&updated); err != nil {
+			return nil, fmt.Errorf("failed to persist block %d: %v", height, err)
+		}
+	}
+	bc.blocks[height] = &updated
+	if status == transaction.StatusProven {
+		delete(bc.proofInputs, height)
+	}
+	return &updated, nil
+}
+
+// proveBlock generates the ZK proof for an executed block against the
+// accounts of its parent state
+func (bc *Blockchain) proveBlock(b *block.Block) (*zk.ProofOutput, error) {
+	height := b.Header.Height
+
+	bc.mu.RLock()
+	parentRoot := bc.blocks[height-1].Header.StateRoot
+	accounts, ok := bc.proofInputs[height]
+	if !ok {
+		// Not recorded when the block was produced, e.g. after a restart
+		accounts = zkAccounts(bc.stateAt(height - 1))
+	}
+	prover := bc.prover
+	bc.mu.RUnlock()
+
+	// 准备证明输入
+	input := zk.ProofInput{
+		OldStateRoot: parentRoot,
+		Accounts:     accounts,
+		Transactions: zkTransactions(b.Transactions),
+		Governance:   zkGovernance(b.Transactions),
+	}
+
+	// 生成证明
+	start := time.Now()
+	output, err := generateProof(prover, input)
+	metrics.ProofGeneration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ZK proof: %v", err)
+	}
+	if output.NewStateRoot != b.Header.StateRoot {
+		return nil, fmt.Errorf("proof state root %s does not match block state root %s", output.NewStateRoot, b.Header.StateRoot)
+	}
+	return output, nil
+}
+
+// generateProof proves input with prover, turning a panic in the prover into
+// an error so that the block stays executed instead of taking the node down
+func generateProof(prover *zk.Prover, input zk.ProofInput) (output *zk.ProofOutput, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			output, err = nil, fmt.Errorf("prover panicked: %v", r)
+		}
+	}()
+	return prover.GenerateProof(input)
+}
@@ -0,0 +1,185 @@
+package blockchain
+
+import (
+	"fmt"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/chaincode"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/block"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/state"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+)
+
+// SetForcedQueue sets the forced-inclusion queue on Fabric. A sequencer then
+// includes registered transactions in its blocks, and imported blocks are
+// rejected if they leave out a transaction that is overdue. Forced inclusion
+// is off while no queue is set.
+func (bc *Blockchain) SetForcedQueue(queue chaincode.ForcedQueue) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.forced = queue
+	bc.forcedSeen = nil
+}
+
+// forcedTransactions reads the forced-inclusion queue, which is empty if none
+// is set. While Fabric cannot be read, the entries read last are returned,
+// so blocks are still produced and imported with the queue as last seen. It
+// talks to Fabric, so the caller must not hold the chain lock.
+func (bc *Blockchain) forcedTransactions() []chaincode.ForcedTransaction {
+	bc.mu.RLock()
+	queue := bc.forced
+	bc.mu.RUnlock()
+
+	if queue == nil {
+		return nil
+	}
+	entries, err := queue.ForcedTransactions()
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if err != nil {
+		bc.logger.Warn("Failed to read forced inclusion queue, using the last read entries",
+			"entries", len(bc.forcedSeen), "err", err)
+		return bc.forcedSeen
+	}
+	// The queue only grows, so a concurrent read that returned fewer
+	// entries is older
+	if len(entries) >= len(bc.forcedSeen) {
+		bc.forcedSeen = entries
+	}
+	return entries
+}
+
+// pendingForced returns the entries that are not included in a block below
+// height. The caller must hold the read lock.
+func (bc *Blockchain) pendingForced(entries []chaincode.ForcedTransaction, height uint64) []chaincode.ForcedTransaction {
+	var pending []chaincode.ForcedTransaction
+	for _, entry := range entries {
+		if loc, ok := bc.indexer.TxLocation(entry.Transaction.Hash); ok && loc.Height < height {
+			continue
+		}
+		pending = append(pending, entry)
+	}
+	return pending
+}
+
+// forcedReady reports whether a pending forced transaction can be included
+// in the next block
+func (bc *Blockchain) forcedReady() bool {
+	entries := bc.forcedTransactions()
+
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	for _, entry := range bc.pendingForced(entries, uint64(len(bc.blocks))) {
+		if _, ok := executableForced(bc.state, &entry.Transaction); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// executableForced returns a forced transaction prepared for inclusion if it
+// can be executed on st: a signed transfer carrying the sender's next nonce
+// and no more than its balance. Only the sender can change its nonce, key and
+// spendable balance, so the sequencer cannot make a forced transaction
+// unexecutable.
+func executableForced(st *state.State, forced *transaction.Transaction) (transaction.Transaction, bool) {
+	tx := *forced
+	if tx.Type != transaction.TypeTransfer || tx.Hash != tx.ComputeHash() {
+		return tx, false
+	}
+	// Like a block, a forced transaction brings its own key for an account
+	// that has none yet
+	pubKey := senderKey(st, &tx)
+	if pubKey == nil || !tx.VerifySignature(pubKey) {
+		return tx, false
+	}
+	if err := checkState(st, &tx); err != nil {
+		return tx, false
+	}
+	tx.PublicKey = transaction.NewPublicKey(pubKey)
+	tx.Status = transaction.StatusPending
+	return tx, true
+}
+
+// packForced builds the transactions of a block on top of st from the pending
+// forced transactions and the transactions selected from the pool. Forced
+// transactions that can be executed go first, in queue order. The pool
+// transactions follow as long as they still apply, and forced transactions
+// that only become executable after them, such as one whose nonce follows a
+// pooled transaction, go last. Forced transactions count towards the block
+// limits like any other. st is modified.
+func packForced(st *state.State, pending []chaincode.ForcedTransaction, selected []transaction.Transaction, cfg ProductionConfig) []transaction.Transaction {
+	var (
+		txs     []transaction.Transaction
+		size    int
+		cost    int
+		done    = make([]bool, len(pending))
+		skipped = make(map[string]bool) // Senders with a pool transaction left out
+	)
+	fits := func(tx *transaction.Transaction) bool {
+		return len(txs) < cfg.MaxTransactions && size+tx.Size() <= cfg.MaxBytes && cost+tx.Type.Cost() <= cfg.MaxCost
+	}
+	add := func(tx transaction.Transaction) {
+		applyTransaction(st, &tx)
+		txs = append(txs, tx)
+		size += tx.Size()
+		cost += tx.Type.Cost()
+	}
+	addForced := func() {
+		for progress := true; progress; {
+			progress = false
+			for i := range pending {
+				if done[i] {
+					continue
+				}
+				if tx, ok := executableForced(st, &pending[i].Transaction); ok && fits(&tx) {
+					add(tx)
+					done[i] = true
+					progress = true
+				}
+			}
+		}
+	}
+
+	addForced()
+	for i := range selected {
+		tx := &selected[i]
+		if skipped[tx.From] {
+			continue
+		}
+		if checkState(st, tx) != nil ||
+			checkGovernanceSigner(st, tx) != nil || !fits(tx) {
+			skipped[tx.From] = true
+			continue
+		}
+		add(*tx)
+	}
+	addForced()
+	return txs
+}
+
+// checkForced checks that block, whose post-state is st, leaves out no
+// pending forced transaction that is due at its height and could have been
+// executed. A block is only held to the transactions registered before its
+// parent, that is while the L2 tip was below the parent; the queue read now
+// may hold later registrations that the block could not have included.
+func checkForced(st *state.State, pending []chaincode.ForcedTransaction, b *block.Block) error {
+	included := make(map[[32]byte]bool, len(b.Transactions))
+	for _, tx := range b.Transactions {
+		included[tx.Hash] = true
+	}
+	for i := range pending {
+		entry := &pending[i]
+		if entry.Registered+1 >= b.Header.Height {
+			continue
+		}
+		if entry.Deadline > b.Header.Height || included[entry.Transaction.Hash] {
+			continue
+		}
+		if _, ok := executableForced(st, &entry.Transaction); ok {
+			return fmt.Errorf("forced transaction %d (%x) was due by block %d but is not included",
+				entry.Index, entry.Transaction.Hash, entry.Deadline)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/chaincode"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/block"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/state"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+	"github.com/StupidBug/fabric-zkrollup/pkg/zk"
+)
+
+func TestForcedInclusion(t *testing.T) {
+	const (
+		alice = "0000000000000000000000000000000000000001"
+		bob   = "0000000000000000000000000000000000000002"
+		carol = "0000000000000000000000000000000000000003"
+	)
+	aliceKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	bobKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	sign := func(key *ecdsa.PrivateKey, from, to string, value int, nonce uint64) transaction.Transaction {
+		tx := transaction.Transaction{From: from, To: to, Value: types.NewAmount(uint64(value)), Nonce: nonce}
+		if err := tx.SignTransaction(key); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+		tx.Hash = tx.ComputeHash()
+		tx.PublicKey = transaction.NewPublicKey(&key.PublicKey)
+		return tx
+	}
+
+	// Bob goes to Fabric, as the sequencer ignores him. His second
+	// registration has a nonce gap and can never be executed.
+	fabric := chaincode.NewMemoryFabric(2)
+	forced, err := fabric.RegisterForced(sign(bobKey, bob, carol, 50, 0))
+	if err != nil {
+		t.Fatalf("Failed to register forced transaction: %v", err)
+	}
+	if forced.Registered != 0 || forced.Deadline != 2 {
+		t.Errorf("Expected registration at 0 with deadline 2, got %d and %d", forced.Registered, forced.Deadline)
+	}
+	if _, err := fabric.RegisterForced(sign(bobKey, bob, carol, 50, 5)); err != nil {
+		t.Fatalf("Failed to register forced transaction: %v", err)
+	}
+
+	// Alice and Bob hold genesis accounts, whose keys every node registers
+	register := func(bc *Blockchain) {
+		bc.SetPublicKey(alice, &aliceKey.PublicKey)
+		bc.SetPublicKey(bob, &bobKey.PublicKey)
+	}
+	produce := func(bc *Blockchain, nonce uint64) {
+		t.Helper()
+		register(bc)
+		if err := bc.AddTransaction(sign(aliceKey, alice, carol, 10, nonce)); err != nil {
+			t.Fatalf("Failed to add transaction: %v", err)
+		}
+		if err := bc.CreateBlock(); err != nil {
+			t.Fatalf("Failed to create block: %v", err)
+		}
+	}
+	// Every node shares the circuit keys of the sequencer
+	genesis := DefaultGenesis()
+	genesis.Prover, _ = zk.NewProver(zk.BackendGroth16, "")
+	queue := &flakyQueue{ForcedQueue: fabric}
+	follower, _ := NewBlockchainWithGenesis(genesis, nil)
+	follower.SetForcedQueue(queue)
+	register(follower)
+
+	// A sequencer ignoring the queue produces blocks the follower rejects
+	// once the forced transaction is due. The first block is not held to
+	// it, as its parent was the tip when it was registered.
+	censor, _ := NewBlockchainWithGenesis(genesis, nil)
+	censor.SetProofSubmitter(&testSubmitter{})
+	produce(censor, 0)
+	produce(censor, 1)
+	censored, _ := censor.GetBlock(1)
+	if err := follower.ImportBlock(censored); err != nil {
+		t.Fatalf("Failed to import block before the deadline: %v", err)
+	}
+	// Fabric going away does not stop the import, which goes on with the
+	// queue as last read
+	queue.setErr(errors.New("fabric unavailable"))
+	censored, _ = censor.GetBlock(2)
+	if err := follower.ImportBlock(censored); err == nil || !strings.Contains(err.Error(), "forced transaction 0") {
+		t.Fatalf("Expected block leaving out forced transaction to be rejected, got %v", err)
+	}
+
+	// Nor does it stop production
+	sequencer, _ := NewBlockchainWithGenesis(genesis, nil)
+	sequencer.SetProofSubmitter(fabric)
+	sequencer.SetForcedQueue(queue)
+	produce(sequencer, 0)
+	if b, _ := sequencer.GetBlock(1); len(b.Transactions) != 1 {
+		t.Fatalf("Expected a block of pool transactions without Fabric, got %d transactions", len(b.Transactions))
+	}
+	queue.setErr(nil)
+	produce(sequencer, 1)
+	b, _ := sequencer.GetBlock(2)
+	if len(b.Transactions) != 2 || b.Transactions[0].Hash != forced.Transaction.Hash {
+		t.Fatalf("Expected forced transaction first in block, got %d transactions", len(b.Transactions))
+	}
+	follower, _ = NewBlockchainWithGenesis(genesis, nil)
+	follower.SetForcedQueue(queue)
+	register(follower)
+	for height := uint64(1); height <= 2; height++ {
+		next, _ := sequencer.GetBlock(height)
+		if err := follower.ImportBlock(next); err != nil {
+			t.Fatalf("Failed to import block %d: %v", height, err)
+		}
+	}
+	if follower.GetBalance(bob) != types.NewAmount(500000-50) {
+		t.Errorf("Expected forced transfer applied, bob has %v", follower.GetBalance(bob))
+	}
+	if err := sequencer.VerifyBlock(b); err != nil {
+		t.Errorf("Failed to verify block: %v", err)
+	}
+}
+
+func TestCheckForcedRegistration(t *testing.T) {
+	const bob = "0000000000000000000000000000000000000002"
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tx := transaction.Transaction{From: bob, To: "0000000000000000000000000000000000000003", Value: types.NewAmount(50)}
+	if err := tx.SignTransaction(key); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	tx.Hash = tx.ComputeHash()
+	st := state.NewState()
+	st.SetBalance(bob, types.NewAmount(100))
+	st.SetBalance(tx.To, types.NewAmount(0))
+	st.SetPublicKey(bob, &key.PublicKey)
+
+	b := &block.Block{Header: block.Header{Height: 5}}
+	tests := []struct {
+		registered uint64
+		due        bool
+	}{
+		{3, true},  // Registered before the parent
+		{4, false}, // Registered while the parent was the tip
+		{5, false},
+	}
+	for _, tt := range tests {
+		pending := []chaincode.ForcedTransaction{{Registered: tt.registered, Deadline: 5, Transaction: tx}}
+		if err := checkForced(st, pending, b); (err != nil) != tt.due {
+			t.Errorf("Registered at %d: expected due %v, got %v", tt.registered, tt.due, err)
+		}
+	}
+}
+
+// flakyQueue is a forced-inclusion queue that fails to read while err is set
+type flakyQueue struct {
+	chaincode.ForcedQueue
+	mu  sync.Mutex
+	err error
+}
+
+func (q *flakyQueue) ForcedTransactions() ([]chaincode.ForcedTransaction, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return nil, q.err
+	}
+	return q.ForcedQueue.ForcedTransactions()
+}
+
+func (q *flakyQueue) setErr(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.err = err
+}
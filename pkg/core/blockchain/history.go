@@ -0,0 +1,91 @@
+package blockchain
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/state"
+)
+
+// Account is the state of an account at some height
+type Account struct {
+	Address string
+	Balance types.Amount
+	Nonce   uint64
+}
+
+// GetBalanceAt returns the balance of address after the block at height
+func (bc *Blockchain) GetBalanceAt(address string, height uint64) (types.Amount, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	account, err := bc.accountAt(address, height)
+	if err != nil {
+		return types.Amount{}, err
+	}
+	return account.Balance, nil
+}
+
+// GetNonceAt returns the nonce of address after the block at height
+func (bc *Blockchain) GetNonceAt(address string, height uint64) (uint64, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	account, err := bc.accountAt(address, height)
+	if err != nil {
+		return 0, err
+	}
+	return account.Nonce, nil
+}
+
+// GetAccountsAt returns every account after the block at height, sorted by
+// address
+func (bc *Blockchain) GetAccountsAt(height uint64) ([]Account, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if err := bc.checkHistoryHeight(height); err != nil {
+		return nil, err
+	}
+	var accounts []Account
+	for addr, acc := range bc.stateAt(height).GetAllAccounts() {
+		accounts = append(accounts, Account{Address: addr, Balance: acc.Balance, Nonce: acc.Nonce})
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].Address < accounts[j].Address
+	})
+	return accounts, nil
+}
+
+// accountAt returns the state of address after the block at height. The
+// first later block that touched the address recorded that state in its
+// diff; if none did, the current state still holds it. The caller must hold
+// the read lock.
+func (bc *Blockchain) accountAt(address string, height uint64) (state.PriorAccount, error) {
+	if err := bc.checkHistoryHeight(height); err != nil {
+		return state.PriorAccount{}, err
+	}
+	for _, diff := range bc.diffs[height+1:] {
+		if prior, ok := diff.Accounts[address]; ok {
+			return prior, nil
+		}
+	}
+	return state.PriorAccount{
+		Balance: bc.state.GetBalance(address),
+		Nonce:   bc.state.GetNonce(address),
+	}, nil
+}
+
+// checkHistoryHeight checks that the state after the block at height is
+// known. A chain bootstrapped from a snapshot has no state before it. The
+// caller must hold the read lock.
+func (bc *Blockchain) checkHistoryHeight(height uint64) error {
+	if height >= uint64(len(bc.blocks)) {
+		return fmt.Errorf("block not found at height %d", height)
+	}
+	if height < bc.base {
+		return fmt.Errorf("state at height %d is not available: chain starts at snapshot height %d", height, bc.base)
+	}
+	return nil
+}
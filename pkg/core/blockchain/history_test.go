@@ -0,0 +1,83 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/store"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
+)
+
+func TestHistoricalState(t *testing.T) {
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	bc, err := NewBlockchainWithStore(st)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	bc.SetProofSubmitter(&testSubmitter{})
+
+	const sender = "0000000000000000000000000000000000000001"
+	const receiver = "0000000000000000000000000000000000000002"
+	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	bc.SetPublicKey(sender, &privateKey.PublicKey)
+	for nonce := uint64(0); nonce < 2; nonce++ {
+		tx := createTestTransaction(100, nonce)
+		if err := tx.SignTransaction(privateKey); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+		tx.Hash = tx.ComputeHash()
+		if err := bc.AddTransaction(tx); err != nil {
+			t.Fatalf("Failed to add transaction: %v", err)
+		}
+		if err := bc.CreateBlock(); err != nil {
+			t.Fatalf("Failed to create block: %v", err)
+		}
+	}
+
+	check := func(bc *Blockchain) {
+		t.Helper()
+		for height, want := range []int{1000000, 999900, 999800} {
+			balance, err := bc.GetBalanceAt(sender, uint64(height))
+			if err != nil || balance != types.NewAmount(uint64(want)) {
+				t.Errorf("Expected sender balance %v at height %d, got %v (%v)", want, height, balance, err)
+			}
+			nonce, err := bc.GetNonceAt(sender, uint64(height))
+			if err != nil || nonce != uint64(height) {
+				t.Errorf("Expected sender nonce %d at height %d, got %d (%v)", height, height, nonce, err)
+			}
+		}
+		if balance, _ := bc.GetBalanceAt(receiver, 1); balance != types.NewAmount(500100) {
+			t.Errorf("Expected receiver balance 500100 at height 1, got %v", balance)
+		}
+
+		accounts, err := bc.GetAccountsAt(0)
+		if err != nil || len(accounts) != 3 || accounts[0].Address != sender || accounts[0].Balance != types.NewAmount(1000000) {
+			t.Errorf("Expected genesis accounts at height 0, got %v (%v)", accounts, err)
+		}
+		if _, err := bc.GetBalanceAt(sender, 3); err == nil {
+			t.Error("Expected query above the tip to fail")
+		}
+	}
+	check(bc)
+
+	// Earlier blocks are verified against the state rebuilt from the diffs
+	b, _ := bc.GetBlock(1)
+	if err := bc.VerifyBlock(b); err != nil {
+		t.Errorf("Expected block 1 to verify against historical state: %v", err)
+	}
+
+	// Diffs are persisted with their blocks
+	if _, err := st.GetDiff(2); err != nil {
+		t.Errorf("Expected state diff of block 2 to be stored: %v", err)
+	}
+	reopened, err := NewBlockchainWithStore(st)
+	if err != nil {
+		t.Fatalf("Failed to reopen blockchain: %v", err)
+	}
+	check(reopened)
+}
@@ -0,0 +1,183 @@
+package blockchain
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+)
+
+// poolCheckInterval is the interval at which the pool is checked for enough
+// transactions to fill a block before the next block interval
+const poolCheckInterval = 100 * time.Millisecond
+
+// ProductionConfig controls block production
+type ProductionConfig struct {
+	Interval        time.Duration // Time between blocks
+	MaxTransactions int           // Maximum transactions per block
+	MaxBytes        int           // Maximum total size of the transactions in a block
+	MaxCost         int           // Maximum total execution cost of the transactions in a block
+}
+
+// DefaultProductionConfig returns the default block production settings
+func DefaultProductionConfig() ProductionConfig {
+	return ProductionConfig{
+		Interval:        1 * time.Second,
+		MaxTransactions: 16,
+		MaxBytes:        64 << 10,
+		MaxCost:         16 * transaction.CostTransfer,
+	}
+}
+
+// SetProductionConfig replaces the block production settings. It takes
+// effect the next time block production is started.
+func (bc *Blockchain) SetProductionConfig(cfg ProductionConfig) error {
+	if cfg.Interval <= 0 {
+		return fmt.Errorf("block interval must be positive")
+	}
+	if cfg.MaxTransactions <= 0 {
+		return fmt.Errorf("max transactions per block must be positive")
+	}
+	if cfg.MaxBytes <= 0 {
+		return fmt.Errorf("max block size must be positive")
+	}
+	if cfg.MaxCost <= 0 {
+		return fmt.Errorf("max block cost must be positive")
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.production = cfg
+	return nil
+}
+
+// lifecycle tracks the block production goroutine
+type lifecycle struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{} // closed when the goroutine exits
+}
+
+// Start starts block production in the background. Blocks are produced every
+// block interval, or earlier once the pool holds enough transactions to fill a
+// block, and blocks left unfinalized by an earlier run are proven and
+// submitted. The pool is swept for expired and invalid transactions every
+// revalidation interval, and its journal compacted every rejournal interval.
+// The journal, closed by Stop, is compacted and reopened first. The admission pipeline behind SubmitTransaction runs alongside. Production
+// stops when ctx is cancelled or Stop is called.
+func (bc *Blockchain) Start(ctx context.Context) error {
+	bc.lifecycle.mu.Lock()
+	defer bc.lifecycle.mu.Unlock()
+
+	if bc.lifecycle.done != nil {
+		return fmt.Errorf("block production already started")
+	}
+	if err := bc.reopenPoolJournal(); err != nil {
+		return fmt.Errorf("failed to rotate transaction journal: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	bc.lifecycle.cancel = cancel
+	bc.lifecycle.done = done
+
+	bc.mu.RLock()
+	cfg := bc.production
+	bc.mu.RUnlock()
+
+	bc.startAdmission()
+	go func() {
+		defer close(done)
+		bc.produceBlocks(ctx, cfg)
+		bc.stopAdmission()
+	}()
+	bc.logger.Info("Block production started", "interval", cfg.Interval, "max_txs", cfg.MaxTransactions,
+		"max_bytes", cfg.MaxBytes, "max_cost", cfg.MaxCost)
+	return nil
+}
+
+// Stop stops block production, waits for it and the admission pipeline to
+// exit and closes the pool journal. Submissions already queued are admitted
+// or rejected before the pipeline exits. A block that is being proven is
+// allowed to finish; if ctx expires first, Stop returns and the block, which
+// is already persisted as executed, is proven again by the next run.
+func (bc *Blockchain) Stop(ctx context.Context) error {
+	bc.lifecycle.mu.Lock()
+	defer bc.lifecycle.mu.Unlock()
+
+	if bc.lifecycle.done != nil {
+		bc.lifecycle.cancel()
+		select {
+		case <-bc.lifecycle.done:
+			bc.lifecycle.cancel = nil
+			bc.lifecycle.done = nil
+			bc.logger.Info("Block production stopped")
+		case <-ctx.Done():
+			return fmt.Errorf("block production did not stop: %v", ctx.Err())
+		}
+	}
+	if err := bc.closePoolJournal(); err != nil {
+		return fmt.Errorf("failed to close transaction journal: %v", err)
+	}
+	return nil
+}
+
+// produceBlocks produces blocks and maintains the pool until ctx is cancelled
+func (bc *Blockchain) produceBlocks(ctx context.Context, cfg ProductionConfig) {
+	bc.mu.RLock()
+	clock := bc.clock
+	revalidate := bc.poolConfig.Revalidate
+	rejournal := bc.poolConfig.Rejournal
+	bc.mu.RUnlock()
+	ticker := clock.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	poolCheck := clock.NewTicker(poolCheckInterval)
+	defer poolCheck.Stop()
+	sweep := clock.NewTicker(revalidate)
+	defer sweep.Stop()
+	compact := clock.NewTicker(rejournal)
+	defer compact.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			bc.produceBlock()
+		case <-poolCheck.C():
+			if pending, _ := bc.txPool.Stats(); pending >= cfg.MaxTransactions {
+				bc.produceBlock()
+			}
+		case <-sweep.C():
+			bc.maintainPool()
+		case <-compact.C():
+			bc.rejournalPool()
+		}
+	}
+}
+
+// produceBlock creates a block if the pool has executable transactions or a
+// forced transaction is waiting, and otherwise retries finality for blocks
+// that are not final yet
+func (bc *Blockchain) produceBlock() {
+	pending, _ := bc.txPool.Stats()
+	if pending == 0 && !bc.forcedReady() {
+		if bc.firstUnfinalized() == nil {
+			return
+		}
+		bc.produceMu.Lock()
+		err := bc.advanceFinality()
+		bc.produceMu.Unlock()
+		if err != nil {
+			bc.logger.Warn("Blocks are not final yet", "err", err)
+		}
+		return
+	}
+
+	bc.logger.Debug("Block creation triggered", "pending", pending)
+	if err := bc.CreateBlock(); err != nil {
+		bc.logger.Error("Failed to create block", "err", err)
+	}
+}
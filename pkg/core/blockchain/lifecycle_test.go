@@ -0,0 +1,162 @@
+package blockchain
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/store"
+	"github.com/StupidBug/fabric-zkrollup/pkg/crypto"
+	"github.com/StupidBug/fabric-zkrollup/pkg/leaktest"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/block"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+	"github.com/StupidBug/fabric-zkrollup/pkg/zk"
+)
+
+func TestStartStop(t *testing.T) {
+	bc := newTestBlockchain(t)
+	baseline := runtime.NumGoroutine()
+
+	if err := bc.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	if err := bc.Start(context.Background()); err == nil {
+		t.Error("Expected error when starting twice")
+	}
+	if err := bc.Stop(context.Background()); err != nil {
+		t.Fatalf("Failed to stop: %v", err)
+	}
+	if err := bc.Stop(context.Background()); err != nil {
+		t.Errorf("Expected stopping a stopped chain to succeed: %v", err)
+	}
+	leaktest.WaitForGoroutines(t, baseline)
+
+	// Cancelling the start context also stops production, and the chain can
+	// be started again afterwards
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := bc.Start(ctx); err != nil {
+		t.Fatalf("Failed to restart: %v", err)
+	}
+	cancel()
+	leaktest.WaitForGoroutines(t, baseline)
+	if err := bc.Stop(context.Background()); err != nil {
+		t.Errorf("Failed to stop after cancel: %v", err)
+	}
+}
+
+func TestStopWaitsForProving(t *testing.T) {
+	bc := newTestBlockchain(t)
+	bc.SetProofSubmitter(&testSubmitter{})
+	baseline := runtime.NumGoroutine()
+	sealed, cancel := bc.Subscribe(EventFilter{Types: []EventType{EventBlockSealed}})
+	defer cancel()
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+	tx := createTestTransaction(100, 0)
+	bc.SetPublicKey(tx.From, &privateKey.PublicKey)
+	if err := tx.SignTransaction(privateKey); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	tx.Hash = tx.ComputeHash()
+	if err := bc.AddTransaction(tx); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+
+	if err := bc.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	select {
+	case <-sealed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for a block")
+	}
+
+	// Stop while the block is being proven; the proof is allowed to finish
+	if err := bc.Stop(context.Background()); err != nil {
+		t.Fatalf("Failed to stop: %v", err)
+	}
+	latest := bc.GetLatestBlock()
+	if latest.Header.Height != 1 || latest.Status != transaction.StatusFinalized {
+		t.Errorf("Expected block 1 to be finalized after stop, got block %d with status %v",
+			latest.Header.Height, latest.Status)
+	}
+	leaktest.WaitForGoroutines(t, baseline)
+}
+
+func TestUnprovableBlockStaysExecuted(t *testing.T) {
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	bc, err := NewBlockchainWithStore(st)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	bc.SetProver(nil)
+
+	// A block stored by an earlier version, crediting an account that the
+	// circuit cannot create
+	senderKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	bc.SetPublicKey("0000000000000000000000000000000000000001", &senderKey.PublicKey)
+	tx := createTestTransaction(10, 0)
+	tx.To = transaction.NewPublicKey(&key.PublicKey).Address()
+	if err := tx.SignTransaction(senderKey); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	tx.Hash = tx.ComputeHash()
+	next := bc.state.Clone()
+	applyTransaction(next, &tx)
+	b := &block.Block{
+		Header: block.Header{
+			Version:          1,
+			PrevHash:         bc.GetLatestBlock().ComputeHash(),
+			Timestamp:        time.Now().UTC(),
+			Height:           1,
+			TransactionCount: 1,
+			StateRoot:        computeStateRoot(next),
+		},
+		Transactions: []transaction.Transaction{tx},
+		Status:       transaction.StatusExecuted,
+	}
+	b.Header.MerkleRoot = crypto.CreateMerkleTreeFromTransactions(b.Transactions).GetRoot()
+	if err := b.SignWith(bc.sequencerKey, rand.Reader); err != nil {
+		t.Fatalf("Failed to sign block: %v", err)
+	}
+	bc.mu.Lock()
+	err = bc.commitBlock(b, false)
+	bc.mu.Unlock()
+	if err != nil {
+		t.Fatalf("Failed to commit block: %v", err)
+	}
+
+	// With proving on, the block fails to prove and stays executed instead
+	// of crashing the node
+	bc.SetProver(bc.verifier)
+	bc.produceMu.Lock()
+	err = bc.advanceFinality()
+	bc.produceMu.Unlock()
+	if err == nil {
+		t.Fatal("Expected the block to fail to prove")
+	}
+	if latest := bc.GetLatestBlock(); latest.Header.Height != 1 || latest.Status != transaction.StatusExecuted {
+		t.Errorf("Expected block 1 to stay executed, got block %d with status %v", latest.Header.Height, latest.Status)
+	}
+
+	// Replaying the stored block on restart reports it
+	if _, err := NewBlockchainWithStore(st); err == nil {
+		t.Error("Expected the stored block to be refused on restart")
+	}
+
+	// A panicking prover is reported as an error too
+	if _, err := generateProof(nil, zk.ProofInput{}); err == nil {
+		t.Error("Expected the prover panic to be returned as an error")
+	}
+}
@@ -0,0 +1,50 @@
+package blockchain
+
+import (
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+)
+
+// selectTransactions returns the transactions of pooled that go into the next
+// block under the limits of cfg. Transactions are taken greedily in the given
+// priority order: one that does not fit is left for a later block while
+// smaller ones behind it may still be included, except later transactions of
+// the same sender, which would then have a nonce gap. The selection only
+// depends on that order, so the same pool always gives the same block.
+func selectTransactions(pooled []transaction.Transaction, cfg ProductionConfig) []transaction.Transaction {
+	var (
+		selected []transaction.Transaction
+		size     int
+		cost     int
+		skipped  = make(map[string]bool) // Senders with a transaction left out
+	)
+	for i := range pooled {
+		if len(selected) == cfg.MaxTransactions {
+			break
+		}
+		tx := &pooled[i]
+		if skipped[tx.From] {
+			continue
+		}
+		txSize, txCost := tx.Size(), tx.Type.Cost()
+		if size+txSize > cfg.MaxBytes || cost+txCost > cfg.MaxCost {
+			skipped[tx.From] = true
+			continue
+		}
+		selected = append(selected, *tx)
+		size += txSize
+		cost += txCost
+	}
+	return selected
+}
+
+// checkLimits rejects a transaction that exceeds the limits of cfg on its own
+// and could never be included in a block
+func checkLimits(tx *transaction.Transaction, cfg ProductionConfig) error {
+	if size := tx.Size(); size > cfg.MaxBytes {
+		return rejectf(RejectOversized, "transaction size %d exceeds block limit of %d bytes", size, cfg.MaxBytes)
+	}
+	if cost := tx.Type.Cost(); cost > cfg.MaxCost {
+		return rejectf(RejectOversized, "transaction cost %d exceeds block limit of %d", cost, cfg.MaxCost)
+	}
+	return nil
+}
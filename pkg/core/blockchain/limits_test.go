@@ -0,0 +1,74 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+)
+
+func TestSelectTransactions(t *testing.T) {
+	tx := func(from string, nonce uint64, data int) transaction.Transaction {
+		return transaction.Transaction{From: from, To: "0000000000000000000000000000000000000003",
+			Value: types.NewAmount(1), Nonce: nonce, Data: make([]byte, data)}
+	}
+	a, b := "0000000000000000000000000000000000000001", "0000000000000000000000000000000000000002"
+	smallTx, bigTx := tx(a, 0, 0), tx(a, 0, 1000)
+	small, big := smallTx.Size(), bigTx.Size()
+	pooled := []transaction.Transaction{tx(a, 0, 0), tx(a, 1, 1000), tx(b, 0, 0), tx(a, 2, 0), tx(b, 1, 0)}
+
+	tests := []struct {
+		name   string
+		cfg    ProductionConfig
+		nonces []string // Sender and nonce of the selected transactions
+	}{
+		{"unlimited", ProductionConfig{MaxTransactions: 10, MaxBytes: 1 << 20, MaxCost: 1 << 20}, []string{"a0", "a1", "b0", "a2", "b1"}},
+		{"count", ProductionConfig{MaxTransactions: 2, MaxBytes: 1 << 20, MaxCost: 1 << 20}, []string{"a0", "a1"}},
+		// The large transaction waits, and so does the rest of its sender's
+		{"bytes", ProductionConfig{MaxTransactions: 10, MaxBytes: 3 * small, MaxCost: 1 << 20}, []string{"a0", "b0", "b1"}},
+		{"bytes exact", ProductionConfig{MaxTransactions: 10, MaxBytes: small + big, MaxCost: 1 << 20}, []string{"a0", "a1"}},
+		{"cost", ProductionConfig{MaxTransactions: 10, MaxBytes: 1 << 20, MaxCost: 3 * transaction.CostTransfer}, []string{"a0", "a1", "b0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, tx := range selectTransactions(pooled, tt.cfg) {
+				got = append(got, map[string]string{a: "a", b: "b"}[tx.From]+string(rune('0'+tx.Nonce)))
+			}
+			if len(got) != len(tt.nonces) {
+				t.Fatalf("Expected %v, got %v", tt.nonces, got)
+			}
+			for i := range got {
+				if got[i] != tt.nonces[i] {
+					t.Fatalf("Expected %v, got %v", tt.nonces, got)
+				}
+			}
+		})
+	}
+}
+
+func TestAddTransactionOversized(t *testing.T) {
+	bc := newTestBlockchain(t)
+	cfg := DefaultProductionConfig()
+	cfg.MaxBytes = 200
+	if err := bc.SetProductionConfig(cfg); err != nil {
+		t.Fatalf("Failed to set production config: %v", err)
+	}
+
+	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	bc.SetPublicKey("0000000000000000000000000000000000000001", &privateKey.PublicKey)
+	tx := createTestTransaction(100, 0)
+	if err := tx.SignTransaction(privateKey); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	tx.Hash = tx.ComputeHash()
+
+	var rejected *RejectError
+	if err := bc.AddTransaction(tx); !errors.As(err, &rejected) || rejected.Reason != RejectOversized {
+		t.Fatalf("Expected oversized rejection, got %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/txpool"
+	"github.com/StupidBug/fabric-zkrollup/pkg/logging"
+	"github.com/StupidBug/fabric-zkrollup/pkg/metrics"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+)
+
+// SetPoolConfig replaces the limits of the transaction pool. The sweep and
+// rejournal intervals take effect the next time block production is started.
+func (bc *Blockchain) SetPoolConfig(cfg txpool.Config) error {
+	if cfg.MaxSize <= 0 {
+		return fmt.Errorf("pool size must be positive")
+	}
+	if cfg.MaxPerSender <= 0 {
+		return fmt.Errorf("pool limit per sender must be positive")
+	}
+	if cfg.MaxQueued <= 0 {
+		return fmt.Errorf("pool queue limit per sender must be positive")
+	}
+	if cfg.MaxNonceGap == 0 {
+		return fmt.Errorf("pool nonce gap must be positive")
+	}
+	if cfg.Lifetime < 0 {
+		return fmt.Errorf("pool lifetime must not be negative")
+	}
+	if cfg.Revalidate <= 0 {
+		return fmt.Errorf("pool revalidation interval must be positive")
+	}
+	if cfg.PriceBump < 0 || cfg.PriceBump > txpool.MaxPriceBump {
+		return fmt.Errorf("pool price bump must be between 0 and %d", txpool.MaxPriceBump)
+	}
+	if cfg.Rejournal <= 0 {
+		return fmt.Errorf("pool rejournal interval must be positive")
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.poolConfig = cfg
+	bc.txPool.SetConfig(cfg)
+	return nil
+}
+
+// newTxPool creates an empty pool whose executable transactions start from
+// the confirmed nonces of the chain state
+func (bc *Blockchain) newTxPool() *txpool.TxPool {
+	pool := txpool.NewTxPool()
+	pool.SetConfig(bc.poolConfig)
+	pool.SetClock(bc.clock)
+	pool.SetLogger(bc.logger)
+	pool.SetNonces(func(address string) uint64 {
+		return bc.state.GetNonce(address)
+	})
+	if bc.journal != nil {
+		pool.SetJournal(bc.journal)
+	}
+	return pool
+}
+
+// OpenPoolJournal re-admits the transactions journaled at path by an earlier
+// run, validating each against the current state as if it were submitted
+// again, and then journals the pool to path. Transactions that were included
+// or became invalid meanwhile are dropped, and the journal is compacted down
+// to the re-admitted ones. A damaged journal is read up to the damage.
+func (bc *Blockchain) OpenPoolJournal(path string) error {
+	journal := txpool.NewJournal(path)
+	loaded, dropped, err := journal.Load(func(tx transaction.Transaction) error {
+		return bc.AddTransaction(tx)
+	})
+	if err != nil {
+		bc.logger.Warn("Transaction journal is damaged, keeping the transactions before the damage", "path", path, "err", err)
+	}
+	bc.logger.Info("Loaded transaction journal", "path", path, "transactions", loaded, "readmitted", loaded-dropped)
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.journal = journal
+	bc.txPool.SetJournal(journal)
+	if err := bc.txPool.Rejournal(); err != nil {
+		return fmt.Errorf("failed to rotate transaction journal: %v", err)
+	}
+	return nil
+}
+
+// reopenPoolJournal rotates the journal of the pool, if there is one, down
+// to the pooled transactions and opens it for appending again
+func (bc *Blockchain) reopenPoolJournal() error {
+	bc.mu.RLock()
+	pool := bc.txPool
+	bc.mu.RUnlock()
+	return pool.Rejournal()
+}
+
+// closePoolJournal closes the journal of the pool, if there is one
+func (bc *Blockchain) closePoolJournal() error {
+	bc.mu.RLock()
+	pool := bc.txPool
+	bc.mu.RUnlock()
+	return pool.CloseJournal()
+}
+
+// rejournalPool compacts the journal down to the pooled transactions
+func (bc *Blockchain) rejournalPool() {
+	if err := bc.txPool.Rejournal(); err != nil {
+		bc.logger.Error("Failed to rotate transaction journal", "err", err)
+	}
+}
+
+// maxReplaced is the number of replaced transactions remembered for lookups
+const maxReplaced = 1024
+
+// replacedTx is a pooled transaction that was replaced by a higher fee
+type replacedTx struct {
+	tx transaction.Transaction
+	by [32]byte // Hash of the replacement
+}
+
+// replacements remembers the most recently replaced transactions, so that
+// their senders can find out what became of them. It is protected by the
+// chain lock.
+type replacements struct {
+	txs   map[[32]byte]replacedTx
+	order [][32]byte // Oldest first
+}
+
+// newReplacements creates an empty record of replaced transactions
+func newReplacements() *replacements {
+	return &replacements{txs: make(map[[32]byte]replacedTx)}
+}
+
+// add records that tx was replaced by the transaction with hash by,
+// forgetting the oldest record beyond maxReplaced
+func (r *replacements) add(tx transaction.Transaction, by [32]byte) {
+	if _, ok := r.txs[tx.Hash]; !ok {
+		r.order = append(r.order, tx.Hash)
+	}
+	tx.Status = transaction.StatusReplaced
+	r.txs[tx.Hash] = replacedTx{tx: tx, by: by}
+	if len(r.order) > maxReplaced {
+		delete(r.txs, r.order[0])
+		r.order = r.order[1:]
+	}
+}
+
+// get returns the record of a replaced transaction
+func (r *replacements) get(hash [32]byte) (replacedTx, bool) {
+	rt, ok := r.txs[hash]
+	return rt, ok
+}
+
+// droppedTx is a transaction removed from the pool without being included
+type droppedTx struct {
+	tx     transaction.Transaction
+	reason string // A Reject* or Drop* reason
+}
+
+// dropped pairs each of txs with reason
+func dropped(txs []transaction.Transaction, reason string) []droppedTx {
+	drops := make([]droppedTx, len(txs))
+	for i := range txs {
+		drops[i] = droppedTx{tx: txs[i], reason: reason}
+	}
+	return drops
+}
+
+// reportDropped logs the dropped transactions and tells subscribers why they
+// left the pool. The caller must hold the write lock.
+func (bc *Blockchain) reportDropped(drops []droppedTx, height uint64, message string) {
+	for i := range drops {
+		tx := drops[i].tx
+		metrics.TxDropped.WithLabelValues(drops[i].reason).Inc()
+		bc.logger.Info("Dropped transaction from pool", logging.Hash("tx", tx.Hash),
+			"from", tx.From, "nonce", tx.Nonce, "reason", drops[i].reason, "height", height)
+		bc.events.send(Event{
+			Type:        EventTxFailed,
+			Height:      height,
+			Transaction: &tx,
+			Err:         errors.New(message),
+			Reason:      drops[i].reason,
+		})
+	}
+}
+
+// maintainPool drops the transactions that outlived the pool lifetime and
+// re-validates the rest against the current state, which catches
+// transactions that became invalid without a block being committed
+func (bc *Blockchain) maintainPool() {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	height := uint64(len(bc.blocks) - 1)
+	bc.reportDropped(dropped(bc.txPool.Expire(), DropExpired), height, "transaction expired in the pool")
+	bc.reportDropped(bc.revalidatePool(), height, "transaction no longer valid")
+	bc.updateGauges()
+}
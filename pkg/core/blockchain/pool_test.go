@@ -0,0 +1,385 @@
+package blockchain
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/clock"
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/store"
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/txpool"
+	"github.com/StupidBug/fabric-zkrollup/pkg/metrics"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPoolLimitsAndDrops(t *testing.T) {
+	manual := clock.NewManual(time.Unix(1000, 0))
+	bc := newTestBlockchain(t)
+	bc.SetProver(nil)
+	bc.SetClock(manual)
+	if err := bc.SetPoolConfig(txpool.Config{MaxSize: 2, MaxPerSender: 1, MaxQueued: 1, MaxNonceGap: 64, MinFee: types.NewAmount(1), Lifetime: time.Minute, Revalidate: time.Second, Rejournal: time.Hour}); err != nil {
+		t.Fatalf("Failed to set pool config: %v", err)
+	}
+	failed, cancel := bc.Subscribe(EventFilter{Types: []EventType{EventTxFailed}})
+	defer cancel()
+
+	accounts := []string{
+		"0000000000000000000000000000000000000001",
+		"0000000000000000000000000000000000000002",
+		"0000000000000000000000000000000000000003",
+	}
+	keys := make(map[string]*ecdsa.PrivateKey)
+	for _, address := range accounts {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate key pair: %v", err)
+		}
+		keys[address] = key
+		bc.SetPublicKey(address, &key.PublicKey)
+	}
+	send := func(from string, nonce uint64, fee int) (transaction.Transaction, error) {
+		tx := transaction.Transaction{From: from, To: accounts[0], Value: types.NewAmount(10), Nonce: nonce, Fee: types.NewAmount(uint64(fee))}
+		if err := tx.SignTransaction(keys[from]); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+		tx.Hash = tx.ComputeHash()
+		return tx, bc.AddTransaction(tx)
+	}
+	expectReject := func(err error, reason string) {
+		t.Helper()
+		var rejected *RejectError
+		if !errors.As(err, &rejected) || rejected.Reason != reason {
+			t.Fatalf("Expected rejection %q, got %v", reason, err)
+		}
+	}
+	expectDrop := func(hash [32]byte, reason string) {
+		t.Helper()
+		select {
+		case ev := <-failed:
+			if ev.Transaction.Hash != hash || ev.Reason != reason {
+				t.Fatalf("Expected %x to be dropped as %q, got %x as %q", hash, reason, ev.Transaction.Hash, ev.Reason)
+			}
+		default:
+			t.Fatalf("Expected %x to be dropped as %q", hash, reason)
+		}
+	}
+
+	_, err := send(accounts[1], 0, 0)
+	expectReject(err, RejectFeeTooLow)
+	first, err := send(accounts[1], 0, 1)
+	if err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+	_, err = send(accounts[1], 1, 1)
+	expectReject(err, RejectSenderLimit)
+	second, err := send(accounts[2], 0, 1)
+	if err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+
+	// The pool is full: only a higher fee gets in, evicting the latest of
+	// the cheapest transactions
+	_, err = send(accounts[0], 0, 1)
+	expectReject(err, RejectPoolFull)
+	third, err := send(accounts[0], 0, 5)
+	if err != nil {
+		t.Fatalf("Failed to add transaction to a full pool: %v", err)
+	}
+	expectDrop(second.Hash, DropEvicted)
+
+	// A sender whose balance dropped loses its transaction on the next sweep
+	bc.mu.Lock()
+	bc.state.SetBalance(accounts[1], types.NewAmount(0))
+	bc.mu.Unlock()
+	bc.maintainPool()
+	expectDrop(first.Hash, RejectInsufficientBalance)
+
+	// The rest expires once it outlives the pool lifetime
+	expiredBefore := testutil.ToFloat64(metrics.TxDropped.WithLabelValues(DropExpired))
+	manual.Advance(time.Minute)
+	bc.maintainPool()
+	expectDrop(third.Hash, DropExpired)
+	if got := testutil.ToFloat64(metrics.TxDropped.WithLabelValues(DropExpired)); got != expiredBefore+1 {
+		t.Errorf("Expected dropped counter %v, got %v", expiredBefore+1, got)
+	}
+	if size := len(bc.GetTransactionPool()); size != 0 {
+		t.Errorf("Expected an empty pool, got %d transactions", size)
+	}
+}
+
+func TestAddTransactionAlreadyKnown(t *testing.T) {
+	bc := newTestBlockchain(t)
+	bc.SetProver(nil)
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+	tx := createTestTransaction(100, 0)
+	bc.SetPublicKey(tx.From, &privateKey.PublicKey)
+	if err := tx.SignTransaction(privateKey); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+
+	// Concurrent copies of one transaction get in exactly once, whatever
+	// hash they claim
+	const copies = 8
+	var wg sync.WaitGroup
+	errs := make([]error, copies)
+	for i := 0; i < copies; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			replay := tx
+			replay.Hash = [32]byte{byte(i)}
+			errs[i] = bc.AddTransaction(replay)
+		}(i)
+	}
+	wg.Wait()
+	admitted := 0
+	for _, err := range errs {
+		var rejected *RejectError
+		switch {
+		case err == nil:
+			admitted++
+		case !errors.As(err, &rejected) || rejected.Reason != RejectAlreadyKnown:
+			t.Errorf("Expected rejection %q, got %v", RejectAlreadyKnown, err)
+		}
+	}
+	if admitted != 1 || len(bc.GetTransactionPool()) != 1 {
+		t.Fatalf("Expected one admitted copy, got %d admitted and %d pooled", admitted, len(bc.GetTransactionPool()))
+	}
+
+	// Once included, replays are recognized through the index
+	if err := bc.CreateBlock(); err != nil {
+		t.Fatalf("Failed to create block: %v", err)
+	}
+	var rejected *RejectError
+	if err := bc.AddTransaction(tx); !errors.As(err, &rejected) || rejected.Reason != RejectAlreadyKnown {
+		t.Fatalf("Expected rejection %q for an included transaction, got %v", RejectAlreadyKnown, err)
+	}
+}
+
+func TestReplaceByFee(t *testing.T) {
+	bc := newTestBlockchain(t)
+	bc.SetProver(nil)
+	failed, cancel := bc.Subscribe(EventFilter{Types: []EventType{EventTxFailed}})
+	defer cancel()
+
+	from := "0000000000000000000000000000000000000001"
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+	bc.SetPublicKey(from, &privateKey.PublicKey)
+	send := func(value, fee int) (transaction.Transaction, error) {
+		tx := transaction.Transaction{From: from, To: "0000000000000000000000000000000000000002", Value: types.NewAmount(uint64(value)), Fee: types.NewAmount(uint64(fee))}
+		if err := tx.SignTransaction(privateKey); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+		tx.Hash = tx.ComputeHash()
+		return tx, bc.AddTransaction(tx)
+	}
+
+	stuck, err := send(100, 10)
+	if err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+
+	// The default price bump asks for at least 10% more
+	var rejected *RejectError
+	if _, err := send(200, 10); !errors.As(err, &rejected) || rejected.Reason != RejectUnderpriced {
+		t.Fatalf("Expected rejection %q, got %v", RejectUnderpriced, err)
+	}
+
+	// The replacement may spend the value and fee the stuck transaction
+	// held
+	before := testutil.ToFloat64(metrics.TxDropped.WithLabelValues(DropReplaced))
+	if _, err := send(1000000, 11); !errors.As(err, &rejected) || rejected.Reason != RejectInsufficientBalance {
+		t.Fatalf("Expected rejection %q for a fee on top of the whole balance, got %v", RejectInsufficientBalance, err)
+	}
+	replacement, err := send(1000000-11, 11)
+	if err != nil {
+		t.Fatalf("Failed to replace transaction: %v", err)
+	}
+	select {
+	case ev := <-failed:
+		if ev.Transaction.Hash != stuck.Hash || ev.Reason != DropReplaced {
+			t.Fatalf("Expected %x to be dropped as %q, got %x as %q", stuck.Hash, DropReplaced, ev.Transaction.Hash, ev.Reason)
+		}
+	default:
+		t.Fatal("Expected the stuck transaction to be reported as replaced")
+	}
+	if got := testutil.ToFloat64(metrics.TxDropped.WithLabelValues(DropReplaced)); got != before+1 {
+		t.Errorf("Expected dropped counter %v, got %v", before+1, got)
+	}
+	if pool := bc.GetTransactionPool(); len(pool) != 1 || pool[0].Hash != replacement.Hash {
+		t.Fatalf("Expected only the replacement in the pool, got %v", pool)
+	}
+
+	// The replaced transaction stays visible after the replacement is
+	// included
+	if err := bc.CreateBlock(); err != nil {
+		t.Fatalf("Failed to create block: %v", err)
+	}
+	tx := bc.GetTransactionByHash(stuck.Hash)
+	if tx == nil || tx.Status != transaction.StatusReplaced {
+		t.Fatalf("Expected the stuck transaction to be replaced, got %v", tx)
+	}
+	if by, ok := bc.ReplacedBy(stuck.Hash); !ok || by != replacement.Hash {
+		t.Errorf("Expected replacement %x, got %x", replacement.Hash, by)
+	}
+}
+
+func TestPoolJournalRestart(t *testing.T) {
+	dir := t.TempDir()
+	st, err := store.Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	bc, err := NewBlockchainWithStore(st)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	path := filepath.Join(dir, "txpool.jsonl")
+	if err := bc.OpenPoolJournal(path); err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+
+	funderKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+	// The sender's transactions carry its key, which the journal keeps
+	// along with them
+	const owner = "0000000000000000000000000000000000000003"
+	bc.SetPublicKey(owner, &privateKey.PublicKey)
+	txs := make([]transaction.Transaction, 3)
+	for i := range txs {
+		key := privateKey
+		if i == 0 {
+			// The first transaction funds the sender and is included
+			// before the restart
+			txs[i] = createTestTransaction(1000, 0)
+			txs[i].To = owner
+			key = funderKey
+			bc.SetPublicKey(txs[i].From, &funderKey.PublicKey)
+		} else {
+			txs[i] = transaction.Transaction{From: owner, To: "0000000000000000000000000000000000000002", Value: types.NewAmount(100), Nonce: uint64(i - 1)}
+			txs[i].PublicKey = transaction.NewPublicKey(&privateKey.PublicKey)
+		}
+		if err := txs[i].SignTransaction(key); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+		txs[i].Hash = txs[i].ComputeHash()
+		if err := bc.AddTransaction(txs[i]); err != nil {
+			t.Fatalf("Failed to add transaction %d: %v", i, err)
+		}
+		if i == 0 {
+			if err := bc.CreateBlock(); err != nil {
+				t.Fatalf("Failed to create block: %v", err)
+			}
+		}
+	}
+
+	// After a restart the included transaction is dropped and the others
+	// are admitted again
+	reopened, err := NewBlockchainWithStore(st)
+	if err != nil {
+		t.Fatalf("Failed to reopen blockchain: %v", err)
+	}
+	if err := reopened.OpenPoolJournal(path); err != nil {
+		t.Fatalf("Failed to reopen journal: %v", err)
+	}
+	pool := reopened.GetTransactionPool()
+	if len(pool) != 2 || pool[0].Hash != txs[1].Hash || pool[1].Hash != txs[2].Hash {
+		t.Fatalf("Expected transactions 1 and 2 to be pooled again, got %v", pool)
+	}
+
+	// Transactions added later carry the key as well
+	add := func(chain *Blockchain, nonce uint64) {
+		t.Helper()
+		tx := transaction.Transaction{From: owner, To: "0000000000000000000000000000000000000002", Value: types.NewAmount(100), Nonce: nonce}
+		tx.PublicKey = transaction.NewPublicKey(&privateKey.PublicKey)
+		if err := tx.SignTransaction(privateKey); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+		tx.Hash = tx.ComputeHash()
+		if err := chain.AddTransaction(tx); err != nil {
+			t.Fatalf("Failed to add transaction: %v", err)
+		}
+	}
+	journaled := func(want int) {
+		t.Helper()
+		loaded, _, err := txpool.NewJournal(path).Load(func(tx transaction.Transaction) error {
+			if !tx.PublicKey.Equal(transaction.NewPublicKey(&privateKey.PublicKey)) {
+				t.Errorf("Expected journaled transaction %x to keep its public key", tx.Hash)
+			}
+			return nil
+		})
+		if err != nil || loaded != want {
+			t.Errorf("Expected %d journaled transactions, got %d, %v", want, loaded, err)
+		}
+	}
+
+	// Stopping closes the journal, so later transactions are not appended
+	if err := reopened.Stop(context.Background()); err != nil {
+		t.Fatalf("Failed to stop blockchain: %v", err)
+	}
+	add(reopened, 2)
+	journaled(2)
+
+	// Starting again compacts the journal down to the pool and appends to
+	// it once more
+	production := DefaultProductionConfig()
+	production.Interval = time.Hour
+	if err := reopened.SetProductionConfig(production); err != nil {
+		t.Fatalf("Failed to set production config: %v", err)
+	}
+	if err := reopened.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start blockchain: %v", err)
+	}
+	journaled(3)
+	add(reopened, 3)
+	journaled(4)
+	if err := reopened.Stop(context.Background()); err != nil {
+		t.Fatalf("Failed to stop blockchain: %v", err)
+	}
+}
+
+func TestReplacementBalanceOverflow(t *testing.T) {
+	bc := newTestBlockchain(t)
+
+	// A pooled transaction whose value and fee cannot be credited back,
+	// which validation never lets in
+	pooled := createTestTransaction(0, 0)
+	pooled.Value = types.MaxAmount()
+	pooled.Fee = types.NewAmount(1)
+	pooled.Hash = pooled.ComputeHash()
+	if _, _, err := bc.txPool.Add(pooled); err != nil {
+		t.Fatalf("Failed to pool transaction: %v", err)
+	}
+
+	replacement := createTestTransaction(100, 0)
+	replacement.Fee = types.NewAmount(10)
+	bc.mu.Lock()
+	err := bc.validateTransaction(&replacement)
+	bc.mu.Unlock()
+	var rejected *RejectError
+	if !errors.As(err, &rejected) || rejected.Reason != RejectBalanceOverflow {
+		t.Fatalf("Expected rejection %q, got %v", RejectBalanceOverflow, err)
+	}
+}
@@ -0,0 +1,51 @@
+package blockchain
+
+import "fmt"
+
+// Reasons a transaction is rejected from the pool
+const (
+	RejectMissingSignature    = "missing_signature"
+	RejectInvalidSignature    = "invalid_signature"
+	RejectUnknownSender       = "unknown_sender"
+	RejectUnknownAccount      = "unknown_account"
+	RejectInvalidType         = "invalid_type"
+	RejectInsufficientBalance = "insufficient_balance"
+	RejectBalanceOverflow     = "balance_overflow"
+	RejectInvalidNonce        = "invalid_nonce"
+	RejectOversized           = "oversized"
+	RejectFeeTooLow           = "fee_too_low"
+	RejectPoolFull            = "pool_full"
+	RejectSenderLimit         = "sender_limit"
+	RejectAlreadyKnown        = "already_known"
+	RejectUnderpriced         = "replacement_underpriced"
+)
+
+// Reasons a pooled transaction is dropped besides the Reject* reasons of
+// transactions that no longer validate against the state
+const (
+	DropEvicted  = "evicted"
+	DropExpired  = "expired"
+	DropReplaced = "replaced"
+)
+
+// RejectError is returned by AddTransaction when a transaction is not
+// admitted to the pool
+type RejectError struct {
+	Reason string // One of the Reject* reasons
+	Err    error
+}
+
+// Error returns the message of the underlying error
+func (e *RejectError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *RejectError) Unwrap() error {
+	return e.Err
+}
+
+// rejectf returns a RejectError for reason with a formatted message
+func rejectf(reason, format string, args ...interface{}) error {
+	return &RejectError{Reason: reason, Err: fmt.Errorf(format, args...)}
+}
@@ -0,0 +1,77 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/metrics"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAddTransactionRejectReason(t *testing.T) {
+	bc := newTestBlockchain(t)
+	recipient := "0000000000000000000000000000000000000002"
+	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	sign := func(value int64, nonce uint64) error {
+		tx := createTestTransaction(value, nonce)
+		if err := tx.SignTransaction(privateKey); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+		tx.Hash = tx.ComputeHash()
+		return bc.AddTransaction(tx)
+	}
+
+	tests := []struct {
+		name   string
+		setup  func()
+		value  int64
+		nonce  uint64
+		reason string
+	}{
+		{"unknown sender", func() {}, 100, 0, RejectUnknownSender},
+		{"underpriced replacement", func() {
+			bc.SetPublicKey("0000000000000000000000000000000000000001", &privateKey.PublicKey)
+			if err := sign(100, 5); err != nil {
+				t.Fatalf("Failed to queue transaction: %v", err)
+			}
+		}, 200, 5, RejectUnderpriced},
+		{"insufficient balance", func() {}, 2000000, 0, RejectInsufficientBalance},
+		{"balance overflow", func() {
+			bc.state.SetBalance(recipient, types.MaxAmount())
+		}, 100, 0, RejectBalanceOverflow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setup()
+			rejected := metrics.TxRejected.WithLabelValues(tt.reason)
+			before := testutil.ToFloat64(rejected)
+
+			var rejectErr *RejectError
+			err := sign(tt.value, tt.nonce)
+			if !errors.As(err, &rejectErr) || rejectErr.Reason != tt.reason {
+				t.Fatalf("Expected rejection %q, got %v", tt.reason, err)
+			}
+			if got := testutil.ToFloat64(rejected); got != before+1 {
+				t.Errorf("Expected rejection counter %v, got %v", before+1, got)
+			}
+		})
+	}
+
+	bc.state.SetBalance(recipient, types.NewAmount(500000))
+	admitted := testutil.ToFloat64(metrics.TxAdmitted)
+	if err := sign(100, 0); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+	if got := testutil.ToFloat64(metrics.TxAdmitted); got != admitted+1 {
+		t.Errorf("Expected admission counter %v, got %v", admitted+1, got)
+	}
+	if got := testutil.ToFloat64(metrics.PoolSize); got != 2 {
+		t.Errorf("Expected pool size 2, got %v", got)
+	}
+}
@@ -0,0 +1,122 @@
+package blockchain
+
+import (
+	"fmt"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/logging"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/block"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+)
+
+// RollbackResult describes the outcome of a rollback
+type RollbackResult struct {
+	Height   uint64 `json:"height"`   // Height of the new chain tip
+	Dropped  int    `json:"dropped"`  // Number of blocks removed
+	Requeued int    `json:"requeued"` // Transactions of removed blocks returned to the pool
+}
+
+// RollbackTo removes the blocks above height and restores the account state
+// as of height by undoing the state diff of each removed block, newest first.
+// Transactions of the removed blocks that are still valid go back to the
+// front of the pool, ahead of the transactions already pooled. Blocks whose
+// proof was submitted to Fabric may be committed there, so the chain cannot
+// be rolled back to below the highest submitted block.
+func (bc *Blockchain) RollbackTo(height uint64) (*RollbackResult, error) {
+	bc.produceMu.Lock()
+	defer bc.produceMu.Unlock()
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	tip := uint64(len(bc.blocks)) - 1
+	if height > tip {
+		return nil, fmt.Errorf("cannot roll back to height %d: chain tip is at %d", height, tip)
+	}
+	if height < bc.base {
+		return nil, fmt.Errorf("cannot roll back to height %d: chain starts at snapshot height %d", height, bc.base)
+	}
+	if submitted := bc.submittedHeight(); height < submitted {
+		return nil, fmt.Errorf("cannot roll back to height %d: block %d is %v", height, submitted, bc.blocks[submitted].Status)
+	}
+
+	// Delete the stored blocks first, so that a failure leaves the node
+	// running on blocks that are still persisted
+	if bc.store != nil {
+		if err := bc.store.DeleteBlocksFrom(height + 1); err != nil {
+			return nil, fmt.Errorf("failed to delete blocks: %v", err)
+		}
+	}
+
+	var returned []transaction.Transaction
+	for _, b := range bc.blocks[height+1:] {
+		for _, tx := range b.Transactions {
+			tx.Status = transaction.StatusPending
+			returned = append(returned, tx)
+		}
+	}
+	for h := tip; h > height; h-- {
+		bc.state.Revert(bc.diffs[h])
+		delete(bc.proofInputs, h)
+	}
+	bc.blocks = bc.blocks[:height+1]
+	bc.diffs = bc.diffs[:height+1]
+
+	if err := bc.rebuildIndex(); err != nil {
+		bc.logger.Warn("Failed to rebuild index", "err", err)
+	}
+
+	// Return the transactions of the removed blocks to the pool, evicting
+	// what no longer fits, and drop whatever no longer applies to the
+	// restored state
+	evicted := bc.txPool.Prepend(returned)
+	bc.reportDropped(dropped(evicted, DropEvicted), height, fmt.Sprintf("transaction evicted by a rollback to height %d", height))
+	removed := make(map[[32]byte]bool)
+	for i := range evicted {
+		removed[evicted[i].Hash] = true
+	}
+	drops := bc.revalidatePool()
+	for _, drop := range drops {
+		removed[drop.tx.Hash] = true
+	}
+	bc.reportDropped(drops, height, fmt.Sprintf("transaction no longer valid after rollback to height %d", height))
+	result := &RollbackResult{Height: height, Dropped: int(tip - height)}
+	for i := range returned {
+		if !removed[returned[i].Hash] {
+			result.Requeued++
+			bc.events.send(Event{Type: EventNewPendingTx, Transaction: &returned[i]})
+		}
+	}
+
+	bc.updateGauges()
+	bc.events.send(Event{Type: EventChainRolledBack, Height: height, Block: bc.blocks[height]})
+	bc.logger.Warn("Chain rolled back", "height", height, logging.Hash("hash", bc.blocks[height].ComputeHash()),
+		"dropped", result.Dropped, "requeued", result.Requeued)
+	return result, nil
+}
+
+// submittedHeight returns the height of the highest block whose proof was
+// submitted to Fabric, finalized or not. The caller must hold the lock.
+func (bc *Blockchain) submittedHeight() uint64 {
+	for h := len(bc.blocks) - 1; h > int(bc.base); h-- {
+		switch bc.blocks[h].Status {
+		case transaction.StatusSubmitted, transaction.StatusFinalized:
+			return uint64(h)
+		}
+	}
+	return bc.base
+}
+
+// touchedAddresses returns the addresses whose account state b may change
+func touchedAddresses(b *block.Block) []string {
+	seen := make(map[string]bool)
+	var addresses []string
+	for _, tx := range b.Transactions {
+		for _, addr := range []string{tx.From, tx.To} {
+			if addr != "" && !seen[addr] {
+				seen[addr] = true
+				addresses = append(addresses, addr)
+			}
+		}
+	}
+	return addresses
+}
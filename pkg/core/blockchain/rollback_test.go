@@ -0,0 +1,113 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/store"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+)
+
+func TestRollbackTo(t *testing.T) {
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	bc, err := NewBlockchainWithStore(st)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	// Keep blocks proven, as submitted blocks cannot be rolled back
+	submitter := &testSubmitter{err: errors.New("fabric unavailable")}
+	bc.SetProofSubmitter(submitter)
+
+	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	bc.SetPublicKey("0000000000000000000000000000000000000001", &privateKey.PublicKey)
+	addTransfer := func(nonce uint64) transaction.Transaction {
+		t.Helper()
+		tx := createTestTransaction(100, nonce)
+		if err := tx.SignTransaction(privateKey); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+		tx.Hash = tx.ComputeHash()
+		if err := bc.AddTransaction(tx); err != nil {
+			t.Fatalf("Failed to add transaction: %v", err)
+		}
+		return tx
+	}
+
+	addTransfer(0)
+	if err := bc.CreateBlock(); err != nil {
+		t.Fatalf("Failed to create block: %v", err)
+	}
+	stateRoot := bc.GetStateRoot()
+	balance := bc.GetBalance("0000000000000000000000000000000000000002")
+
+	dropped := addTransfer(1)
+	if err := bc.CreateBlock(); err != nil {
+		t.Fatalf("Failed to create block: %v", err)
+	}
+	pooled := addTransfer(2)
+
+	if _, err := bc.RollbackTo(5); err == nil {
+		t.Error("Expected rollback above the tip to fail")
+	}
+
+	// A block whose submission may have reached Fabric is kept, along with
+	// every block below it
+	if _, err := bc.setBlockStatus(1, transaction.StatusSubmitted, nil); err != nil {
+		t.Fatalf("Failed to set block status: %v", err)
+	}
+	if _, err := bc.RollbackTo(0); err == nil {
+		t.Error("Expected rollback of a submitted block to fail")
+	}
+	result, err := bc.RollbackTo(1)
+	if err != nil {
+		t.Fatalf("Failed to roll back: %v", err)
+	}
+	if result.Height != 1 || result.Dropped != 1 || result.Requeued != 1 {
+		t.Errorf("Unexpected rollback result %+v", result)
+	}
+	if bc.GetHeight() != 2 || bc.GetStateRoot() != stateRoot {
+		t.Errorf("Expected chain at height 2 with state root %s, got height %d root %s", stateRoot, bc.GetHeight(), bc.GetStateRoot())
+	}
+	if got := bc.GetBalance("0000000000000000000000000000000000000002"); got != balance {
+		t.Errorf("Expected balance %v after rollback, got %v", balance, got)
+	}
+	if got := bc.GetNonce("0000000000000000000000000000000000000001"); got != 1 {
+		t.Errorf("Expected nonce 1 after rollback, got %d", got)
+	}
+
+	// The dropped transaction goes back ahead of the one still pooled
+	pool := bc.GetTransactionPool()
+	if len(pool) != 2 || pool[0].Hash != dropped.Hash || pool[1].Hash != pooled.Hash {
+		t.Fatalf("Expected dropped transaction to be requeued first, got %v", pool)
+	}
+	if got := bc.GetTransactionByHash(dropped.Hash); got == nil || got.Status != transaction.StatusPending {
+		t.Errorf("Expected dropped transaction to be pending, got %v", got)
+	}
+
+	// The rollback is persisted
+	reopened, err := NewBlockchainWithStore(st)
+	if err != nil {
+		t.Fatalf("Failed to reopen blockchain: %v", err)
+	}
+	if reopened.GetHeight() != 2 || reopened.GetStateRoot() != stateRoot {
+		t.Errorf("Expected persisted chain at height 2, got %d", reopened.GetHeight())
+	}
+
+	// Production continues on top of the restored state
+	submitter.setErr(nil)
+	if err := bc.CreateBlock(); err != nil {
+		t.Fatalf("Failed to create block after rollback: %v", err)
+	}
+	if latest := bc.GetLatestBlock(); latest.Header.Height != 2 || len(latest.Transactions) != 2 {
+		t.Errorf("Expected block 2 with both transactions, got block %d with %d", latest.Header.Height, len(latest.Transactions))
+	}
+	if _, err := bc.RollbackTo(0); err == nil {
+		t.Error("Expected rollback of finalized blocks to fail")
+	}
+}
@@ -0,0 +1,185 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/block"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/state"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+	"github.com/StupidBug/fabric-zkrollup/pkg/zk"
+)
+
+// devSequencerScalar is the private scalar of the development sequencer key
+const devSequencerScalar = "dbc2ee0fc8e6314006bc44b93ed6e6621c2f2c7dc88d6c218a1dddd3d852ef09"
+
+// DevSequencerKey returns the well-known sequencer key used when none is
+// configured. Anyone can sign blocks with it, so a chain pinning it offers no
+// protection against forged blocks; it is meant for development and tests.
+func DevSequencerKey() *ecdsa.PrivateKey {
+	d, _ := new(big.Int).SetString(devSequencerScalar, 16)
+	key := &ecdsa.PrivateKey{D: d}
+	key.PublicKey.Curve = elliptic.P256()
+	key.PublicKey.X, key.PublicKey.Y = elliptic.P256().ScalarBaseMult(d.Bytes())
+	return key
+}
+
+// Genesis holds the chain parameters fixed at genesis. Every node of a chain
+// must use the same genesis.
+type Genesis struct {
+	SequencerKey *ecdsa.PublicKey // Key the first block must be signed with
+	// Prover holding the circuit keys that blocks are proven and verified
+	// with. Nodes share the keys of one setup, as proofs made with other
+	// keys are rejected. If nil, the keys are kept in the data directory,
+	// or only in memory for an in-memory chain.
+	Prover *zk.Prover
+}
+
+// DefaultGenesis returns the genesis used when none is configured, which pins
+// the development sequencer key
+func DefaultGenesis() Genesis {
+	return Genesis{SequencerKey: &DevSequencerKey().PublicKey}
+}
+
+// LoadSequencerKey reads a PEM encoded P-256 private key, in SEC 1 or PKCS #8
+// form, from path
+func LoadSequencerKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sequencer key: %v", err)
+	}
+	p, _ := pem.Decode(data)
+	if p == nil {
+		return nil, fmt.Errorf("sequencer key %s is not PEM encoded", path)
+	}
+
+	var key *ecdsa.PrivateKey
+	switch p.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(p.Bytes)
+	case "PRIVATE KEY":
+		parsed, parseErr := x509.ParsePKCS8PrivateKey(p.Bytes)
+		ecKey, ok := parsed.(*ecdsa.PrivateKey)
+		if parseErr == nil && !ok {
+			parseErr = fmt.Errorf("not an ECDSA key")
+		}
+		key, err = ecKey, parseErr
+	default:
+		return nil, fmt.Errorf("unexpected PEM block %q in sequencer key %s", p.Type, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse sequencer key %s: %v", path, err)
+	}
+	if key.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("sequencer key %s is not a P-256 key", path)
+	}
+	return key, nil
+}
+
+// SetSequencerKey replaces the key this node signs the blocks it produces
+// with. Blocks are only produced while it matches the authorized sequencer
+// key, which changes when a rotation transaction is included in a block.
+func (bc *Blockchain) SetSequencerKey(key *ecdsa.PrivateKey) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.sequencerKey = key
+}
+
+// GetSequencerKey returns the key the next block must be signed with
+func (bc *Blockchain) GetSequencerKey() *ecdsa.PublicKey {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.state.GetSequencerKey()
+}
+
+// checkSequencer checks that b is signed by the sequencer authorized in st,
+// the state after its parent
+func checkSequencer(st *state.State, b *block.Block) error {
+	authorized := st.GetSequencerKey()
+	if authorized == nil || !b.Header.Sequencer.Equal(transaction.NewPublicKey(authorized)) {
+		return fmt.Errorf("block is not produced by the authorized sequencer")
+	}
+	return b.VerifySignature()
+}
+
+// checkTransactionType checks the fields that depend on the transaction type.
+// Only governance transactions may be sent from the governance address.
+func checkTransactionType(tx *transaction.Transaction) error {
+	switch tx.Type {
+	case transaction.TypeTransfer:
+		if tx.From == transaction.GovernanceAddress || tx.To == transaction.GovernanceAddress {
+			return fmt.Errorf("transfers cannot involve the governance address")
+		}
+		if tx.To == "" {
+			return fmt.Errorf("transfers need a recipient")
+		}
+		if len(tx.Data) != 0 {
+			return fmt.Errorf("transfers cannot carry data")
+		}
+	case transaction.TypeRotateSequencer:
+		if tx.From != transaction.GovernanceAddress {
+			return fmt.Errorf("governance transactions must be sent from the governance address")
+		}
+		if tx.To != "" || !tx.Value.IsZero() {
+			return fmt.Errorf("governance transactions cannot transfer value")
+		}
+		if _, err := transaction.ParsePublicKey(tx.Data); err != nil {
+			return fmt.Errorf("invalid new sequencer key: %v", err)
+		}
+	default:
+		return fmt.Errorf("unknown transaction type %d", tx.Type)
+	}
+	return nil
+}
+
+// checkGovernanceSigner checks that a governance transaction is signed by
+// the sequencer authorized in st. Other transactions always pass.
+func checkGovernanceSigner(st *state.State, tx *transaction.Transaction) error {
+	if tx.From != transaction.GovernanceAddress {
+		return nil
+	}
+	authorized := st.GetSequencerKey()
+	if authorized == nil || !tx.PublicKey.Equal(transaction.NewPublicKey(authorized)) {
+		return fmt.Errorf("not signed by the authorized sequencer")
+	}
+	return nil
+}
+
+// signerKey returns the key that must have signed a transaction from address
+// in st: the sequencer key for governance transactions and the account key
+// otherwise
+func signerKey(st *state.State, address string) *ecdsa.PublicKey {
+	if address == transaction.GovernanceAddress {
+		return st.GetSequencerKey()
+	}
+	return st.GetPublicKey(address)
+}
+
+// senderKey returns the key tx must be signed with in st. An account that
+// has a key keeps it; an account without one is bound to the key tx carries,
+// but only if the address is derived from that key, so nobody can claim
+// another account. It returns nil if there is no such key.
+func senderKey(st *state.State, tx *transaction.Transaction) *ecdsa.PublicKey {
+	if pubKey := signerKey(st, tx.From); pubKey != nil {
+		return pubKey
+	}
+	if tx.From == transaction.GovernanceAddress || tx.PublicKey.Address() != tx.From {
+		return nil
+	}
+	return tx.PublicKey.ECDSA()
+}
+
+// applyGovernance applies a governance transaction to st. The governance
+// address has a nonce but no balance, so it stays out of the state root.
+func applyGovernance(st *state.State, tx *transaction.Transaction) {
+	st.SetNonce(tx.From, tx.Nonce+1)
+	if tx.Type == transaction.TypeRotateSequencer {
+		key, _ := transaction.ParsePublicKey(tx.Data)
+		st.SetSequencerKey(key.ECDSA())
+	}
+}
@@ -0,0 +1,186 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/store"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/state"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+)
+
+func TestSequencerRotation(t *testing.T) {
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	bc, err := NewBlockchainWithStore(st)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	bc.SetProofSubmitter(&testSubmitter{})
+
+	accountKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	bc.SetPublicKey("0000000000000000000000000000000000000001", &accountKey.PublicKey)
+	addTransfer := func(nonce uint64) {
+		t.Helper()
+		tx := createTestTransaction(100, nonce)
+		if err := tx.SignTransaction(accountKey); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+		tx.Hash = tx.ComputeHash()
+		if err := bc.AddTransaction(tx); err != nil {
+			t.Fatalf("Failed to add transaction: %v", err)
+		}
+	}
+
+	// Only the current sequencer can rotate the key
+	forged := transaction.NewRotateSequencerTx(&newKey.PublicKey, 0)
+	forged.SignTransaction(newKey)
+	if err := bc.AddTransaction(forged); err == nil {
+		t.Error("Expected rotation signed by another key to be rejected")
+	}
+
+	rotation := transaction.NewRotateSequencerTx(&newKey.PublicKey, 0)
+	if err := rotation.SignTransaction(DevSequencerKey()); err != nil {
+		t.Fatalf("Failed to sign rotation: %v", err)
+	}
+	if err := bc.AddTransaction(rotation); err != nil {
+		t.Fatalf("Failed to add rotation: %v", err)
+	}
+	addTransfer(0)
+	if err := bc.CreateBlock(); err != nil {
+		t.Fatalf("Failed to create block: %v", err)
+	}
+	if !newKey.PublicKey.Equal(bc.GetSequencerKey()) {
+		t.Fatal("Expected sequencer key to be rotated")
+	}
+	rotated := bc.GetLatestBlock()
+
+	// The proof commits to the rotation, not only to the transfers
+	if err := checkProof(bc.verifier, rotated); err != nil {
+		t.Fatalf("Expected the rotation block proof to verify: %v", err)
+	}
+	tampered := *rotated
+	tampered.Transactions = append([]transaction.Transaction(nil), rotated.Transactions...)
+	for i := range tampered.Transactions {
+		if tampered.Transactions[i].Type == transaction.TypeRotateSequencer {
+			tampered.Transactions[i].Data = transaction.NewPublicKey(&accountKey.PublicKey).Bytes()
+		}
+	}
+	if err := checkProof(bc.verifier, &tampered); err == nil {
+		t.Error("Expected the proof to be rejected for another rotation")
+	}
+
+	// The old key can no longer produce blocks
+	addTransfer(1)
+	if err := bc.CreateBlock(); err == nil {
+		t.Fatal("Expected block production with the old key to fail")
+	}
+	bc.SetSequencerKey(newKey)
+	if err := bc.CreateBlock(); err != nil {
+		t.Fatalf("Failed to create block with the new key: %v", err)
+	}
+	latest := bc.GetLatestBlock()
+	if !latest.Header.Sequencer.Equal(transaction.NewPublicKey(&newKey.PublicKey)) {
+		t.Error("Expected block to be produced by the new key")
+	}
+	if err := bc.VerifyBlock(latest); err != nil {
+		t.Errorf("Expected block signed by the new key to verify: %v", err)
+	}
+
+	// The rotation is replayed on restart
+	reopened, err := NewBlockchainWithStore(st)
+	if err != nil {
+		t.Fatalf("Failed to reopen blockchain: %v", err)
+	}
+	if !newKey.PublicKey.Equal(reopened.GetSequencerKey()) {
+		t.Error("Expected rotated sequencer key after restart")
+	}
+
+	// A follower pinning a different genesis key rejects the chain
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	follower, err := NewBlockchainWithGenesis(Genesis{SequencerKey: &other.PublicKey}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create follower: %v", err)
+	}
+	if err := follower.ImportBlock(rotated); err == nil {
+		t.Error("Expected block from an unpinned sequencer to be rejected")
+	}
+}
+
+func TestSenderKey(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	derived := transaction.NewPublicKey(&key.PublicKey).Address()
+	st := state.NewState()
+
+	// A new account is bound only to a key its address is derived from
+	tx := &transaction.Transaction{From: derived, PublicKey: transaction.NewPublicKey(&key.PublicKey)}
+	if pubKey := senderKey(st, tx); pubKey == nil || !pubKey.Equal(&key.PublicKey) {
+		t.Error("Expected the carried key of a derived address")
+	}
+	claimed := &transaction.Transaction{From: "0000000000000000000000000000000000000001", PublicKey: tx.PublicKey}
+	if senderKey(st, claimed) != nil {
+		t.Error("Expected no key for an address not derived from the carried key")
+	}
+
+	// Once bound, the account key wins over any carried key
+	st.SetPublicKey(derived, &key.PublicKey)
+	tx.PublicKey = transaction.NewPublicKey(&other.PublicKey)
+	if pubKey := senderKey(st, tx); pubKey == nil || !pubKey.Equal(&key.PublicKey) {
+		t.Error("Expected the bound key to be kept")
+	}
+
+	// A registered key cannot be replaced
+	bc := newTestBlockchain(t)
+	if err := bc.SetPublicKey(claimed.From, &key.PublicKey); err != nil {
+		t.Fatalf("Failed to register key: %v", err)
+	}
+	if err := bc.SetPublicKey(claimed.From, &other.PublicKey); err == nil {
+		t.Error("Expected a different key to be refused")
+	}
+}
+
+func TestTransferToUnknownAccount(t *testing.T) {
+	bc := newTestBlockchain(t)
+	senderKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	bc.SetPublicKey("0000000000000000000000000000000000000001", &senderKey.PublicKey)
+	send := func(to string) error {
+		tx := createTestTransaction(10, 0)
+		tx.To = to
+		if err := tx.SignTransaction(senderKey); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+		tx.Hash = tx.ComputeHash()
+		return bc.AddTransaction(tx)
+	}
+
+	// The circuit proves blocks against the accounts of the parent state,
+	// so a transfer creating an account is refused
+	var rejected *RejectError
+	derived := transaction.NewPublicKey(&key.PublicKey).Address()
+	if err := send(derived); !errors.As(err, &rejected) || rejected.Reason != RejectUnknownAccount {
+		t.Fatalf("Expected rejection %q, got %v", RejectUnknownAccount, err)
+	}
+
+	// A transfer between existing accounts is produced and proven
+	if err := send("0000000000000000000000000000000000000002"); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+	if err := bc.CreateBlock(); err != nil {
+		t.Fatalf("Failed to create block: %v", err)
+	}
+	b, err := bc.GetBlock(1)
+	if err != nil {
+		t.Fatalf("Failed to get block: %v", err)
+	}
+	if b.Status == transaction.StatusExecuted || b.Proof == nil {
+		t.Errorf("Expected block to be proven, got status %v", b.Status)
+	}
+}
@@ -0,0 +1,240 @@
+package blockchain
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/store"
+	"github.com/StupidBug/fabric-zkrollup/pkg/crypto"
+	"github.com/StupidBug/fabric-zkrollup/pkg/logging"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/block"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/state"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+	"github.com/StupidBug/fabric-zkrollup/pkg/zk"
+)
+
+// snapshotVersion is the version of the snapshot format. Version 2 dropped
+// the account public keys, which the state root does not cover.
+const snapshotVersion = 2
+
+// snapshotName is the store key of the snapshot a chain was bootstrapped from
+const snapshotName = "snapshot"
+
+// Snapshot is the complete account state after a finalized block, together
+// with that block and its proof. A node can start from a snapshot instead of
+// replaying the chain from genesis.
+type Snapshot struct {
+	Version   int               `json:"version"`
+	Height    uint64            `json:"height"`
+	StateRoot string            `json:"state_root"`
+	Block     *block.Block      `json:"block"`
+	Accounts  []SnapshotAccount `json:"accounts"` // Sorted by address
+	// Uncompressed key the block after the snapshot must be signed with
+	SequencerKey    []byte `json:"sequencer_key"`
+	GovernanceNonce uint64 `json:"governance_nonce"`
+}
+
+// SnapshotAccount is an account in a snapshot. Public keys are not part of
+// it: derived addresses bind their key again with their next transaction, and
+// other keys are registered on every node with SetPublicKey.
+type SnapshotAccount struct {
+	Address string       `json:"address"`
+	Balance types.Amount `json:"balance"`
+	Nonce   uint64       `json:"nonce"`
+}
+
+// ExportSnapshot returns a snapshot of the state after the block at height.
+// Only finalized blocks can be exported, as later blocks may still be rolled
+// back.
+func (bc *Blockchain) ExportSnapshot(height uint64) (*Snapshot, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if height == 0 {
+		return nil, fmt.Errorf("cannot export a snapshot of the genesis block")
+	}
+	if err := bc.checkHistoryHeight(height); err != nil {
+		return nil, err
+	}
+	b := bc.blocks[height]
+	if b.Status != transaction.StatusFinalized {
+		return nil, fmt.Errorf("block %d is not finalized yet", height)
+	}
+
+	st := bc.stateAt(height)
+	snap := &Snapshot{
+		Version:         snapshotVersion,
+		Height:          height,
+		StateRoot:       b.Header.StateRoot,
+		Block:           b,
+		SequencerKey:    transaction.NewPublicKey(st.GetSequencerKey()).Bytes(),
+		GovernanceNonce: st.GetNonce(transaction.GovernanceAddress),
+	}
+	for addr, acc := range st.GetAllAccounts() {
+		snap.Accounts = append(snap.Accounts, SnapshotAccount{Address: addr, Balance: acc.Balance, Nonce: acc.Nonce})
+	}
+	sort.Slice(snap.Accounts, func(i, j int) bool {
+		return snap.Accounts[i].Address < snap.Accounts[j].Address
+	})
+	return snap, nil
+}
+
+// ImportSnapshot verifies snap against the sequencer key and circuit keys of
+// genesis and initializes the empty store st with it. The snapshot block must
+// be signed with the genesis sequencer key, so a chain that rotated its key
+// is imported with a genesis pinning the rotated key. A chain opened from st
+// with genesis afterwards starts at the snapshot block and continues from
+// there. The snapshot cannot prove that its block belongs to the chain of a
+// given genesis, so the block hash should be compared with a trusted node.
+func ImportSnapshot(st *store.Store, snap *Snapshot, genesis Genesis) error {
+	if genesis.SequencerKey == nil {
+		return fmt.Errorf("genesis has no sequencer key")
+	}
+	verifier, err := genesisProver(genesis, st)
+	if err != nil {
+		return err
+	}
+	if _, err := verifySnapshot(snap, genesis, verifier); err != nil {
+		return fmt.Errorf("invalid snapshot: %v", err)
+	}
+	blocks, err := st.LoadBlocks()
+	if err != nil {
+		return fmt.Errorf("failed to load blocks: %v", err)
+	}
+	if len(blocks) != 0 {
+		return fmt.Errorf("data directory already holds a chain")
+	}
+
+	// The block goes last, so an interrupted import leaves no chain behind
+	if err := st.Put(snapshotName, snap); err != nil {
+		return fmt.Errorf("failed to persist snapshot: %v", err)
+	}
+	b := *snap.Block
+	b.Transactions = make([]transaction.Transaction, len(snap.Block.Transactions))
+	copy(b.Transactions, snap.Block.Transactions)
+	for i := range b.Transactions {
+		b.Transactions[i].Status = transaction.StatusFinalized
+	}
+	b.Status = transaction.StatusFinalized
+	if err := st.PutBlock(&b); err != nil {
+		return fmt.Errorf("failed to persist snapshot block: %v", err)
+	}
+	return nil
+}
+
+// loadSnapshot sets the state from the stored snapshot whose block is b, the
+// first stored block, and makes b the base of the chain. Like a stored
+// genesis block, the snapshot is checked against the genesis of the chain.
+func (bc *Blockchain) loadSnapshot(b *block.Block) error {
+	var snap Snapshot
+	if err := bc.store.Get(snapshotName, &snap); err != nil {
+		return fmt.Errorf("failed to load snapshot: %v", err)
+	}
+	st, err := verifySnapshot(&snap, bc.genesis, bc.verifier)
+	if err != nil {
+		return err
+	}
+	if snap.Block.ComputeHash() != b.ComputeHash() {
+		return fmt.Errorf("snapshot block does not match stored block %d", b.Header.Height)
+	}
+	bc.state = st
+	bc.base = snap.Height
+	bc.logger.Info("Starting from snapshot", "height", snap.Height, logging.Hash("hash", b.ComputeHash()),
+		"state_root", snap.StateRoot)
+	return nil
+}
+
+// verifySnapshot checks that the accounts of snap hash to the state root of
+// its block and that the block is signed with the sequencer key of genesis
+// and proven, and returns the state the snapshot holds. Fields outside the
+// state root are only accepted as far as the block vouches for them: the
+// sequencer key must be the one authorized after the block, and no nonce may
+// be below the transactions of the block.
+func verifySnapshot(snap *Snapshot, genesis Genesis, verifier *zk.Prover) (*state.State, error) {
+	if snap.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+	b := snap.Block
+	if b == nil {
+		return nil, fmt.Errorf("snapshot has no block")
+	}
+	if snap.Height == 0 || b.Header.Height != snap.Height {
+		return nil, fmt.Errorf("snapshot height %d does not match block %d", snap.Height, b.Header.Height)
+	}
+	if b.Header.StateRoot != snap.StateRoot {
+		return nil, fmt.Errorf("snapshot state root does not match block state root")
+	}
+
+	st, err := snap.state()
+	if err != nil {
+		return nil, err
+	}
+	if stateRoot := computeStateRoot(st); stateRoot != b.Header.StateRoot {
+		return nil, fmt.Errorf("state root mismatch: block has %s, accounts give %s", b.Header.StateRoot, stateRoot)
+	}
+
+	if uint32(len(b.Transactions)) != b.Header.TransactionCount {
+		return nil, fmt.Errorf("transaction count mismatch")
+	}
+	if !crypto.VerifyTransactionMerkleRoot(b.Transactions, b.Header.MerkleRoot) {
+		return nil, fmt.Errorf("merkle root mismatch")
+	}
+	if !b.Header.Sequencer.Equal(transaction.NewPublicKey(genesis.SequencerKey)) {
+		return nil, fmt.Errorf("snapshot block is not signed by the genesis sequencer key")
+	}
+	if err := b.VerifySignature(); err != nil {
+		return nil, err
+	}
+	if !transaction.NewPublicKey(st.GetSequencerKey()).Equal(sequencerAfter(b)) {
+		return nil, fmt.Errorf("snapshot sequencer key does not match block")
+	}
+	for _, tx := range b.Transactions {
+		if st.GetNonce(tx.From) <= tx.Nonce {
+			return nil, fmt.Errorf("snapshot nonce of %s is below transaction %x", tx.From, tx.Hash)
+		}
+	}
+	if err := checkProof(verifier, b); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// state returns the account state the snapshot holds
+func (snap *Snapshot) state() (*state.State, error) {
+	st := state.NewState()
+	seen := make(map[string]bool)
+	for _, account := range snap.Accounts {
+		if seen[account.Address] {
+			return nil, fmt.Errorf("duplicate account %s", account.Address)
+		}
+		seen[account.Address] = true
+		st.SetBalance(account.Address, account.Balance)
+		if account.Nonce > 0 {
+			st.SetNonce(account.Address, account.Nonce)
+		}
+	}
+	if snap.GovernanceNonce > 0 {
+		st.SetNonce(transaction.GovernanceAddress, snap.GovernanceNonce)
+	}
+	sequencerKey, err := transaction.ParsePublicKey(snap.SequencerKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sequencer key: %v", err)
+	}
+	st.SetSequencerKey(sequencerKey.ECDSA())
+	return st, nil
+}
+
+// sequencerAfter returns the sequencer key authorized after b: the key of
+// the last rotation in b, or the key b was signed with
+func sequencerAfter(b *block.Block) transaction.PublicKey {
+	key := b.Header.Sequencer
+	for _, tx := range b.Transactions {
+		if tx.Type == transaction.TypeRotateSequencer {
+			if rotated, err := transaction.ParsePublicKey(tx.Data); err == nil {
+				key = rotated
+			}
+		}
+	}
+	return key
+}
@@ -0,0 +1,152 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/store"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+)
+
+func TestSnapshot(t *testing.T) {
+	bc := newTestBlockchain(t)
+	bc.SetProofSubmitter(&testSubmitter{})
+
+	const sender = "0000000000000000000000000000000000000001"
+	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	bc.SetPublicKey(sender, &privateKey.PublicKey)
+	for nonce := uint64(0); nonce < 2; nonce++ {
+		tx := createTestTransaction(100, nonce)
+		if err := tx.SignTransaction(privateKey); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+		tx.Hash = tx.ComputeHash()
+		if err := bc.AddTransaction(tx); err != nil {
+			t.Fatalf("Failed to add transaction: %v", err)
+		}
+		if err := bc.CreateBlock(); err != nil {
+			t.Fatalf("Failed to create block: %v", err)
+		}
+	}
+
+	if _, err := bc.ExportSnapshot(0); err == nil {
+		t.Error("Expected genesis export to fail")
+	}
+	snap, err := bc.ExportSnapshot(1)
+	if err != nil {
+		t.Fatalf("Failed to export snapshot: %v", err)
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatalf("Failed to marshal snapshot: %v", err)
+	}
+	decode := func() *Snapshot {
+		var decoded Snapshot
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("Failed to unmarshal snapshot: %v", err)
+		}
+		return &decoded
+	}
+
+	// Accounts that do not hash to the block's state root are rejected
+	genesis := DefaultGenesis()
+	genesis.Prover = bc.prover
+	tampered := decode()
+	tampered.Accounts[1].Balance, _ = tampered.Accounts[1].Balance.Add(types.NewAmount(100))
+	if err := ImportSnapshot(openTestStore(t), tampered, genesis); err == nil {
+		t.Error("Expected snapshot with tampered balance to be rejected")
+	}
+
+	// So is a proof made with circuit keys the importer does not share
+	if err := ImportSnapshot(openTestStore(t), decode(), DefaultGenesis()); err == nil {
+		t.Error("Expected snapshot proven with other circuit keys to be rejected")
+	}
+
+	// And a block signed by another sequencer than the genesis pins
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err := ImportSnapshot(openTestStore(t), decode(), Genesis{SequencerKey: &other.PublicKey, Prover: bc.prover}); err == nil {
+		t.Error("Expected snapshot signed by an unpinned sequencer to be rejected")
+	}
+
+	// Fields outside the state root must agree with the block
+	tampered = decode()
+	tampered.SequencerKey = transaction.NewPublicKey(&other.PublicKey).Bytes()
+	if err := ImportSnapshot(openTestStore(t), tampered, genesis); err == nil {
+		t.Error("Expected snapshot with another sequencer key to be rejected")
+	}
+	tampered = decode()
+	for i := range tampered.Accounts {
+		if tampered.Accounts[i].Address == sender {
+			tampered.Accounts[i].Nonce = 0
+		}
+	}
+	if err := ImportSnapshot(openTestStore(t), tampered, genesis); err == nil {
+		t.Error("Expected snapshot with a nonce below its block to be rejected")
+	}
+
+	st := openTestStore(t)
+	if err := ImportSnapshot(st, decode(), genesis); err != nil {
+		t.Fatalf("Failed to import snapshot: %v", err)
+	}
+	if err := ImportSnapshot(st, decode(), genesis); err == nil {
+		t.Error("Expected import into a non-empty store to fail")
+	}
+	follower, err := NewBlockchainWithGenesis(genesis, st)
+	if err != nil {
+		t.Fatalf("Failed to open chain from snapshot: %v", err)
+	}
+	if follower.GetHeight() != 2 || follower.GetBalance(sender) != types.NewAmount(999900) || follower.GetNonce(sender) != 1 {
+		t.Errorf("Unexpected state after import: height %d, balance %v, nonce %d",
+			follower.GetHeight(), follower.GetBalance(sender), follower.GetNonce(sender))
+	}
+	if _, err := follower.GetBlock(0); err == nil {
+		t.Error("Expected blocks below the snapshot to be unavailable")
+	}
+	if _, err := follower.GetBalanceAt(sender, 0); err == nil {
+		t.Error("Expected state below the snapshot to be unavailable")
+	}
+
+	// The snapshot holds no public keys, so the follower registers the key
+	// of the genesis account like every other node, and continues from the
+	// snapshot
+	if follower.GetPublicKey(sender) != nil {
+		t.Error("Expected no public key from the snapshot")
+	}
+	if err := follower.SetPublicKey(sender, &privateKey.PublicKey); err != nil {
+		t.Fatalf("Failed to register key: %v", err)
+	}
+	next, _ := bc.GetBlock(2)
+	if err := follower.ImportBlock(next); err != nil {
+		t.Fatalf("Failed to import block after snapshot: %v", err)
+	}
+	if _, err := follower.RollbackTo(0); err == nil {
+		t.Error("Expected rollback below the snapshot to fail")
+	}
+
+	reopened, err := NewBlockchainWithGenesis(genesis, st)
+	if err != nil {
+		t.Fatalf("Failed to reopen chain: %v", err)
+	}
+	if reopened.GetStateRoot() != bc.GetStateRoot() || reopened.GetBalance(sender) != types.NewAmount(999800) {
+		t.Errorf("Expected reopened chain at the source tip, got root %s", reopened.GetStateRoot())
+	}
+	if got := reopened.GetTransactionByHash(next.Transactions[0].Hash); got == nil {
+		t.Error("Expected transaction after the snapshot to be indexed")
+	}
+	if len(reopened.GetBlocks()) != 2 {
+		t.Errorf("Expected 2 blocks held, got %d", len(reopened.GetBlocks()))
+	}
+}
+
+func openTestStore(t *testing.T) *store.Store {
+	t.Helper()
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	return st
+}
@@ -0,0 +1,118 @@
+package follower
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/blockchain"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/block"
+)
+
+// syncBatchSize is the number of blocks requested from the sequencer at once
+const syncBatchSize = 20
+
+// syncBlocksResponse mirrors the sequencer's /sync/blocks response
+type syncBlocksResponse struct {
+	Height uint64         `json:"height"`
+	Blocks []*block.Block `json:"blocks"`
+}
+
+// Follower keeps a local chain in sync with a sequencer. Every block pulled
+// from the sequencer is re-executed against local state and its proof
+// verified before it is imported, so the local chain never trusts the
+// sequencer's output.
+type Follower struct {
+	bc           *blockchain.Blockchain
+	sequencerURL string
+	interval     time.Duration
+	client       *http.Client
+	logger       *slog.Logger
+}
+
+// NewFollower creates a follower that syncs bc from the sequencer API at
+// sequencerURL, polling every interval
+func NewFollower(bc *blockchain.Blockchain, sequencerURL string, interval time.Duration, logger *slog.Logger) *Follower {
+	return &Follower{
+		bc:           bc,
+		sequencerURL: strings.TrimSuffix(sequencerURL, "/"),
+		interval:     interval,
+		client:       &http.Client{Timeout: 30 * time.Second},
+		logger:       logger.With("sequencer", strings.TrimSuffix(sequencerURL, "/")),
+	}
+}
+
+// SyncOnce pulls and imports blocks until the local chain has caught up with
+// the sequencer. It returns the number of blocks imported.
+func (f *Follower) SyncOnce(ctx context.Context) (int, error) {
+	imported := 0
+	for {
+		from := f.bc.GetHeight()
+		resp, err := f.fetchBlocks(ctx, from)
+		if err != nil {
+			return imported, err
+		}
+
+		for _, b := range resp.Blocks {
+			if err := f.bc.ImportBlock(b); err != nil {
+				return imported, fmt.Errorf("failed to import block %d: %v", b.Header.Height, err)
+			}
+			imported++
+		}
+
+		if len(resp.Blocks) == 0 || f.bc.GetHeight() >= resp.Height {
+			return imported, nil
+		}
+	}
+}
+
+// Run syncs with the sequencer every interval until ctx is cancelled
+func (f *Follower) Run(ctx context.Context) {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for {
+		imported, err := f.SyncOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			f.logger.Warn("Failed to sync with sequencer", "height", f.bc.GetHeight(), "err", err)
+		}
+		if imported > 0 {
+			f.logger.Info("Imported blocks from sequencer", "blocks", imported, "height", f.bc.GetHeight())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetchBlocks requests the blocks starting at height from from the sequencer
+func (f *Follower) fetchBlocks(ctx context.Context, from uint64) (*syncBlocksResponse, error) {
+	url := fmt.Sprintf("%s/api/v1/sync/blocks?from=%d&limit=%d", f.sequencerURL, from, syncBatchSize)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := f.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch blocks: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("sequencer returned status %d", resp.StatusCode)
+	}
+
+	var result syncBlocksResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode blocks: %v", err)
+	}
+	return &result, nil
+}
@@ -0,0 +1,196 @@
+package follower
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/api/router"
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/blockchain"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/block"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
+	"github.com/StupidBug/fabric-zkrollup/pkg/zk"
+	"github.com/gin-gonic/gin"
+)
+
+// testGenesis is the genesis of the test chains, whose circuit keys every
+// node shares
+var testGenesis = func() blockchain.Genesis {
+	genesis := blockchain.DefaultGenesis()
+	genesis.Prover, _ = zk.NewProver(zk.BackendGroth16, "")
+	return genesis
+}()
+
+// newSequencer creates a sequencer chain with one produced block and serves
+// its API on loopback
+func newSequencer(t *testing.T) (*blockchain.Blockchain, *httptest.Server) {
+	gin.SetMode(gin.TestMode)
+	bc, err := blockchain.NewBlockchainWithGenesis(testGenesis, nil)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+	tx := transaction.Transaction{
+		From:      "0000000000000000000000000000000000000001",
+		To:        "0000000000000000000000000000000000000002",
+		Value:     types.NewAmount(100),
+		Nonce:     0,
+		Status:    transaction.StatusPending,
+		Timestamp: time.Now().Unix(),
+	}
+	bc.SetPublicKey(tx.From, &privateKey.PublicKey)
+	if err := tx.SignTransaction(privateKey); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	tx.Hash = tx.ComputeHash()
+	if err := bc.AddTransaction(tx); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+	if err := bc.CreateBlock(); err != nil {
+		t.Fatalf("Failed to create block: %v", err)
+	}
+
+	r := router.NewRouter(bc, slog.Default())
+	r.Setup()
+	server := httptest.NewServer(r.Handler())
+	t.Cleanup(server.Close)
+	return bc, server
+}
+
+// newLocal creates a follower chain for sequencer with the key of the
+// genesis account that the sequencer's block spends from registered, as
+// every node must
+func newLocal(t *testing.T, sequencer *blockchain.Blockchain) *blockchain.Blockchain {
+	const sender = "0000000000000000000000000000000000000001"
+	local, err := blockchain.NewBlockchainWithGenesis(testGenesis, nil)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	local.SetPublicKey(sender, sequencer.GetPublicKey(sender))
+	return local
+}
+
+func TestFollowerSync(t *testing.T) {
+	sequencer, server := newSequencer(t)
+
+	local := newLocal(t, sequencer)
+	f := NewFollower(local, server.URL, time.Second, slog.Default())
+	imported, err := f.SyncOnce(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if imported != 1 {
+		t.Errorf("Expected 1 imported block, got %d", imported)
+	}
+
+	if local.GetHeight() != sequencer.GetHeight() {
+		t.Errorf("Expected height %d, got %d", sequencer.GetHeight(), local.GetHeight())
+	}
+	if local.GetStateRoot() != sequencer.GetStateRoot() {
+		t.Errorf("Expected state root %s, got %s", sequencer.GetStateRoot(), local.GetStateRoot())
+	}
+	for _, address := range []string{
+		"0000000000000000000000000000000000000001",
+		"0000000000000000000000000000000000000002",
+	} {
+		if local.GetBalance(address) != sequencer.GetBalance(address) {
+			t.Errorf("Balance mismatch for %s: %v != %v", address, local.GetBalance(address), sequencer.GetBalance(address))
+		}
+	}
+
+	// Syncing again is a no-op
+	imported, err = f.SyncOnce(context.Background())
+	if err != nil || imported != 0 {
+		t.Errorf("Expected nothing to import, got %d: %v", imported, err)
+	}
+
+	// The follower serves the read-only API
+	r := router.NewRouter(local, slog.Default())
+	r.SetupReadOnly()
+	follower := httptest.NewServer(r.Handler())
+	defer follower.Close()
+
+	resp, err := http.Get(follower.URL + "/api/v1/state/root")
+	if err != nil {
+		t.Fatalf("Failed to query follower: %v", err)
+	}
+	var root struct {
+		StateRoot string `json:"stateRoot"`
+	}
+	json.NewDecoder(resp.Body).Decode(&root)
+	resp.Body.Close()
+	if root.StateRoot != sequencer.GetStateRoot() {
+		t.Errorf("Expected follower API to serve state root %s, got %s", sequencer.GetStateRoot(), root.StateRoot)
+	}
+
+	resp, err = http.Post(follower.URL+"/api/v1/transaction/send", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("Failed to query follower: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected follower to reject transaction submission, got status %d", resp.StatusCode)
+	}
+}
+
+func TestFollowerRejectsForgedBlock(t *testing.T) {
+	sequencer, _ := newSequencer(t)
+
+	// Serve the sequencer's blocks with a forged state root
+	forger := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := *sequencer.GetLatestBlock()
+		b.Header.StateRoot = "1"
+		json.NewEncoder(w).Encode(syncBlocksResponse{
+			Height: sequencer.GetHeight(),
+			Blocks: []*block.Block{&b},
+		})
+	}))
+	defer forger.Close()
+
+	local := newLocal(t, sequencer)
+	f := NewFollower(local, forger.URL, time.Second, slog.Default())
+	if _, err := f.SyncOnce(context.Background()); err == nil {
+		t.Error("Expected forged block to be rejected")
+	}
+	if local.GetHeight() != 1 {
+		t.Errorf("Expected follower to stay at genesis, got height %d", local.GetHeight())
+	}
+}
+
+func TestFollowerRun(t *testing.T) {
+	sequencer, server := newSequencer(t)
+
+	local := newLocal(t, sequencer)
+	f := NewFollower(local, server.URL, 10*time.Millisecond, slog.Default())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for local.GetHeight() < sequencer.GetHeight() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if local.GetHeight() != sequencer.GetHeight() {
+		t.Errorf("Expected follower to reach height %d, got %d", sequencer.GetHeight(), local.GetHeight())
+	}
+}
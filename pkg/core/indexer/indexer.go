@@ -0,0 +1,173 @@
+package indexer
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/block"
+)
+
+// TxLocation is the position of a transaction in the chain
+type TxLocation struct {
+	Height uint64 `json:"height"` // Height of the containing block
+	Index  int    `json:"index"`  // Index of the transaction within the block
+}
+
+// Indexer maps transaction hashes, addresses and block hashes to their place
+// in the chain. It is updated as blocks are committed.
+type Indexer struct {
+	mu        sync.RWMutex
+	txs       map[[32]byte]TxLocation // Transaction hash -> location
+	addresses map[string][]TxLocation // Address -> locations in chain order
+	blocks    map[[32]byte]uint64     // Block hash -> height
+	height    uint64                  // Height of the next block to index
+}
+
+// NewIndexer creates an empty indexer
+func NewIndexer() *Indexer {
+	return &Indexer{
+		txs:       make(map[[32]byte]TxLocation),
+		addresses: make(map[string][]TxLocation),
+		blocks:    make(map[[32]byte]uint64),
+	}
+}
+
+// NewIndexerFrom creates an empty indexer whose first block is at height, for
+// a chain that starts at a snapshot
+func NewIndexerFrom(height uint64) *Indexer {
+	ix := NewIndexer()
+	ix.height = height
+	return ix
+}
+
+// Entry is what a single block adds to the index. Entries are persisted
+// per block, so the index grows with the chain without being rewritten.
+type Entry struct {
+	Height uint64    `json:"height"`
+	Hash   string    `json:"hash"` // Hex encoded block hash
+	Txs    []EntryTx `json:"txs"`
+}
+
+// EntryTx is a transaction of an index entry
+type EntryTx struct {
+	Hash string `json:"hash"` // Hex encoded transaction hash
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// NewEntry returns the index entry of b
+func NewEntry(b *block.Block) Entry {
+	hash := b.ComputeHash()
+	entry := Entry{
+		Height: b.Header.Height,
+		Hash:   hex.EncodeToString(hash[:]),
+		Txs:    make([]EntryTx, len(b.Transactions)),
+	}
+	for i, tx := range b.Transactions {
+		entry.Txs[i] = EntryTx{Hash: hex.EncodeToString(tx.Hash[:]), From: tx.From, To: tx.To}
+	}
+	return entry
+}
+
+// IndexBlock adds a block to the index. Blocks must be indexed in height
+// order.
+func (ix *Indexer) IndexBlock(b *block.Block) error {
+	return ix.Apply(NewEntry(b))
+}
+
+// Apply adds the index entry of a block. Entries must be applied in height
+// order.
+func (ix *Indexer) Apply(entry Entry) error {
+	blockHash, err := decodeHash(entry.Hash)
+	if err != nil {
+		return err
+	}
+	txHashes := make([][32]byte, len(entry.Txs))
+	for i, tx := range entry.Txs {
+		if txHashes[i], err = decodeHash(tx.Hash); err != nil {
+			return err
+		}
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if entry.Height != ix.height {
+		return fmt.Errorf("cannot index block %d: expected height %d", entry.Height, ix.height)
+	}
+
+	ix.blocks[blockHash] = entry.Height
+	for i, tx := range entry.Txs {
+		loc := TxLocation{Height: entry.Height, Index: i}
+		ix.txs[txHashes[i]] = loc
+		ix.addresses[tx.From] = append(ix.addresses[tx.From], loc)
+		if tx.To != tx.From && tx.To != "" {
+			ix.addresses[tx.To] = append(ix.addresses[tx.To], loc)
+		}
+	}
+	ix.height++
+	return nil
+}
+
+// Height returns the height of the next block to index, which is the number
+// of blocks indexed unless the chain starts at a snapshot
+func (ix *Indexer) Height() uint64 {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	return ix.height
+}
+
+// TxLocation returns the location of the transaction with the given hash
+func (ix *Indexer) TxLocation(hash [32]byte) (TxLocation, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	loc, ok := ix.txs[hash]
+	return loc, ok
+}
+
+// BlockHeight returns the height of the block with the given hash
+func (ix *Indexer) BlockHeight(hash [32]byte) (uint64, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	height, ok := ix.blocks[hash]
+	return height, ok
+}
+
+// AddressTransactions returns up to limit locations of transactions sent or
+// received by address, newest first, skipping the first offset. It also
+// returns the total number of transactions for the address.
+func (ix *Indexer) AddressTransactions(address string, offset, limit int) ([]TxLocation, int) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	locs := ix.addresses[address]
+	total := len(locs)
+	if offset < 0 || offset >= total || limit <= 0 {
+		return []TxLocation{}, total
+	}
+
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	page := make([]TxLocation, 0, end-offset)
+	for i := offset; i < end; i++ {
+		page = append(page, locs[total-1-i])
+	}
+	return page, total
+}
+
+// decodeHash parses a hex encoded 32-byte hash
+func decodeHash(s string) ([32]byte, error) {
+	var hash [32]byte
+	decoded, err := hex.DecodeString(s)
+	if err != nil || len(decoded) != len(hash) {
+		return hash, fmt.Errorf("invalid hash %q in index", s)
+	}
+	copy(hash[:], decoded)
+	return hash, nil
+}
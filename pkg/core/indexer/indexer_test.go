@@ -0,0 +1,144 @@
+package indexer
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/block"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+)
+
+func createTestTransaction(from, to string, value int, nonce uint64) transaction.Transaction {
+	tx := transaction.Transaction{
+		From:      from,
+		To:        to,
+		Value:     types.NewAmount(uint64(value)),
+		Nonce:     nonce,
+		Status:    transaction.StatusExecuted,
+		Timestamp: time.Now().Unix(),
+	}
+	tx.Hash = tx.ComputeHash()
+	return tx
+}
+
+func createTestBlock(height uint64, txs ...transaction.Transaction) *block.Block {
+	return &block.Block{
+		Header: block.Header{
+			Version:          1,
+			PrevHash:         [32]byte{byte(height)},
+			Timestamp:        time.Now().UTC(),
+			Height:           height,
+			TransactionCount: uint32(len(txs)),
+		},
+		Transactions: txs,
+	}
+}
+
+func TestIndexBlock(t *testing.T) {
+	ix := NewIndexer()
+	tx1 := createTestTransaction("a", "b", 100, 0)
+	tx2 := createTestTransaction("a", "c", 200, 1)
+	tx3 := createTestTransaction("b", "a", 300, 0)
+
+	genesis := createTestBlock(0)
+	b1 := createTestBlock(1, tx1, tx2)
+	b2 := createTestBlock(2, tx3)
+	for _, b := range []*block.Block{genesis, b1, b2} {
+		if err := ix.IndexBlock(b); err != nil {
+			t.Fatalf("Failed to index block %d: %v", b.Header.Height, err)
+		}
+	}
+
+	// Blocks must be indexed in order
+	if err := ix.IndexBlock(createTestBlock(5)); err == nil {
+		t.Error("Expected error for out of order block")
+	}
+
+	loc, ok := ix.TxLocation(tx2.Hash)
+	if !ok || loc.Height != 1 || loc.Index != 1 {
+		t.Errorf("Unexpected location for tx2: %+v, found %v", loc, ok)
+	}
+	if _, ok := ix.TxLocation([32]byte{0xff}); ok {
+		t.Error("Expected unknown transaction to be missing")
+	}
+
+	height, ok := ix.BlockHeight(b2.ComputeHash())
+	if !ok || height != 2 {
+		t.Errorf("Expected block hash to map to height 2, got %d", height)
+	}
+
+	// Address history is newest first
+	locs, total := ix.AddressTransactions("a", 0, 10)
+	if total != 3 || len(locs) != 3 {
+		t.Fatalf("Expected 3 transactions for a, got %d of %d", len(locs), total)
+	}
+	if locs[0].Height != 2 || locs[2].Height != 1 || locs[2].Index != 0 {
+		t.Errorf("Unexpected history order: %+v", locs)
+	}
+}
+
+func TestAddressTransactionsPagination(t *testing.T) {
+	ix := NewIndexer()
+	ix.IndexBlock(createTestBlock(0))
+	for i := 0; i < 5; i++ {
+		tx := createTestTransaction("a", "b", 100, uint64(i))
+		if err := ix.IndexBlock(createTestBlock(uint64(i+1), tx)); err != nil {
+			t.Fatalf("Failed to index block: %v", err)
+		}
+	}
+
+	locs, total := ix.AddressTransactions("a", 1, 2)
+	if total != 5 || len(locs) != 2 {
+		t.Fatalf("Expected page of 2 out of 5, got %d of %d", len(locs), total)
+	}
+	if locs[0].Height != 4 || locs[1].Height != 3 {
+		t.Errorf("Unexpected page contents: %+v", locs)
+	}
+
+	locs, _ = ix.AddressTransactions("a", 4, 10)
+	if len(locs) != 1 || locs[0].Height != 1 {
+		t.Errorf("Expected last page to hold the oldest transaction, got %+v", locs)
+	}
+
+	locs, total = ix.AddressTransactions("a", 10, 10)
+	if len(locs) != 0 || total != 5 {
+		t.Errorf("Expected empty page past the end, got %d of %d", len(locs), total)
+	}
+}
+
+func TestIndexerEntries(t *testing.T) {
+	tx := createTestTransaction("a", "b", 100, 0)
+	b0, b1 := createTestBlock(0), createTestBlock(1, tx)
+
+	// Entries survive a JSON round trip and rebuild the same index
+	ix := NewIndexer()
+	for _, b := range []*block.Block{b0, b1} {
+		data, err := json.Marshal(NewEntry(b))
+		if err != nil {
+			t.Fatalf("Failed to marshal entry: %v", err)
+		}
+		var entry Entry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			t.Fatalf("Failed to unmarshal entry: %v", err)
+		}
+		if err := ix.Apply(entry); err != nil {
+			t.Fatalf("Failed to apply entry %d: %v", b.Header.Height, err)
+		}
+	}
+	if loc, ok := ix.TxLocation(tx.Hash); !ok || loc.Height != 1 {
+		t.Errorf("Expected tx location at height 1, got %+v", loc)
+	}
+	if height, ok := ix.BlockHeight(b1.ComputeHash()); !ok || height != 1 {
+		t.Errorf("Expected block height 1, got %d", height)
+	}
+	if _, total := ix.AddressTransactions("b", 0, 10); total != 1 {
+		t.Errorf("Expected 1 transaction for b, got %d", total)
+	}
+
+	// Entries out of order are rejected
+	if err := ix.Apply(NewEntry(b1)); err == nil {
+		t.Error("Expected an entry at the wrong height to be rejected")
+	}
+}
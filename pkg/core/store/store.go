@@ -0,0 +1,247 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/indexer"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/block"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/state"
+)
+
+const (
+	blocksDir = "blocks"
+	diffsDir  = "diffs"
+	indexDir  = "index"
+)
+
+// Store persists blocks and chain metadata as JSON files under a data
+// directory. Each block is written to its own file, named by height, and
+// every write goes through a temporary file and a rename so a crash never
+// leaves a half-written file behind.
+type Store struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// Open opens the store in dir, creating the directory layout if needed
+func Open(dir string) (*Store, error) {
+	for _, sub := range []string{blocksDir, diffsDir, indexDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create data directory: %v", err)
+		}
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Dir returns the data directory of the store
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// PutBlock persists a block under its height
+func (s *Store) PutBlock(b *block.Block) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.writeJSON(blockPath(b.Header.Height), b)
+}
+
+// GetBlock loads the block at height
+func (s *Store) GetBlock(height uint64) (*block.Block, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b block.Block
+	if err := s.readJSON(blockPath(height), &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// PutDiff persists the state diff of the block at height
+func (s *Store) PutDiff(height uint64, d *state.Diff) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.writeJSON(diffPath(height), d)
+}
+
+// GetDiff loads the state diff of the block at height. It returns an error
+// satisfying os.IsNotExist if none was stored.
+func (s *Store) GetDiff(height uint64) (*state.Diff, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var d state.Diff
+	if err := s.readJSON(diffPath(height), &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// PutIndexEntry persists the index entry of the block at entry.Height
+func (s *Store) PutIndexEntry(entry indexer.Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.writeJSON(indexPath(entry.Height), entry)
+}
+
+// GetIndexEntry loads the index entry of the block at height. It returns an
+// error satisfying os.IsNotExist if none was stored.
+func (s *Store) GetIndexEntry(height uint64) (*indexer.Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entry indexer.Entry
+	if err := s.readJSON(indexPath(height), &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// LoadBlocks loads all persisted blocks in height order. The heights must be
+// contiguous, starting at zero or, for a chain bootstrapped from a snapshot,
+// at the snapshot height.
+func (s *Store) LoadBlocks() ([]*block.Block, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	heights, err := s.blockHeights()
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := make([]*block.Block, 0, len(heights))
+	for i, height := range heights {
+		if height != heights[0]+uint64(i) {
+			return nil, fmt.Errorf("missing block at height %d", heights[0]+uint64(i))
+		}
+		var b block.Block
+		if err := s.readJSON(blockPath(height), &b); err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, &b)
+	}
+	return blocks, nil
+}
+
+// DeleteBlocksFrom removes the blocks at height and above, together with
+// their state diffs and index entries. They are removed newest first, so an
+// interrupted call still leaves a contiguous chain.
+func (s *Store) DeleteBlocksFrom(height uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	heights, err := s.blockHeights()
+	if err != nil {
+		return err
+	}
+	for i := len(heights) - 1; i >= 0 && heights[i] >= height; i-- {
+		if err := os.Remove(filepath.Join(s.dir, indexPath(heights[i]))); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete index entry %d: %v", heights[i], err)
+		}
+		if err := os.Remove(filepath.Join(s.dir, diffPath(heights[i]))); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete state diff %d: %v", heights[i], err)
+		}
+		if err := os.Remove(filepath.Join(s.dir, blockPath(heights[i]))); err != nil {
+			return fmt.Errorf("failed to delete block %d: %v", heights[i], err)
+		}
+	}
+	return nil
+}
+
+// Put persists an arbitrary JSON value under name
+func (s *Store) Put(name string, v interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.writeJSON(name+".json", v)
+}
+
+// Get loads the JSON value stored under name into v. It returns an error
+// satisfying os.IsNotExist if nothing was stored.
+func (s *Store) Get(name string, v interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.readJSON(name+".json", v)
+}
+
+// blockHeights returns the heights of all persisted blocks in ascending order
+func (s *Store) blockHeights() ([]uint64, error) {
+	files, err := ioutil.ReadDir(filepath.Join(s.dir, blocksDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blocks: %v", err)
+	}
+
+	var heights []uint64
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		height, err := strconv.ParseUint(strings.TrimSuffix(name, ".json"), 10, 64)
+		if err != nil {
+			continue
+		}
+		heights = append(heights, height)
+	}
+	sort.Slice(heights, func(i, j int) bool {
+		return heights[i] < heights[j]
+	})
+	return heights, nil
+}
+
+// writeJSON atomically writes v as JSON to the relative path name
+func (s *Store) writeJSON(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %v", name, err)
+	}
+
+	path := filepath.Join(s.dir, name)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	return nil
+}
+
+// readJSON reads the JSON file at the relative path name into v
+func (s *Store) readJSON(name string, v interface{}) error {
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to unmarshal %s: %v", name, err)
+	}
+	return nil
+}
+
+// blockPath returns the relative path of the block file at height
+func blockPath(height uint64) string {
+	return filepath.Join(blocksDir, fmt.Sprintf("%020d.json", height))
+}
+
+// diffPath returns the relative path of the state diff file at height
+func diffPath(height uint64) string {
+	return filepath.Join(diffsDir, fmt.Sprintf("%020d.json", height))
+}
+
+// indexPath returns the relative path of the index entry file at height
+func indexPath(height uint64) string {
+	return filepath.Join(indexDir, fmt.Sprintf("%020d.json", height))
+}
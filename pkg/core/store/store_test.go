@@ -0,0 +1,206 @@
+package store
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/indexer"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/block"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/state"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+)
+
+func createTestBlock(height uint64) *block.Block {
+	tx := transaction.Transaction{
+		From:      "0000000000000000000000000000000000000001",
+		To:        "0000000000000000000000000000000000000002",
+		Value:     types.NewAmount(100),
+		Nonce:     height,
+		Status:    transaction.StatusExecuted,
+		Timestamp: time.Now().Unix(),
+	}
+	tx.Hash = tx.ComputeHash()
+	return &block.Block{
+		Header: block.Header{
+			Version:          1,
+			PrevHash:         [32]byte{byte(height)},
+			StateRoot:        "12345",
+			Timestamp:        time.Now().UTC(),
+			Height:           height,
+			TransactionCount: 1,
+		},
+		Transactions: []transaction.Transaction{tx},
+	}
+}
+
+func TestStoreBlocks(t *testing.T) {
+	st, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+
+	var blocks []*block.Block
+	for height := uint64(0); height < 3; height++ {
+		b := createTestBlock(height)
+		if err := st.PutBlock(b); err != nil {
+			t.Fatalf("Failed to put block %d: %v", height, err)
+		}
+		blocks = append(blocks, b)
+	}
+
+	loaded, err := st.LoadBlocks()
+	if err != nil {
+		t.Fatalf("Failed to load blocks: %v", err)
+	}
+	if len(loaded) != len(blocks) {
+		t.Fatalf("Expected %d blocks, got %d", len(blocks), len(loaded))
+	}
+
+	// Hashes must survive the round trip so the chain links stay valid
+	for i := range blocks {
+		if loaded[i].ComputeHash() != blocks[i].ComputeHash() {
+			t.Errorf("Block %d hash changed after reload", i)
+		}
+		if loaded[i].Header.ComputeHash() != blocks[i].Header.ComputeHash() {
+			t.Errorf("Block %d header hash changed after reload", i)
+		}
+		if loaded[i].Transactions[0].Hash != blocks[i].Transactions[0].Hash {
+			t.Errorf("Block %d transaction hash changed after reload", i)
+		}
+	}
+
+	b, err := st.GetBlock(1)
+	if err != nil {
+		t.Fatalf("Failed to get block: %v", err)
+	}
+	if b.Header.Height != 1 {
+		t.Errorf("Expected block at height 1, got %d", b.Header.Height)
+	}
+}
+
+func TestStoreMissingBlock(t *testing.T) {
+	st, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+
+	// A gap in the stored heights is reported
+	if err := st.PutBlock(createTestBlock(0)); err != nil {
+		t.Fatalf("Failed to put block: %v", err)
+	}
+	if err := st.PutBlock(createTestBlock(2)); err != nil {
+		t.Fatalf("Failed to put block: %v", err)
+	}
+	if _, err := st.LoadBlocks(); err == nil {
+		t.Error("Expected error for missing block")
+	}
+
+	// A chain may start above zero after a snapshot import
+	if err := st.DeleteBlocksFrom(0); err != nil {
+		t.Fatalf("Failed to delete blocks: %v", err)
+	}
+	for h := uint64(3); h < 5; h++ {
+		if err := st.PutBlock(createTestBlock(h)); err != nil {
+			t.Fatalf("Failed to put block %d: %v", h, err)
+		}
+	}
+	loaded, err := st.LoadBlocks()
+	if err != nil || len(loaded) != 2 || loaded[0].Header.Height != 3 {
+		t.Errorf("Expected blocks 3 and 4, got %d blocks (%v)", len(loaded), err)
+	}
+}
+
+func TestStoreDeleteBlocksFrom(t *testing.T) {
+	st, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	for h := uint64(0); h < 5; h++ {
+		b := createTestBlock(h)
+		if err := st.PutBlock(b); err != nil {
+			t.Fatalf("Failed to put block %d: %v", h, err)
+		}
+		if err := st.PutIndexEntry(indexer.NewEntry(b)); err != nil {
+			t.Fatalf("Failed to put index entry %d: %v", h, err)
+		}
+	}
+
+	if err := st.DeleteBlocksFrom(2); err != nil {
+		t.Fatalf("Failed to delete blocks: %v", err)
+	}
+	loaded, err := st.LoadBlocks()
+	if err != nil {
+		t.Fatalf("Failed to load blocks: %v", err)
+	}
+	if len(loaded) != 2 {
+		t.Errorf("Expected 2 blocks after deletion, got %d", len(loaded))
+	}
+	if _, err := st.GetBlock(2); !os.IsNotExist(err) {
+		t.Errorf("Expected block 2 to be gone, got %v", err)
+	}
+
+	// Index entries are deleted together with their blocks
+	if _, err := st.GetIndexEntry(2); !os.IsNotExist(err) {
+		t.Errorf("Expected index entry 2 to be gone, got %v", err)
+	}
+	if entry, err := st.GetIndexEntry(1); err != nil || entry.Height != 1 {
+		t.Errorf("Expected index entry 1 to be kept, got %v", err)
+	}
+}
+
+func TestStoreDiffs(t *testing.T) {
+	st, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	diff := &state.Diff{Accounts: map[string]state.PriorAccount{
+		"0000000000000000000000000000000000000001": {Exists: true, Balance: types.NewAmount(1000), Nonce: 2},
+	}}
+	if err := st.PutBlock(createTestBlock(1)); err != nil {
+		t.Fatalf("Failed to put block: %v", err)
+	}
+	if err := st.PutDiff(1, diff); err != nil {
+		t.Fatalf("Failed to put diff: %v", err)
+	}
+
+	loaded, err := st.GetDiff(1)
+	if err != nil {
+		t.Fatalf("Failed to get diff: %v", err)
+	}
+	if got := loaded.Accounts["0000000000000000000000000000000000000001"]; got != diff.Accounts["0000000000000000000000000000000000000001"] {
+		t.Errorf("Expected stored account %+v, got %+v", diff.Accounts["0000000000000000000000000000000000000001"], got)
+	}
+
+	// Diffs are deleted together with their blocks
+	if err := st.DeleteBlocksFrom(1); err != nil {
+		t.Fatalf("Failed to delete blocks: %v", err)
+	}
+	if _, err := st.GetDiff(1); !os.IsNotExist(err) {
+		t.Errorf("Expected diff to be deleted, got %v", err)
+	}
+}
+
+func TestStorePutGet(t *testing.T) {
+	st, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+
+	var missing map[string]int
+	if err := st.Get("meta", &missing); !os.IsNotExist(err) {
+		t.Errorf("Expected not-exist error, got %v", err)
+	}
+
+	if err := st.Put("meta", map[string]int{"height": 7}); err != nil {
+		t.Fatalf("Failed to put value: %v", err)
+	}
+	var meta map[string]int
+	if err := st.Get("meta", &meta); err != nil {
+		t.Fatalf("Failed to get value: %v", err)
+	}
+	if meta["height"] != 7 {
+		t.Errorf("Expected height 7, got %d", meta["height"])
+	}
+}
@@ -0,0 +1,138 @@
+package txpool
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+)
+
+// errNoActiveJournal is returned when writing to a journal that is not open
+// for appending yet
+var errNoActiveJournal = errors.New("no active journal")
+
+// maxJournalLine is the longest journal line that can be read back
+const maxJournalLine = 1 << 20
+
+// Journal is a file of pooled transactions, one JSON object per line, that
+// lets the pool survive a restart. Transactions are appended as they are
+// admitted, and the file is rotated down to the transactions still pooled
+// from time to time. The pool serializes access to its journal.
+type Journal struct {
+	path   string
+	writer *os.File // nil until the journal is rotated
+}
+
+// NewJournal creates a journal kept in the file at path
+func NewJournal(path string) *Journal {
+	return &Journal{path: path}
+}
+
+// Load passes each journaled transaction to add, in the order they were
+// written, and returns how many were read and how many add refused. A
+// missing file holds no transactions. Reading stops at the first line that
+// cannot be decoded, such as one cut short by a crash, and the transactions
+// read up to it are kept.
+func (j *Journal) Load(add func(tx transaction.Transaction) error) (loaded int, dropped int, err error) {
+	f, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open journal: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), maxJournalLine)
+	for line := 1; scanner.Scan(); line++ {
+		var tx transaction.Transaction
+		if err := json.Unmarshal(scanner.Bytes(), &tx); err != nil {
+			return loaded, dropped, fmt.Errorf("failed to decode journal line %d: %v", line, err)
+		}
+		loaded++
+		if add(tx) != nil {
+			dropped++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return loaded, dropped, fmt.Errorf("failed to read journal: %v", err)
+	}
+	return loaded, dropped, nil
+}
+
+// Insert appends tx to the journal
+func (j *Journal) Insert(tx transaction.Transaction) error {
+	if j.writer == nil {
+		return errNoActiveJournal
+	}
+	data, err := json.Marshal(tx)
+	if err != nil {
+		return fmt.Errorf("failed to marshal transaction: %v", err)
+	}
+	if _, err := j.writer.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to append to journal: %v", err)
+	}
+	return nil
+}
+
+// Rotate replaces the journal with txs and reopens it for appending. The new
+// file is written next to the old one and renamed over it, so a crash leaves
+// either journal intact.
+func (j *Journal) Rotate(txs []transaction.Transaction) error {
+	if j.writer != nil {
+		if err := j.writer.Close(); err != nil {
+			return fmt.Errorf("failed to close journal: %v", err)
+		}
+		j.writer = nil
+	}
+
+	tmp := j.path + ".new"
+	replacement, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create journal: %v", err)
+	}
+	w := bufio.NewWriter(replacement)
+	for i := range txs {
+		data, err := json.Marshal(txs[i])
+		if err != nil {
+			replacement.Close()
+			return fmt.Errorf("failed to marshal transaction: %v", err)
+		}
+		if _, err := w.Write(append(data, '\n')); err != nil {
+			replacement.Close()
+			return fmt.Errorf("failed to write journal: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		replacement.Close()
+		return fmt.Errorf("failed to write journal: %v", err)
+	}
+	if err := replacement.Close(); err != nil {
+		return fmt.Errorf("failed to write journal: %v", err)
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		return fmt.Errorf("failed to replace journal: %v", err)
+	}
+
+	sink, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to reopen journal: %v", err)
+	}
+	j.writer = sink
+	return nil
+}
+
+// Close closes the journal. Transactions are no longer appended until it is
+// rotated again.
+func (j *Journal) Close() error {
+	if j.writer == nil {
+		return nil
+	}
+	err := j.writer.Close()
+	j.writer = nil
+	return err
+}
@@ -0,0 +1,84 @@
+package txpool
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+)
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "txpool.jsonl")
+	journal := NewJournal(path)
+
+	// A missing journal holds nothing, and nothing is appended before the
+	// first rotation
+	if loaded, _, err := journal.Load(func(transaction.Transaction) error { return nil }); err != nil || loaded != 0 {
+		t.Fatalf("Expected an empty journal, got %d, %v", loaded, err)
+	}
+	if err := journal.Insert(createFeeTransaction("alice", 0, 1)); err != errNoActiveJournal {
+		t.Fatalf("Expected errNoActiveJournal, got %v", err)
+	}
+
+	pool := NewTxPool()
+	pool.SetJournal(journal)
+	pool.Add(createFeeTransaction("alice", 0, 1))
+	if err := pool.Rejournal(); err != nil {
+		t.Fatalf("Failed to rotate journal: %v", err)
+	}
+	pool.Add(createFeeTransaction("bob", 0, 1))
+	pool.Add(createFeeTransaction("alice", 0, 2)) // Replaces alice's first transaction
+	if err := journal.Close(); err != nil {
+		t.Fatalf("Failed to close journal: %v", err)
+	}
+
+	// Reloading replays every write; a refused transaction is counted
+	var replayed []transaction.Transaction
+	loaded, dropped, err := NewJournal(path).Load(func(tx transaction.Transaction) error {
+		replayed = append(replayed, tx)
+		if tx.From == "bob" {
+			return errors.New("refused")
+		}
+		return nil
+	})
+	if err != nil || loaded != 3 || dropped != 1 {
+		t.Fatalf("Expected 3 transactions with 1 dropped, got %d and %d, %v", loaded, dropped, err)
+	}
+	if replayed[2].Fee != types.NewAmount(2) || replayed[2].Hash != createFeeTransaction("alice", 0, 2).Hash {
+		t.Errorf("Expected the replacement last, got %v", replayed[2])
+	}
+
+	// Rotation compacts the journal to the pooled transactions
+	if err := pool.Rejournal(); err != nil {
+		t.Fatalf("Failed to rotate journal: %v", err)
+	}
+	if loaded, _, err := NewJournal(path).Load(func(transaction.Transaction) error { return nil }); err != nil || loaded != 2 {
+		t.Errorf("Expected 2 transactions after rotation, got %d, %v", loaded, err)
+	}
+	journal.Close()
+}
+
+func TestJournalTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "txpool.jsonl")
+	journal := NewJournal(path)
+	if err := journal.Rotate([]transaction.Transaction{createFeeTransaction("alice", 0, 1)}); err != nil {
+		t.Fatalf("Failed to rotate journal: %v", err)
+	}
+	journal.Close()
+
+	// A crash in the middle of an append leaves a partial last line
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	f.WriteString(`{"From":"bob","No`)
+	f.Close()
+
+	loaded, _, err := NewJournal(path).Load(func(transaction.Transaction) error { return nil })
+	if err == nil || loaded != 1 {
+		t.Errorf("Expected the first transaction and an error, got %d, %v", loaded, err)
+	}
+}
@@ -1,43 +1,763 @@
 package txpool
 
 import (
+	"container/heap"
+	"container/list"
+	"errors"
+	"log/slog"
+	"math/big"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/StupidBug/fabric-zkrollup/pkg/clock"
+	"github.com/StupidBug/fabric-zkrollup/pkg/logging"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
 	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
 )
 
-// TxPool represents the transaction pool
+// Config holds the limits of the pool
+type Config struct {
+	MaxSize      int           // Transactions the pool holds at most
+	MaxPerSender int           // Transactions one sender may have in the pool
+	MaxQueued    int           // Transactions one sender may have waiting behind a nonce gap
+	MaxNonceGap  uint64        // Distance from the sender's confirmed nonce a transaction may be pooled at
+	MinFee       types.Amount  // Fee a transaction has to pay to enter the pool
+	Lifetime     time.Duration // Time a transaction may wait in the pool, unlimited if 0
+	Revalidate   time.Duration // Interval at which the owner sweeps the pool for expired and invalid transactions
+	PriceBump    int           // Percentage by which a replacement must raise the fee of the pooled transaction
+	Rejournal    time.Duration // Interval at which the owner compacts the journal
+}
+
+// MaxPriceBump is the largest price bump a pool accepts; a replacement never
+// has to pay more than eleven times the fee it replaces
+const MaxPriceBump = 1000
+
+// DefaultConfig returns the default pool limits
+func DefaultConfig() Config {
+	return Config{
+		MaxSize:      4096,
+		MaxPerSender: 64,
+		MaxQueued:    16,
+		MaxNonceGap:  64,
+		Lifetime:     3 * time.Hour,
+		Revalidate:   time.Minute,
+		PriceBump:    10,
+		Rejournal:    time.Hour,
+	}
+}
+
+// Errors returned by Add when a transaction is not pooled
+var (
+	ErrAlreadyKnown = errors.New("transaction is already pooled")
+	ErrUnderpriced  = errors.New("replacement transaction underpriced")
+	ErrPoolFull     = errors.New("transaction pool is full")
+	ErrSenderFull   = errors.New("sender has too many pooled transactions")
+	ErrQueueFull    = errors.New("sender has too many transactions waiting behind a nonce gap")
+	ErrNonceTooHigh = errors.New("nonce too far ahead of the sender's confirmed nonce")
+	ErrFeeTooLow    = errors.New("fee below the pool minimum")
+)
+
+// PendingState is a view of sender nonces and balances with pooled
+// transactions applied on top of the confirmed state. Only debits are
+// tracked, so the remaining balance never counts on incoming transfers that
+// are still pending.
+type PendingState struct {
+	nonces map[string]uint64   // Address -> next expected nonce
+	debits map[string]*big.Int // Address -> total value and fees of pending transactions
+}
+
+// NewPendingState creates an empty pending view
+func NewPendingState() *PendingState {
+	return &PendingState{
+		nonces: make(map[string]uint64),
+		debits: make(map[string]*big.Int),
+	}
+}
+
+// Nonce returns the next nonce for address, falling back to the confirmed
+// nonce when the sender has no pending transactions
+func (ps *PendingState) Nonce(address string, confirmed uint64) uint64 {
+	if nonce, exists := ps.nonces[address]; exists {
+		return nonce
+	}
+	return confirmed
+}
+
+// Balance returns the confirmed balance minus the sender's pending debits,
+// or zero if they exceed it
+func (ps *PendingState) Balance(address string, confirmed types.Amount) types.Amount {
+	return remaining(confirmed, ps.debits[address])
+}
+
+// Apply records a transaction in the pending view
+func (ps *PendingState) Apply(tx *transaction.Transaction) {
+	ps.nonces[tx.From] = tx.Nonce + 1
+	ps.Debit(tx)
+}
+
+// Debit records the value and fee of a transaction that waits behind a
+// nonce gap, without advancing the sender's nonce
+func (ps *PendingState) Debit(tx *transaction.Transaction) {
+	debits := ps.debits[tx.From]
+	if debits == nil {
+		debits = new(big.Int)
+		ps.debits[tx.From] = debits
+	}
+	debits.Add(debits, cost(tx))
+}
+
+// cost returns what tx takes from its sender's balance, its value plus its
+// fee
+func cost(tx *transaction.Transaction) *big.Int {
+	return new(big.Int).Add(tx.Value.Big(), tx.Fee.Big())
+}
+
+// remaining returns balance minus debits, or zero if the debits exceed it.
+// Debits are unbounded big integers so that summing the values of pooled
+// transactions can never overflow.
+func remaining(balance types.Amount, debits *big.Int) types.Amount {
+	if debits == nil {
+		return balance
+	}
+	left, err := types.AmountFromBig(new(big.Int).Sub(balance.Big(), debits))
+	if err != nil {
+		return types.Amount{}
+	}
+	return left
+}
+
+// entry is a pooled transaction with its positions in the pool indexes
+type entry struct {
+	tx    transaction.Transaction
+	seq   int64         // Arrival order; returned transactions come first
+	added time.Time     // When the transaction entered the pool
+	elem  *list.Element // Position in arrival order
+	index int           // Position in the priced heap, -1 if not a head
+	tail  int           // Position in the eviction heap, -1 if not a tail
+	stuck bool          // Whether the tail waits behind a nonce gap
+}
+
+// before reports whether e is picked before other: higher fee first, then
+// earlier arrival
+func (e *entry) before(other *entry) bool {
+	if c := e.tx.Fee.Cmp(other.tx.Fee); c != 0 {
+		return c > 0
+	}
+	return e.seq < other.seq
+}
+
+// account is the queue of pooled transactions of one sender. The
+// transactions from the confirmed nonce up to the first gap are executable,
+// the ones behind the gap wait for it to be filled.
+type account struct {
+	txs    []*entry // Ordered by nonce
+	base   uint64   // Confirmed nonce of the sender
+	ready  int      // Number of executable transactions at the front of txs
+	debits big.Int  // Total value and fees of the pooled transactions
+	head   *entry   // Executable transaction in the priced heap, if any
+	last   *entry   // Highest nonce transaction in the eviction heap, if any
+}
+
+// find returns the position of nonce in the queue, or where it would go
+func (a *account) find(nonce uint64) int {
+	return sort.Search(len(a.txs), func(i int) bool { return a.txs[i].tx.Nonce >= nonce })
+}
+
+// has reports whether a transaction with nonce is queued
+func (a *account) has(nonce uint64) bool {
+	i := a.find(nonce)
+	return i < len(a.txs) && a.txs[i].tx.Nonce == nonce
+}
+
+// next returns the nonce that makes a transaction of the sender executable
+func (a *account) next() uint64 {
+	return a.base + uint64(a.ready)
+}
+
+// promote recounts the executable transactions from the confirmed nonce
+func (a *account) promote() {
+	a.ready = 0
+	for a.ready < len(a.txs) && a.txs[a.ready].tx.Nonce == a.base+uint64(a.ready) {
+		a.ready++
+	}
+}
+
+// pricedHeap orders the executable head of every sender by fee
+type pricedHeap []*entry
+
+func (h pricedHeap) Len() int           { return len(h) }
+func (h pricedHeap) Less(i, j int) bool { return h[i].before(h[j]) }
+func (h pricedHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *pricedHeap) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *pricedHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	e.index = -1
+	return e
+}
+
+// evictHeap orders the last transaction of every sender, the ones waiting
+// behind a nonce gap first, then lowest fee first, latest arrival first among
+// equal fees. Evicting a sender's last transaction never leaves a nonce gap
+// behind.
+type evictHeap []*entry
+
+func (h evictHeap) Len() int { return len(h) }
+func (h evictHeap) Less(i, j int) bool {
+	if h[i].stuck != h[j].stuck {
+		return h[i].stuck
+	}
+	return h[j].before(h[i])
+}
+func (h evictHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].tail = i
+	h[j].tail = j
+}
+
+func (h *evictHeap) Push(x interface{}) {
+	e := x.(*entry)
+	e.tail = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *evictHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	e.tail = -1
+	return e
+}
+
+// cursor is a position in the executable transactions of a sender
+type cursor struct {
+	acc *account
+	pos int
+}
+
+// cursorHeap orders senders by the fee of their next executable transaction
+type cursorHeap []cursor
+
+func (h cursorHeap) Len() int { return len(h) }
+func (h cursorHeap) Less(i, j int) bool {
+	return h[i].acc.txs[h[i].pos].before(h[j].acc.txs[h[j].pos])
+}
+func (h cursorHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *cursorHeap) Push(x interface{}) { *h = append(*h, x.(cursor)) }
+func (h *cursorHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// TxPool represents the transaction pool. Transactions are indexed by hash,
+// queued per sender in nonce order and the executable ones are ranked by fee.
 type TxPool struct {
-	mu           sync.RWMutex
-	transactions []transaction.Transaction
+	mu       sync.RWMutex
+	all      map[[32]byte]*entry
+	accounts map[string]*account
+	priced   pricedHeap
+	evict    evictHeap
+	arrival  *list.List // Entries in arrival order
+	first    int64      // Lowest sequence number handed out
+	next     int64      // Next sequence number
+	nonces   func(address string) uint64
+	config   Config
+	clock    clock.Clock
+	logger   *slog.Logger
+	journal  *Journal // nil unless SetJournal was called
 }
 
-// NewTxPool creates a new transaction pool
+// NewTxPool creates a new transaction pool with the default limits, logging
+// to the default logger. Until SetNonces is called every sender's confirmed
+// nonce is 0.
 func NewTxPool() *TxPool {
 	return &TxPool{
-		transactions: make([]transaction.Transaction, 0),
+		all:      make(map[[32]byte]*entry),
+		accounts: make(map[string]*account),
+		arrival:  list.New(),
+		nonces:   func(string) uint64 { return 0 },
+		config:   DefaultConfig(),
+		clock:    clock.Real(),
+		logger:   slog.Default(),
+	}
+}
+
+// SetConfig replaces the limits of the pool. Transactions already pooled
+// beyond new caps are kept until they leave the pool.
+func (p *TxPool) SetConfig(cfg Config) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.config = cfg
+}
+
+// SetClock replaces the clock that transaction lifetimes are measured with
+func (p *TxPool) SetClock(c clock.Clock) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clock = c
+}
+
+// SetLogger replaces the logger of the pool
+func (p *TxPool) SetLogger(logger *slog.Logger) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.logger = logger
+}
+
+// SetJournal makes the pool append every transaction it admits to j. The
+// journal only accepts transactions once it has been rotated.
+func (p *TxPool) SetJournal(j *Journal) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.journal = j
+}
+
+// Rejournal rotates the journal down to the pooled transactions, in arrival
+// order, and opens it for appending. It does nothing without a journal.
+func (p *TxPool) Rejournal() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.journal == nil {
+		return nil
+	}
+	txs := make([]transaction.Transaction, 0, len(p.all))
+	for el := p.arrival.Front(); el != nil; el = el.Next() {
+		txs = append(txs, el.Value.(*entry).tx)
+	}
+	if err := p.journal.Rotate(txs); err != nil {
+		return err
+	}
+	p.logger.Debug("Transaction journal rotated", "transactions", len(txs))
+	return nil
+}
+
+// CloseJournal closes the journal, if there is one. Transactions admitted
+// afterwards are not journaled until it is rotated again.
+func (p *TxPool) CloseJournal() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.journal == nil {
+		return nil
+	}
+	return p.journal.Close()
+}
+
+// record appends tx to the journal, if there is one. A transaction that
+// cannot be journaled stays pooled and is only lost on a restart. The caller
+// must hold the write lock.
+func (p *TxPool) record(tx transaction.Transaction) {
+	if p.journal == nil {
+		return
+	}
+	if err := p.journal.Insert(tx); err != nil && err != errNoActiveJournal {
+		p.logger.Warn("Failed to journal transaction", logging.Hash("tx", tx.Hash), "err", err)
+	}
+}
+
+// SetNonces sets the source of confirmed nonces that each sender's
+// executable transactions start from. It is called while the pool is being
+// modified, so it must not call back into the pool.
+func (p *TxPool) SetNonces(nonces func(address string) uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nonces = nonces
+	for address, acc := range p.accounts {
+		acc.base = nonces(address)
+		p.reindex(acc)
 	}
 }
 
-// Add adds a transaction to the pool
-func (p *TxPool) Add(tx transaction.Transaction) {
+// Add adds a transaction to the pool. A transaction with the hash of a
+// pooled one is refused with ErrAlreadyKnown, one paying less than the
+// minimum fee with ErrFeeTooLow. A pooled transaction of the same sender and
+// nonce is replaced, and returned, if the new one raises its fee by at least
+// the configured price bump; otherwise Add fails with ErrUnderpriced. A
+// sender at its cap is refused with ErrSenderFull, and a transaction that
+// would wait behind a nonce gap with ErrQueueFull once the sender has
+// MaxQueued such transactions, or with ErrNonceTooHigh if it is too far
+// ahead. When the pool is full, the last transaction of a sender makes room
+// and is returned as evicted: one waiting behind a gap gives way to any
+// executable transaction, otherwise the new transaction has to pay more.
+// If no transaction gives way Add fails with ErrPoolFull.
+func (p *TxPool) Add(tx transaction.Transaction) (replaced *transaction.Transaction, evicted []transaction.Transaction, err error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	p.transactions = append(p.transactions, tx)
+	if _, exists := p.all[tx.Hash]; exists {
+		return nil, nil, ErrAlreadyKnown
+	}
+	if tx.Fee.Cmp(p.config.MinFee) < 0 {
+		return nil, nil, ErrFeeTooLow
+	}
+	if acc := p.accounts[tx.From]; acc != nil && acc.has(tx.Nonce) {
+		old := acc.txs[acc.find(tx.Nonce)].tx
+		if !outbids(tx.Fee, old.Fee, p.config.PriceBump) {
+			return nil, nil, ErrUnderpriced
+		}
+		replaced = &old
+	} else {
+		if acc != nil && len(acc.txs) >= p.config.MaxPerSender {
+			return nil, nil, ErrSenderFull
+		}
+		base, next := p.nonces(tx.From), p.nonces(tx.From)
+		if acc != nil {
+			base, next = acc.base, acc.next()
+		}
+		if tx.Nonce >= base && tx.Nonce-base >= p.config.MaxNonceGap {
+			return nil, nil, ErrNonceTooHigh
+		}
+		stuck := tx.Nonce > next
+		if stuck && acc != nil && len(acc.txs)-acc.ready >= p.config.MaxQueued {
+			return nil, nil, ErrQueueFull
+		}
+		if len(p.all) >= p.config.MaxSize {
+			if len(p.evict) == 0 {
+				return nil, nil, ErrPoolFull
+			}
+			cheapest := p.evict[0]
+			if cheapest.tx.From == tx.From || !displaces(tx.Fee, stuck, cheapest) {
+				return nil, nil, ErrPoolFull
+			}
+			p.remove(cheapest)
+			p.refresh(map[string]struct{}{cheapest.tx.From: {}})
+			evicted = append(evicted, cheapest.tx)
+			p.logger.Debug("Transaction evicted", logging.Hash("tx", cheapest.tx.Hash),
+				"from", cheapest.tx.From, "fee", cheapest.tx.Fee)
+		}
+	}
+
+	e := &entry{tx: tx, seq: p.next, added: p.clock.Now(), index: -1, tail: -1}
+	p.next++
+	e.elem = p.arrival.PushBack(e)
+	p.insert(e)
+	p.record(tx)
+	if replaced != nil {
+		p.logger.Debug("Transaction replaced", logging.Hash("tx", replaced.Hash),
+			logging.Hash("replacement", tx.Hash), "from", tx.From, "nonce", tx.Nonce, "fee", tx.Fee)
+	}
+	p.logger.Debug("Transaction pooled", logging.Hash("tx", tx.Hash),
+		"from", tx.From, "nonce", tx.Nonce, "fee", tx.Fee, "pool_size", len(p.all))
+	return replaced, evicted, nil
+}
+
+// displaces reports whether a new transaction paying fee may evict victim
+// from a full pool. A transaction waiting behind a nonce gap gives way to
+// any executable one and never displaces one.
+func displaces(fee types.Amount, stuck bool, victim *entry) bool {
+	if victim.stuck != stuck {
+		return victim.stuck
+	}
+	return victim.tx.Fee.Cmp(fee) < 0
+}
+
+// outbids reports whether fee is higher than old by at least bump percent.
+// The products are computed on big integers, as fees use all of their bits.
+func outbids(fee, old types.Amount, bump int) bool {
+	if fee.Cmp(old) <= 0 {
+		return false
+	}
+	lhs := new(big.Int).Mul(fee.Big(), big.NewInt(100))
+	rhs := new(big.Int).Mul(old.Big(), big.NewInt(100+int64(bump)))
+	return lhs.Cmp(rhs) >= 0
+}
+
+// Prepend puts txs in front of the pooled transactions, in order. It is used
+// to return the transactions of rolled back blocks, which precede everything
+// still in the pool. The returned transactions are then all in the pool, so
+// the limits are applied again afterwards: the last transactions of senders
+// over MaxPerSender or MaxQueued are evicted, and then, while the pool holds
+// more than MaxSize, the transactions Add would evict first. The evicted
+// transactions are returned.
+func (p *TxPool) Prepend(txs []transaction.Transaction) (evicted []transaction.Transaction) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.first -= int64(len(txs))
+	for i := len(txs) - 1; i >= 0; i-- {
+		if _, exists := p.all[txs[i].Hash]; exists {
+			continue
+		}
+		e := &entry{tx: txs[i], seq: p.first + int64(i), added: p.clock.Now(), index: -1, tail: -1}
+		e.elem = p.arrival.PushFront(e)
+		p.insert(e)
+		p.record(txs[i])
+	}
+	evicted = p.trim()
+	p.logger.Debug("Transactions returned to the pool", "count", len(txs), "evicted", len(evicted), "pool_size", len(p.all))
+	return evicted
+}
+
+// trim evicts transactions until the pool is within its limits, taking
+// each sender's last transaction so that no nonce gap opens. The caller must
+// hold the write lock.
+func (p *TxPool) trim() []transaction.Transaction {
+	var evicted []transaction.Transaction
+	drop := func(e *entry) {
+		p.remove(e)
+		p.refresh(map[string]struct{}{e.tx.From: {}})
+		evicted = append(evicted, e.tx)
+		p.logger.Debug("Transaction evicted", logging.Hash("tx", e.tx.Hash), "from", e.tx.From, "fee", e.tx.Fee)
+	}
+
+	addresses := make([]string, 0, len(p.accounts))
+	for address := range p.accounts {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	for _, address := range addresses {
+		acc := p.accounts[address]
+		for len(acc.txs) > p.config.MaxPerSender || len(acc.txs)-acc.ready > p.config.MaxQueued {
+			drop(acc.txs[len(acc.txs)-1])
+		}
+	}
+	for len(p.all) > p.config.MaxSize && len(p.evict) > 0 {
+		drop(p.evict[0])
+	}
+	return evicted
+}
+
+// insert adds e to the hash index and its sender's queue. The caller must
+// hold the write lock.
+func (p *TxPool) insert(e *entry) {
+	acc := p.accounts[e.tx.From]
+	if acc == nil {
+		acc = &account{}
+		p.accounts[e.tx.From] = acc
+	}
+	i := acc.find(e.tx.Nonce)
+	if i < len(acc.txs) && acc.txs[i].tx.Nonce == e.tx.Nonce {
+		replaced := acc.txs[i]
+		delete(p.all, replaced.tx.Hash)
+		p.arrival.Remove(replaced.elem)
+		acc.debits.Sub(&acc.debits, cost(&replaced.tx))
+		acc.txs[i] = e
+	} else {
+		acc.txs = append(acc.txs, nil)
+		copy(acc.txs[i+1:], acc.txs[i:])
+		acc.txs[i] = e
+	}
+	acc.debits.Add(&acc.debits, cost(&e.tx))
+	p.all[e.tx.Hash] = e
+	acc.base = p.nonces(e.tx.From)
+	p.reindex(acc)
+}
+
+// remove deletes e from every index, without updating its sender's
+// executable transactions. The caller must hold the write lock.
+func (p *TxPool) remove(e *entry) {
+	acc := p.accounts[e.tx.From]
+	i := acc.find(e.tx.Nonce)
+	acc.txs = append(acc.txs[:i], acc.txs[i+1:]...)
+	acc.debits.Sub(&acc.debits, cost(&e.tx))
+	delete(p.all, e.tx.Hash)
+	p.arrival.Remove(e.elem)
+}
+
+// reindex recounts the executable transactions of acc after a change and
+// updates its head in the priced heap and its last transaction in the
+// eviction heap. The caller must hold the write lock.
+func (p *TxPool) reindex(acc *account) {
+	acc.promote()
+	var head, last *entry
+	if acc.ready > 0 {
+		head = acc.txs[0]
+	}
+	if len(acc.txs) > 0 {
+		last = acc.txs[len(acc.txs)-1]
+	}
+	if head != acc.head {
+		if acc.head != nil {
+			heap.Remove(&p.priced, acc.head.index)
+		}
+		if head != nil {
+			heap.Push(&p.priced, head)
+		}
+		acc.head = head
+	}
+	stuck := acc.ready < len(acc.txs)
+	if last != acc.last {
+		if acc.last != nil {
+			heap.Remove(&p.evict, acc.last.tail)
+		}
+		if last != nil {
+			last.stuck = stuck
+			heap.Push(&p.evict, last)
+		}
+		acc.last = last
+	} else if last != nil && last.stuck != stuck {
+		last.stuck = stuck
+		heap.Fix(&p.evict, last.tail)
+	}
+}
+
+// refresh reloads the confirmed nonce of each sender in addresses and
+// reindexes it, dropping senders without pooled transactions. The caller
+// must hold the write lock.
+func (p *TxPool) refresh(addresses map[string]struct{}) {
+	for address := range addresses {
+		acc := p.accounts[address]
+		if acc == nil {
+			continue
+		}
+		if len(acc.txs) == 0 {
+			p.reindex(acc)
+			delete(p.accounts, address)
+			continue
+		}
+		acc.base = p.nonces(address)
+		p.reindex(acc)
+	}
+}
+
+// PendingNonce returns the next nonce for address given its confirmed nonce:
+// the nonce after the sender's pooled transactions up to the first gap
+func (p *TxPool) PendingNonce(address string, confirmed uint64) uint64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	nonce := confirmed
+	acc := p.accounts[address]
+	if acc == nil {
+		return nonce
+	}
+	for i := acc.find(confirmed); i < len(acc.txs) && acc.txs[i].tx.Nonce == nonce; i++ {
+		nonce++
+	}
+	return nonce
+}
+
+// PendingBalance returns the balance left for address after all of its
+// pooled transactions, given its confirmed balance, or zero if they spend
+// more than it has
+func (p *TxPool) PendingBalance(address string, confirmed types.Amount) types.Amount {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if acc := p.accounts[address]; acc != nil {
+		return remaining(confirmed, &acc.debits)
+	}
+	return confirmed
 }
 
 // Remove removes a transaction from the pool
 func (p *TxPool) Remove(hash [32]byte) {
+	p.RemoveAll([][32]byte{hash})
+}
+
+// RemoveAll removes every transaction whose hash is in hashes, keeping the
+// order of the remaining transactions
+func (p *TxPool) RemoveAll(hashes [][32]byte) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	for i, t := range p.transactions {
-		if t.Hash == hash {
-			p.transactions = append(p.transactions[:i], p.transactions[i+1:]...)
-			return
+	touched := make(map[string]struct{})
+	removed := 0
+	for _, hash := range hashes {
+		if e, ok := p.all[hash]; ok {
+			p.remove(e)
+			touched[e.tx.From] = struct{}{}
+			removed++
 		}
 	}
+	p.refresh(touched)
+	p.logger.Debug("Transactions removed from the pool",
+		"count", removed, "pool_size", len(p.all))
+}
+
+// Filter keeps only the transactions for which keep returns true and returns
+// the ones that were dropped. keep is called for each sender's transactions
+// in nonce order, with senders in address order.
+func (p *TxPool) Filter(keep func(tx *transaction.Transaction) bool) []transaction.Transaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	addresses := make([]string, 0, len(p.accounts))
+	for address := range p.accounts {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	var dropped []transaction.Transaction
+	touched := make(map[string]struct{}, len(addresses))
+	for _, address := range addresses {
+		acc := p.accounts[address]
+		var drop []*entry
+		for _, e := range acc.txs {
+			if !keep(&e.tx) {
+				drop = append(drop, e)
+			}
+		}
+		for _, e := range drop {
+			p.remove(e)
+			dropped = append(dropped, e.tx)
+		}
+		touched[address] = struct{}{}
+	}
+	p.refresh(touched)
+	return dropped
+}
+
+// Expire removes and returns the transactions that have been in the pool
+// longer than the configured lifetime, together with the later transactions
+// of their senders, which could never execute with a nonce missing. The
+// transactions are returned per sender in nonce order, with senders in
+// address order.
+func (p *TxPool) Expire() []transaction.Transaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.config.Lifetime <= 0 {
+		return nil
+	}
+	deadline := p.clock.Now().Add(-p.config.Lifetime)
+	cut := make(map[string]uint64) // Sender -> lowest expired nonce
+	for el := p.arrival.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*entry)
+		if e.added.After(deadline) {
+			continue
+		}
+		if nonce, ok := cut[e.tx.From]; !ok || e.tx.Nonce < nonce {
+			cut[e.tx.From] = e.tx.Nonce
+		}
+	}
+
+	addresses := make([]string, 0, len(cut))
+	for address := range cut {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	var expired []transaction.Transaction
+	touched := make(map[string]struct{}, len(addresses))
+	for _, address := range addresses {
+		acc := p.accounts[address]
+		drop := append([]*entry(nil), acc.txs[acc.find(cut[address]):]...)
+		for _, e := range drop {
+			p.remove(e)
+			expired = append(expired, e.tx)
+		}
+		touched[address] = struct{}{}
+	}
+	p.refresh(touched)
+	return expired
 }
 
 // Get returns a transaction by its hash
@@ -45,31 +765,125 @@ func (p *TxPool) Get(hash [32]byte) *transaction.Transaction {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	for _, t := range p.transactions {
-		if t.Hash == hash {
-			txCopy := t
-			return &txCopy
-		}
+	if e, ok := p.all[hash]; ok {
+		txCopy := e.tx
+		return &txCopy
 	}
 	return nil
 }
 
-// GetAll returns all transactions in the pool
+// Has reports whether a transaction with hash is pooled
+func (p *TxPool) Has(hash [32]byte) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	_, ok := p.all[hash]
+	return ok
+}
+
+// GetByNonce returns the pooled transaction of address with nonce
+func (p *TxPool) GetByNonce(address string, nonce uint64) *transaction.Transaction {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	acc := p.accounts[address]
+	if acc == nil {
+		return nil
+	}
+	if i := acc.find(nonce); i < len(acc.txs) && acc.txs[i].tx.Nonce == nonce {
+		txCopy := acc.txs[i].tx
+		return &txCopy
+	}
+	return nil
+}
+
+// GetAll returns all transactions in the pool in arrival order
 func (p *TxPool) GetAll() []transaction.Transaction {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	txs := make([]transaction.Transaction, len(p.transactions))
-	copy(txs, p.transactions)
+	txs := make([]transaction.Transaction, 0, len(p.all))
+	for el := p.arrival.Front(); el != nil; el = el.Next() {
+		txs = append(txs, el.Value.(*entry).tx)
+	}
 	return txs
 }
 
+// Pending returns the executable transactions of each sender in nonce order
+func (p *TxPool) Pending() map[string][]transaction.Transaction {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	pending := make(map[string][]transaction.Transaction)
+	for address, acc := range p.accounts {
+		if acc.ready > 0 {
+			pending[address] = entryTransactions(acc.txs[:acc.ready])
+		}
+	}
+	return pending
+}
+
+// Queued returns the transactions of each sender that wait behind a nonce
+// gap, in nonce order
+func (p *TxPool) Queued() map[string][]transaction.Transaction {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	queued := make(map[string][]transaction.Transaction)
+	for address, acc := range p.accounts {
+		if acc.ready < len(acc.txs) {
+			queued[address] = entryTransactions(acc.txs[acc.ready:])
+		}
+	}
+	return queued
+}
+
+// entryTransactions copies the transactions of entries
+func entryTransactions(entries []*entry) []transaction.Transaction {
+	txs := make([]transaction.Transaction, len(entries))
+	for i, e := range entries {
+		txs[i] = e.tx
+	}
+	return txs
+}
+
+// Best returns up to n executable transactions, highest fee first. Each
+// sender's transactions come in nonce order, so a transaction can follow a
+// higher-fee one only if it has to go first. Ties are broken by arrival.
+func (p *TxPool) Best(n int) []transaction.Transaction {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	// The priced heap is already a valid heap of the senders' heads
+	cursors := make(cursorHeap, len(p.priced))
+	for i, e := range p.priced {
+		cursors[i] = cursor{acc: p.accounts[e.tx.From]}
+	}
+
+	var best []transaction.Transaction
+	for len(best) < n && len(cursors) > 0 {
+		c := cursors[0]
+		best = append(best, c.acc.txs[c.pos].tx)
+		if c.pos+1 < c.acc.ready {
+			cursors[0].pos++
+			heap.Fix(&cursors, 0)
+		} else {
+			heap.Pop(&cursors)
+		}
+	}
+	return best
+}
+
 // Clear removes all transactions from the pool
 func (p *TxPool) Clear() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	p.transactions = make([]transaction.Transaction, 0)
+	p.all = make(map[[32]byte]*entry)
+	p.accounts = make(map[string]*account)
+	p.priced = nil
+	p.evict = nil
+	p.arrival.Init()
 }
 
 // Size returns the number of transactions in the pool
@@ -77,5 +891,17 @@ func (p *TxPool) Size() int {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	return len(p.transactions)
+	return len(p.all)
+}
+
+// Stats returns the number of executable and queued transactions
+func (p *TxPool) Stats() (pending int, queued int) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, acc := range p.accounts {
+		pending += acc.ready
+		queued += len(acc.txs) - acc.ready
+	}
+	return pending, queued
 }
@@ -1,9 +1,12 @@
 package txpool
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
+	"github.com/StupidBug/fabric-zkrollup/pkg/clock"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
 	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
 )
 
@@ -11,7 +14,7 @@ func createTestTransaction(value int64, nonce uint64) transaction.Transaction {
 	return transaction.Transaction{
 		From:      "sender",
 		To:        "receiver",
-		Value:     int(value),
+		Value:     types.NewAmount(uint64(value)),
 		Nonce:     nonce,
 		Status:    transaction.StatusPending,
 		Timestamp: time.Now().Unix(),
@@ -37,6 +40,14 @@ func TestTxPoolAdd(t *testing.T) {
 	if tx.Hash != tx1.Hash {
 		t.Error("Transaction hash mismatch")
 	}
+
+	// Test adding the same transaction again
+	if _, _, err := pool.Add(tx1); err != ErrAlreadyKnown {
+		t.Errorf("Expected ErrAlreadyKnown, got %v", err)
+	}
+	if pool.Size() != 1 || !pool.Has(tx1.Hash) {
+		t.Errorf("Expected a single copy in the pool, got size %d", pool.Size())
+	}
 }
 
 func TestTxPoolRemove(t *testing.T) {
@@ -88,13 +99,63 @@ func TestTxPoolGetAll(t *testing.T) {
 	}
 
 	// Verify transactions are copied
-	txs[0].Value = 3000
+	txs[0].Value = types.NewAmount(3000)
 	poolTx := pool.Get(tx1.Hash)
-	if poolTx.Value != 1000 {
+	if poolTx.Value != types.NewAmount(1000) {
 		t.Error("Pool transaction was modified when modifying GetAll result")
 	}
 }
 
+func TestTxPoolPrepend(t *testing.T) {
+	pool := NewTxPool()
+	tx2 := createTestTransaction(100, 2)
+	tx2.Hash = tx2.ComputeHash()
+	pool.Add(tx2)
+
+	tx0 := createTestTransaction(100, 0)
+	tx0.Hash = tx0.ComputeHash()
+	tx1 := createTestTransaction(100, 1)
+	tx1.Hash = tx1.ComputeHash()
+	pool.Prepend([]transaction.Transaction{tx0, tx1})
+
+	txs := pool.GetAll()
+	if len(txs) != 3 || txs[0].Hash != tx0.Hash || txs[1].Hash != tx1.Hash || txs[2].Hash != tx2.Hash {
+		t.Fatalf("Expected prepended transactions first, got %v", txs)
+	}
+	if got := pool.PendingNonce("sender", 0); got != 3 {
+		t.Errorf("Expected pending nonce 3, got %d", got)
+	}
+	if got := pool.PendingBalance("sender", types.NewAmount(1000)); got != types.NewAmount(700) {
+		t.Errorf("Expected pending balance 700, got %v", got)
+	}
+}
+
+func TestTxPoolPrependLimits(t *testing.T) {
+	pool := NewTxPool()
+	pool.SetConfig(Config{MaxSize: 4, MaxPerSender: 2, MaxQueued: 2, MaxNonceGap: 10})
+	pool.Add(createFeeTransaction("alice", 2, 9))
+	pool.Add(createFeeTransaction("bob", 0, 1))
+	pool.Add(createFeeTransaction("carol", 0, 5))
+	pool.Add(createFeeTransaction("dave", 0, 6))
+
+	// The returned transactions go over the limits, which are applied again
+	// afterwards: alice's last transaction goes first, then the cheapest
+	// last transaction of a sender
+	evicted := pool.Prepend([]transaction.Transaction{
+		createFeeTransaction("alice", 0, 9),
+		createFeeTransaction("alice", 1, 9),
+	})
+	if len(evicted) != 2 || evicted[0].From != "alice" || evicted[0].Nonce != 2 || evicted[1].From != "bob" {
+		t.Fatalf("Expected alice's nonce 2 and bob's transaction to be evicted, got %v", evicted)
+	}
+	if pool.Size() != 4 {
+		t.Errorf("Expected pool size 4, got %d", pool.Size())
+	}
+	if got := pool.PendingNonce("alice", 0); got != 2 {
+		t.Errorf("Expected alice's pending nonce 2, got %d", got)
+	}
+}
+
 func TestTxPoolClear(t *testing.T) {
 	pool := NewTxPool()
 	tx1 := createTestTransaction(1000, 0)
@@ -148,3 +209,418 @@ func TestTxPoolConcurrency(t *testing.T) {
 		t.Errorf("Pool size %d exceeds maximum possible size %d", len(txs), numGoroutines)
 	}
 }
+
+func TestTxPoolRemoveAll(t *testing.T) {
+	pool := NewTxPool()
+	tx1 := createTestTransaction(1000, 0)
+	tx1.Hash = tx1.ComputeHash()
+	tx2 := createTestTransaction(2000, 1)
+	tx2.Hash = tx2.ComputeHash()
+	tx3 := createTestTransaction(3000, 2)
+	tx3.Hash = tx3.ComputeHash()
+
+	pool.Add(tx1)
+	pool.Add(tx2)
+	pool.Add(tx3)
+
+	// Remove the included transactions, including one the pool never had
+	pool.RemoveAll([][32]byte{tx1.Hash, tx3.Hash, {0xff}})
+	if pool.Size() != 1 {
+		t.Fatalf("Expected pool size 1, got %d", pool.Size())
+	}
+	if pool.Get(tx2.Hash) == nil {
+		t.Error("Expected transaction not in the removal set to remain in pool")
+	}
+}
+
+func TestTxPoolFilter(t *testing.T) {
+	pool := NewTxPool()
+	tx1 := createTestTransaction(1000, 0)
+	tx1.Hash = tx1.ComputeHash()
+	tx2 := createTestTransaction(2000, 1)
+	tx2.Hash = tx2.ComputeHash()
+	tx3 := createTestTransaction(3000, 2)
+	tx3.Hash = tx3.ComputeHash()
+
+	pool.Add(tx1)
+	pool.Add(tx2)
+	pool.Add(tx3)
+
+	dropped := pool.Filter(func(tx *transaction.Transaction) bool {
+		return tx.Value != types.NewAmount(2000)
+	})
+	if len(dropped) != 1 || dropped[0].Hash != tx2.Hash {
+		t.Fatalf("Expected only tx2 to be dropped, got %v", dropped)
+	}
+
+	// Remaining transactions keep their arrival order
+	txs := pool.GetAll()
+	if len(txs) != 2 || txs[0].Hash != tx1.Hash || txs[1].Hash != tx3.Hash {
+		t.Errorf("Unexpected pool contents after filter: %v", txs)
+	}
+}
+
+func TestTxPoolPendingState(t *testing.T) {
+	pool := NewTxPool()
+
+	// Without pooled transactions the confirmed values are returned
+	if nonce := pool.PendingNonce("sender", 5); nonce != 5 {
+		t.Errorf("Expected pending nonce 5, got %d", nonce)
+	}
+	if balance := pool.PendingBalance("sender", types.NewAmount(10000)); balance != types.NewAmount(10000) {
+		t.Errorf("Expected pending balance 10000, got %v", balance)
+	}
+
+	tx1 := createTestTransaction(1000, 5)
+	tx1.Hash = tx1.ComputeHash()
+	tx2 := createTestTransaction(2000, 6)
+	tx2.Hash = tx2.ComputeHash()
+	pool.Add(tx1)
+	pool.Add(tx2)
+
+	if nonce := pool.PendingNonce("sender", 5); nonce != 7 {
+		t.Errorf("Expected pending nonce 7, got %d", nonce)
+	}
+	if balance := pool.PendingBalance("sender", types.NewAmount(10000)); balance != types.NewAmount(7000) {
+		t.Errorf("Expected pending balance 7000, got %v", balance)
+	}
+
+	// Pending credits are not counted for the receiver
+	if balance := pool.PendingBalance("receiver", types.NewAmount(0)); balance != types.NewAmount(0) {
+		t.Errorf("Expected receiver pending balance 0, got %v", balance)
+	}
+
+	// Removing transactions rebuilds the view
+	pool.RemoveAll([][32]byte{tx1.Hash, tx2.Hash})
+	if nonce := pool.PendingNonce("sender", 7); nonce != 7 {
+		t.Errorf("Expected pending nonce 7 after removal, got %d", nonce)
+	}
+	if balance := pool.PendingBalance("sender", types.NewAmount(7000)); balance != types.NewAmount(7000) {
+		t.Errorf("Expected pending balance 7000 after removal, got %v", balance)
+	}
+}
+
+func createFeeTransaction(from string, nonce uint64, fee uint64) transaction.Transaction {
+	tx := transaction.Transaction{
+		From:   from,
+		To:     "receiver",
+		Value:  types.NewAmount(1),
+		Nonce:  nonce,
+		Status: transaction.StatusPending,
+		Fee:    types.NewAmount(fee),
+	}
+	tx.Hash = tx.ComputeHash()
+	return tx
+}
+
+func TestTxPoolPendingAndQueued(t *testing.T) {
+	pool := NewTxPool()
+	confirmed := map[string]uint64{"alice": 3}
+	pool.SetNonces(func(address string) uint64 { return confirmed[address] })
+
+	// alice: 3 and 4 are executable, 6 waits for 5
+	pool.Add(createFeeTransaction("alice", 6, 1))
+	pool.Add(createFeeTransaction("alice", 3, 1))
+	pool.Add(createFeeTransaction("alice", 4, 1))
+	// bob: nothing is executable before nonce 0
+	pool.Add(createFeeTransaction("bob", 1, 1))
+
+	pending, queued := pool.Stats()
+	if pending != 2 || queued != 2 {
+		t.Fatalf("Expected 2 pending and 2 queued, got %d and %d", pending, queued)
+	}
+	if txs := pool.Pending()["alice"]; len(txs) != 2 || txs[0].Nonce != 3 || txs[1].Nonce != 4 {
+		t.Errorf("Unexpected pending transactions of alice: %v", txs)
+	}
+	if txs := pool.Queued()["alice"]; len(txs) != 1 || txs[0].Nonce != 6 {
+		t.Errorf("Unexpected queued transactions of alice: %v", txs)
+	}
+	if got := pool.PendingNonce("alice", 3); got != 5 {
+		t.Errorf("Expected pending nonce 5, got %d", got)
+	}
+
+	// Filling the gaps promotes the queued transactions
+	pool.Add(createFeeTransaction("alice", 5, 1))
+	pool.Add(createFeeTransaction("bob", 0, 1))
+	if pending, queued := pool.Stats(); pending != 6 || queued != 0 {
+		t.Fatalf("Expected 6 pending and 0 queued, got %d and %d", pending, queued)
+	}
+	if got := pool.PendingNonce("alice", 3); got != 7 {
+		t.Errorf("Expected pending nonce 7, got %d", got)
+	}
+
+	// Once the confirmed nonce moves past the included transactions, the
+	// rest stay executable
+	included := pool.Pending()["alice"][:2]
+	confirmed["alice"] = 5
+	pool.RemoveAll([][32]byte{included[0].Hash, included[1].Hash})
+	if txs := pool.Pending()["alice"]; len(txs) != 2 || txs[0].Nonce != 5 {
+		t.Errorf("Unexpected pending transactions of alice after inclusion: %v", txs)
+	}
+
+	if tx := pool.GetByNonce("alice", 6); tx == nil || tx.Nonce != 6 {
+		t.Errorf("Expected to find alice's nonce 6, got %v", tx)
+	}
+	if tx := pool.GetByNonce("alice", 3); tx != nil {
+		t.Errorf("Expected nonce 3 to be gone, got %v", tx)
+	}
+}
+
+func TestTxPoolBest(t *testing.T) {
+	pool := NewTxPool()
+	pool.Add(createFeeTransaction("alice", 0, 1))
+	pool.Add(createFeeTransaction("alice", 1, 50))
+	pool.Add(createFeeTransaction("bob", 0, 10))
+	pool.Add(createFeeTransaction("carol", 0, 10))
+	pool.Add(createFeeTransaction("carol", 2, 100)) // Queued
+
+	type pick struct {
+		from  string
+		nonce uint64
+	}
+	// bob and carol tie and keep their arrival order; alice's high fee
+	// transaction has to wait for her first one
+	want := []pick{{"bob", 0}, {"carol", 0}, {"alice", 0}, {"alice", 1}}
+	best := pool.Best(10)
+	if len(best) != len(want) {
+		t.Fatalf("Expected %d transactions, got %d", len(want), len(best))
+	}
+	for i, tx := range best {
+		if tx.From != want[i].from || tx.Nonce != want[i].nonce {
+			t.Errorf("Position %d: expected %s/%d, got %s/%d", i, want[i].from, want[i].nonce, tx.From, tx.Nonce)
+		}
+	}
+	if best := pool.Best(1); len(best) != 1 || best[0].From != "bob" {
+		t.Errorf("Expected only bob's transaction, got %v", best)
+	}
+}
+
+func TestTxPoolReplaceNonce(t *testing.T) {
+	pool := NewTxPool()
+	low := createFeeTransaction("alice", 0, 1)
+	high := createFeeTransaction("alice", 0, 5)
+	pool.Add(low)
+
+	// A replacement must raise the fee by the price bump
+	if _, _, err := pool.Add(createFeeTransaction("alice", 0, 1)); err != ErrAlreadyKnown {
+		t.Fatalf("Expected ErrAlreadyKnown, got %v", err)
+	}
+	pool.SetConfig(Config{MaxSize: 10, MaxPerSender: 10, MaxQueued: 10, MaxNonceGap: 10, PriceBump: 500})
+	if _, _, err := pool.Add(high); err != ErrUnderpriced {
+		t.Fatalf("Expected ErrUnderpriced, got %v", err)
+	}
+	pool.SetConfig(DefaultConfig())
+	replaced, _, err := pool.Add(high)
+	if err != nil {
+		t.Fatalf("Failed to replace transaction: %v", err)
+	}
+	if replaced == nil || replaced.Hash != low.Hash {
+		t.Fatalf("Expected the first transaction to be returned as replaced, got %v", replaced)
+	}
+
+	if pool.Size() != 1 || pool.Get(low.Hash) != nil || pool.Get(high.Hash) == nil {
+		t.Fatal("Expected the second transaction to replace the first")
+	}
+	// Only the replacement's value and fee are held back
+	if got := pool.PendingBalance("alice", types.NewAmount(10)); got != types.NewAmount(4) {
+		t.Errorf("Expected pending balance 4, got %v", got)
+	}
+	if best := pool.Best(10); len(best) != 1 || best[0].Hash != high.Hash {
+		t.Errorf("Expected the replacement to be executable, got %v", best)
+	}
+}
+
+func TestOutbids(t *testing.T) {
+	huge, _ := types.MaxAmount().Sub(types.NewAmount(1))
+	tests := []struct {
+		fee, old types.Amount
+		bump     int
+		want     bool
+	}{
+		{types.NewAmount(11), types.NewAmount(10), 10, true},
+		{types.NewAmount(10), types.NewAmount(10), 0, false},
+		{types.NewAmount(1), types.Amount{}, MaxPriceBump, true},
+		{types.MaxAmount(), huge, 10, false}, // Products beyond 128 bits do not wrap
+		{types.MaxAmount(), types.NewAmount(1), MaxPriceBump, true},
+	}
+	for _, tt := range tests {
+		if got := outbids(tt.fee, tt.old, tt.bump); got != tt.want {
+			t.Errorf("outbids(%s, %s, %d) = %v, want %v", tt.fee, tt.old, tt.bump, got, tt.want)
+		}
+	}
+}
+
+func TestTxPoolLimits(t *testing.T) {
+	pool := NewTxPool()
+	pool.SetConfig(Config{MaxSize: 4, MaxPerSender: 2, MaxQueued: 2, MaxNonceGap: 10})
+
+	pool.Add(createFeeTransaction("alice", 0, 5))
+	pool.Add(createFeeTransaction("alice", 1, 1))
+	if _, _, err := pool.Add(createFeeTransaction("alice", 2, 9)); err != ErrSenderFull {
+		t.Fatalf("Expected ErrSenderFull, got %v", err)
+	}
+	// Replacing a pooled nonce does not count against the cap
+	if _, _, err := pool.Add(createFeeTransaction("alice", 1, 2)); err != nil {
+		t.Fatalf("Failed to replace transaction: %v", err)
+	}
+
+	pool.Add(createFeeTransaction("bob", 0, 3))
+	pool.Add(createFeeTransaction("carol", 0, 3))
+
+	// The pool is full: a transaction paying no more than the cheapest last
+	// transaction of a sender is refused
+	if _, _, err := pool.Add(createFeeTransaction("dave", 0, 2)); err != ErrPoolFull {
+		t.Fatalf("Expected ErrPoolFull, got %v", err)
+	}
+
+	// alice's nonce 1 is the cheapest last transaction; her nonce 0 pays
+	// more but evicting it would leave a gap
+	_, evicted, err := pool.Add(createFeeTransaction("dave", 0, 4))
+	if err != nil {
+		t.Fatalf("Failed to add transaction to a full pool: %v", err)
+	}
+	if len(evicted) != 1 || evicted[0].From != "alice" || evicted[0].Nonce != 1 {
+		t.Fatalf("Expected alice's nonce 1 to be evicted, got %v", evicted)
+	}
+	if pool.Size() != 4 {
+		t.Errorf("Expected pool size 4, got %d", pool.Size())
+	}
+
+	// Among equal fees the latest arrival goes first
+	_, evicted, err = pool.Add(createFeeTransaction("erin", 0, 10))
+	if err != nil || len(evicted) != 1 || evicted[0].From != "carol" {
+		t.Fatalf("Expected carol's transaction to be evicted, got %v, %v", evicted, err)
+	}
+}
+
+func TestTxPoolExpire(t *testing.T) {
+	start := time.Unix(1000, 0)
+	manual := clock.NewManual(start)
+	pool := NewTxPool()
+	pool.SetClock(manual)
+	pool.SetConfig(Config{MaxSize: 10, MaxPerSender: 10, MaxQueued: 10, MaxNonceGap: 10, Lifetime: time.Minute})
+
+	old := createFeeTransaction("alice", 0, 1)
+	pool.Add(old)
+	manual.Advance(30 * time.Second)
+	recent := createFeeTransaction("alice", 1, 1)
+	pool.Add(recent)
+	other := createFeeTransaction("bob", 0, 1)
+	pool.Add(other)
+
+	if expired := pool.Expire(); len(expired) != 0 {
+		t.Fatalf("Expected nothing to expire yet, got %v", expired)
+	}
+	manual.Advance(30 * time.Second)
+
+	// The later transaction could only wait behind the gap, so it goes too
+	expired := pool.Expire()
+	if len(expired) != 2 || expired[0].Hash != old.Hash || expired[1].Hash != recent.Hash {
+		t.Fatalf("Expected alice's transactions to expire, got %v", expired)
+	}
+	if pending, queued := pool.Stats(); pending != 1 || queued != 0 {
+		t.Errorf("Expected 1 pending and 0 queued, got %d and %d", pending, queued)
+	}
+	if got := pool.PendingBalance("alice", types.NewAmount(10)); got != types.NewAmount(10) {
+		t.Errorf("Expected pending balance 10, got %v", got)
+	}
+}
+
+func TestTxPoolQueueLimits(t *testing.T) {
+	pool := NewTxPool()
+	pool.SetConfig(Config{MaxSize: 4, MaxPerSender: 10, MaxQueued: 2, MaxNonceGap: 5, MinFee: types.NewAmount(1)})
+
+	if _, _, err := pool.Add(createFeeTransaction("alice", 0, 0)); err != ErrFeeTooLow {
+		t.Fatalf("Expected ErrFeeTooLow, got %v", err)
+	}
+	if _, _, err := pool.Add(createFeeTransaction("alice", 5, 1)); err != ErrNonceTooHigh {
+		t.Fatalf("Expected ErrNonceTooHigh, got %v", err)
+	}
+	pool.Add(createFeeTransaction("alice", 2, 9))
+	pool.Add(createFeeTransaction("alice", 3, 9))
+	if _, _, err := pool.Add(createFeeTransaction("alice", 4, 9)); err != ErrQueueFull {
+		t.Fatalf("Expected ErrQueueFull, got %v", err)
+	}
+	// Filling the gap is not capped, and the filled nonces no longer count
+	// as queued
+	pool.Add(createFeeTransaction("alice", 0, 1))
+	pool.Add(createFeeTransaction("alice", 1, 1))
+	if pending, queued := pool.Stats(); pending != 4 || queued != 0 {
+		t.Fatalf("Expected 4 pending and 0 queued, got %d and %d", pending, queued)
+	}
+
+	// In a full pool a queued transaction gives way to an executable one
+	// paying less, but a queued one never evicts an executable one
+	pool.SetConfig(Config{MaxSize: 3, MaxPerSender: 10, MaxQueued: 2, MaxNonceGap: 5, MinFee: types.NewAmount(1)})
+	pool.Clear()
+	pool.Add(createFeeTransaction("alice", 0, 1))
+	pool.Add(createFeeTransaction("bob", 1, 9))
+	pool.Add(createFeeTransaction("carol", 0, 5))
+	if _, _, err := pool.Add(createFeeTransaction("dave", 1, 9)); err != ErrPoolFull {
+		t.Fatalf("Expected ErrPoolFull, got %v", err)
+	}
+	_, evicted, err := pool.Add(createFeeTransaction("dave", 0, 2))
+	if err != nil || len(evicted) != 1 || evicted[0].From != "bob" {
+		t.Fatalf("Expected bob's queued transaction to be evicted, got %v, %v", evicted, err)
+	}
+	_, evicted, err = pool.Add(createFeeTransaction("erin", 0, 3))
+	if err != nil || len(evicted) != 1 || evicted[0].From != "alice" {
+		t.Fatalf("Expected alice's transaction to be evicted, got %v, %v", evicted, err)
+	}
+}
+
+// fillPool adds n transactions from n/10 senders with ten nonces each and
+// varying fees
+func fillPool(b *testing.B, n int) (*TxPool, []transaction.Transaction) {
+	b.Helper()
+	txs := make([]transaction.Transaction, n)
+	for i := range txs {
+		txs[i] = createFeeTransaction(fmt.Sprintf("sender%d", i/10), uint64(i%10), uint64((i*7919)%1000))
+	}
+	pool := NewTxPool()
+	cfg := DefaultConfig()
+	cfg.MaxSize = n
+	pool.SetConfig(cfg)
+	for i := range txs {
+		pool.Add(txs[i])
+	}
+	return pool, txs
+}
+
+const benchmarkPoolSize = 100000
+
+func BenchmarkTxPoolAdd(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		fillPool(b, benchmarkPoolSize)
+	}
+}
+
+func BenchmarkTxPoolGet(b *testing.B) {
+	pool, txs := fillPool(b, benchmarkPoolSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pool.Get(txs[i%len(txs)].Hash)
+	}
+}
+
+func BenchmarkTxPoolBest(b *testing.B) {
+	pool, _ := fillPool(b, benchmarkPoolSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pool.Best(1000)
+	}
+}
+
+func BenchmarkTxPoolRemoveAll(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		pool, _ := fillPool(b, benchmarkPoolSize)
+		block := pool.Best(1000)
+		hashes := make([][32]byte, len(block))
+		for j := range block {
+			hashes[j] = block[j].Hash
+		}
+		b.StartTimer()
+		pool.RemoveAll(hashes)
+	}
+}
@@ -0,0 +1,27 @@
+// Package leaktest checks that tests do not leave goroutines running.
+package leaktest
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// Timeout is how long WaitForGoroutines waits for goroutines to exit
+const Timeout = 5 * time.Second
+
+// WaitForGoroutines waits for the number of goroutines to drop back to at
+// most want, failing the test with a dump of every stack if it does not
+// within Timeout
+func WaitForGoroutines(t testing.TB, want int) {
+	t.Helper()
+	deadline := time.Now().Add(Timeout)
+	for runtime.NumGoroutine() > want {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("Leaked goroutines: %d running, want at most %d\n%s",
+				runtime.NumGoroutine(), want, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,19 @@
+package leaktest
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestWaitForGoroutines(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	stop := make(chan struct{})
+	go func() { <-stop }()
+	if runtime.NumGoroutine() <= baseline {
+		t.Fatal("Expected the goroutine to be running")
+	}
+
+	// Returns once the goroutine has exited
+	close(stop)
+	WaitForGoroutines(t, baseline)
+}
@@ -0,0 +1,36 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestRequestIDAttribute(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "json", slog.LevelInfo)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+
+	ctx := WithRequestID(context.Background(), "abc123")
+	logger.With("height", 7).InfoContext(ctx, "Block produced", Hash("hash", [32]byte{1}))
+	logger.Debug("Not logged below the level")
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Expected a single JSON record, got %q: %v", buf.String(), err)
+	}
+	if record["request_id"] != "abc123" || record["height"] != float64(7) || record["msg"] != "Block produced" {
+		t.Errorf("Unexpected record %v", record)
+	}
+	if hash, _ := record["hash"].(string); len(hash) != 64 || hash[:2] != "01" {
+		t.Errorf("Expected hex hash, got %v", record["hash"])
+	}
+
+	if _, err := New(&buf, "xml", slog.LevelInfo); err == nil {
+		t.Error("Expected unknown format to be rejected")
+	}
+}
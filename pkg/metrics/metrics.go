@@ -0,0 +1,127 @@
+// Package metrics defines the Prometheus metrics of the node and serves them
+// over HTTP.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "zkrollup"
+
+var (
+	// PoolSize is the number of transactions in the pool
+	PoolSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "txpool_size",
+		Help:      "Number of transactions in the pool.",
+	})
+
+	// TxAdmitted counts transactions admitted to the pool
+	TxAdmitted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "txpool_admitted_total",
+		Help:      "Transactions admitted to the pool.",
+	})
+
+	// TxRejected counts transactions rejected from the pool by reason
+	TxRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "txpool_rejected_total",
+		Help:      "Transactions rejected from the pool, by reason.",
+	}, []string{"reason"})
+
+	// TxDropped counts pooled transactions dropped without being included,
+	// by reason
+	TxDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "txpool_dropped_total",
+		Help:      "Pooled transactions dropped without being included, by reason.",
+	}, []string{"reason"})
+
+	// ChainHeight is the height of the latest block
+	ChainHeight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "chain_height",
+		Help:      "Height of the latest block.",
+	})
+
+	// BlockProduction is the time to build, execute and commit a block,
+	// before it is proven
+	BlockProduction = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "block_production_seconds",
+		Help:      "Time to build, execute and commit a block.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+	})
+
+	// ProofGeneration is the time to generate a block proof
+	ProofGeneration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "proof_generation_seconds",
+		Help:      "Time to generate a block proof.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+	})
+
+	// ProofVerification is the time to verify a block proof
+	ProofVerification = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "proof_verification_seconds",
+		Help:      "Time to verify a block proof.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 12),
+	})
+
+	// FabricSubmission is the time to submit a proof to Fabric
+	FabricSubmission = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "fabric_submission_seconds",
+		Help:      "Time to submit a block proof to Fabric.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	})
+
+	// FabricErrors counts failed proof submissions to Fabric
+	FabricErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fabric_submission_errors_total",
+		Help:      "Failed proof submissions to Fabric.",
+	})
+
+	// HTTPRequests is the duration of HTTP requests by method, route and
+	// status code
+	HTTPRequests = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of HTTP requests, by method, route and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "code"})
+)
+
+// registry holds the node metrics together with the Go runtime and process
+// metrics
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		PoolSize,
+		TxAdmitted,
+		TxRejected,
+		TxDropped,
+		ChainHeight,
+		BlockProduction,
+		ProofGeneration,
+		ProofVerification,
+		FabricSubmission,
+		FabricErrors,
+		HTTPRequests,
+	)
+}
+
+// Handler returns the HTTP handler serving the metrics in the Prometheus
+// text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,27 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	TxRejected.WithLabelValues("invalid_nonce").Inc()
+	HTTPRequests.WithLabelValues("GET", "/api/v1/state/root", "200").Observe(0.01)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"zkrollup_txpool_size",
+		`zkrollup_txpool_rejected_total{reason="invalid_nonce"} 1`,
+		`zkrollup_http_request_duration_seconds_count{code="200",method="GET",route="/api/v1/state/root"} 1`,
+		"zkrollup_proof_generation_seconds_bucket",
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected metrics output to contain %q", want)
+		}
+	}
+}
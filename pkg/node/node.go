@@ -0,0 +1,157 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/api/router"
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/blockchain"
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/follower"
+)
+
+// Config holds the settings of a node
+type Config struct {
+	ListenAddr   string        // Address the HTTP API listens on
+	SequencerURL string        // Sequencer to follow instead of producing blocks, if set
+	SyncInterval time.Duration // Interval between syncs in follower mode
+	Logger       *slog.Logger  // Logger of the API and follower, slog.Default() if nil
+}
+
+// Node runs a blockchain together with its HTTP API. A sequencer node
+// produces blocks; a follower node syncs them from a sequencer and serves a
+// read-only API.
+type Node struct {
+	bc       *blockchain.Blockchain
+	cfg      Config
+	handler  http.Handler
+	follower *follower.Follower // nil for a sequencer
+
+	mu       sync.Mutex
+	listener net.Listener
+	server   *http.Server
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// New creates a node for bc
+func New(bc *blockchain.Blockchain, cfg Config) *Node {
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	r := router.NewRouter(bc, cfg.Logger)
+	n := &Node{
+		bc:  bc,
+		cfg: cfg,
+	}
+	if cfg.SequencerURL != "" {
+		n.follower = follower.NewFollower(bc, cfg.SequencerURL, cfg.SyncInterval, cfg.Logger)
+		r.SetupReadOnly()
+	} else {
+		r.Setup()
+	}
+	n.handler = r.Handler()
+	return n
+}
+
+// Start starts serving the API and producing or following blocks. Background
+// work stops when ctx is cancelled; Stop shuts the node down completely.
+func (n *Node) Start(ctx context.Context) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.listener != nil {
+		return fmt.Errorf("node already started")
+	}
+	ln, err := net.Listen("tcp", n.cfg.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", n.cfg.ListenAddr, err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	if n.follower != nil {
+		n.cfg.Logger.Info("Following sequencer", "url", n.cfg.SequencerURL)
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			n.follower.Run(ctx)
+		}()
+	} else if err := n.bc.Start(ctx); err != nil {
+		cancel()
+		ln.Close()
+		return err
+	}
+
+	server := &http.Server{Handler: n.handler}
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			n.cfg.Logger.Error("HTTP server failed", "err", err)
+		}
+	}()
+
+	n.listener = ln
+	n.server = server
+	n.cancel = cancel
+	n.cfg.Logger.Info("Server is running", "addr", ln.Addr().String())
+	return nil
+}
+
+// Addr returns the address the API is listening on, or an empty string if
+// the node is not started
+func (n *Node) Addr() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.listener == nil {
+		return ""
+	}
+	return n.listener.Addr().String()
+}
+
+// Stop shuts the node down. The HTTP server stops accepting connections and
+// finishes in-flight requests, then block production or syncing stops, with
+// a block that is being proven allowed to finish. Stop gives up waiting when
+// ctx expires.
+func (n *Node) Stop(ctx context.Context) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.listener == nil {
+		return nil
+	}
+
+	var firstErr error
+	if err := n.server.Shutdown(ctx); err != nil {
+		firstErr = fmt.Errorf("failed to shut down HTTP server: %v", err)
+	}
+	n.cancel()
+	if n.follower == nil {
+		if err := n.bc.Stop(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		if firstErr == nil {
+			firstErr = fmt.Errorf("node did not stop: %v", ctx.Err())
+		}
+	}
+
+	n.listener = nil
+	n.server = nil
+	n.cfg.Logger.Info("Node stopped")
+	return firstErr
+}
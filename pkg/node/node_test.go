@@ -0,0 +1,120 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/blockchain"
+	"github.com/StupidBug/fabric-zkrollup/pkg/leaktest"
+	"github.com/StupidBug/fabric-zkrollup/pkg/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNodeStartStop(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	baseline := runtime.NumGoroutine()
+
+	for _, cfg := range []Config{
+		{ListenAddr: "127.0.0.1:0"},
+		{ListenAddr: "127.0.0.1:0", SequencerURL: "http://127.0.0.1:1", SyncInterval: time.Hour},
+	} {
+		bc, err := blockchain.NewBlockchain()
+		if err != nil {
+			t.Fatalf("Failed to create blockchain: %v", err)
+		}
+		n := New(bc, cfg)
+		if err := n.Start(context.Background()); err != nil {
+			t.Fatalf("Failed to start node: %v", err)
+		}
+		if err := n.Start(context.Background()); err == nil {
+			t.Error("Expected error when starting twice")
+		}
+
+		client := &http.Client{Timeout: 5 * time.Second}
+		resp, err := client.Get("http://" + n.Addr() + "/api/v1/state/root")
+		if err != nil {
+			t.Fatalf("Failed to query node: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", resp.StatusCode)
+		}
+		resp, err = client.Get("http://" + n.Addr() + "/metrics")
+		if err != nil {
+			t.Fatalf("Failed to query metrics: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected metrics status 200, got %d", resp.StatusCode)
+		}
+		addr := n.Addr()
+		client.CloseIdleConnections()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = n.Stop(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("Failed to stop node: %v", err)
+		}
+		if _, err := client.Get("http://" + addr + "/api/v1/state/root"); err == nil {
+			t.Error("Expected stopped node to refuse connections")
+		}
+	}
+
+	leaktest.WaitForGoroutines(t, baseline)
+}
+
+func TestNodeRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, "json", slog.LevelInfo)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	bc, err := blockchain.NewBlockchain()
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	n := New(bc, Config{ListenAddr: "127.0.0.1:0", Logger: logger})
+	if err := n.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start node: %v", err)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	req, _ := http.NewRequest(http.MethodGet, "http://"+n.Addr()+"/api/v1/state/root", nil)
+	req.Header.Set(logging.RequestIDHeader, "client-id")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to query node: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get(logging.RequestIDHeader); got != "client-id" {
+		t.Errorf("Expected request ID to be echoed, got %q", got)
+	}
+	resp, err = client.Get("http://" + n.Addr() + "/api/v1/state/root")
+	if err != nil {
+		t.Fatalf("Failed to query node: %v", err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get(logging.RequestIDHeader) == "" {
+		t.Error("Expected a request ID to be generated")
+	}
+	client.CloseIdleConnections()
+
+	// Stopping waits for in-flight requests, so their logs are complete
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := n.Stop(ctx); err != nil {
+		t.Fatalf("Failed to stop node: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"request_id":"client-id"`) {
+		t.Errorf("Expected request log with request ID, got:\n%s", buf.String())
+	}
+}
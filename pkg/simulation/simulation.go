@@ -0,0 +1,185 @@
+// Package simulation drives a sequencer chain step by step on a manual clock
+// and seeded randomness, so that a scripted run always produces the same
+// chain, byte for byte, and runs as fast as the transactions execute.
+//
+// Blocks are not proven: Groth16 proofs are randomized by the proving system
+// itself and take seconds each, so simulated blocks stay executed.
+package simulation
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"time"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/clock"
+	"github.com/StupidBug/fabric-zkrollup/pkg/core/blockchain"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+)
+
+// Config holds the parameters of a simulation
+type Config struct {
+	Seed     int64         // Seed of the keys, signatures and proof choices
+	Start    time.Time     // Time the clock starts at
+	Interval time.Duration // Time each step advances the clock by
+}
+
+// DefaultConfig returns a simulation with seed 1 starting at the Unix epoch
+// with one second steps
+func DefaultConfig() Config {
+	return Config{
+		Seed:     1,
+		Start:    time.Unix(0, 0).UTC(),
+		Interval: time.Second,
+	}
+}
+
+// Transfer is a scripted transfer. The nonce is filled in from the sender's
+// pending nonce.
+type Transfer struct {
+	From  string
+	To    string
+	Value types.Amount
+}
+
+// Step is one block interval of a script: the transfers submitted during the
+// interval, which go into the block produced at its end
+type Step struct {
+	Transfers []Transfer
+}
+
+// Simulation is an in-memory sequencer driven step by step
+type Simulation struct {
+	chain    *blockchain.Blockchain
+	clock    *clock.Manual
+	random   *rand.Rand
+	interval time.Duration
+	keys     map[string]*ecdsa.PrivateKey
+	rejected int
+}
+
+// New creates a simulation of a fresh chain with the default genesis
+func New(cfg Config) (*Simulation, error) {
+	if cfg.Interval <= 0 {
+		return nil, fmt.Errorf("step interval must be positive")
+	}
+	chain, err := blockchain.NewBlockchain()
+	if err != nil {
+		return nil, err
+	}
+	s := &Simulation{
+		chain:    chain,
+		clock:    clock.NewManual(cfg.Start),
+		random:   rand.New(rand.NewSource(cfg.Seed)),
+		interval: cfg.Interval,
+		keys:     make(map[string]*ecdsa.PrivateKey),
+	}
+	s.chain.SetClock(s.clock)
+	s.chain.SetRandom(s.random)
+	s.chain.SetProver(nil)
+	return s, nil
+}
+
+// Chain returns the simulated chain
+func (s *Simulation) Chain() *blockchain.Blockchain {
+	return s.chain
+}
+
+// Clock returns the clock of the simulation
+func (s *Simulation) Clock() *clock.Manual {
+	return s.clock
+}
+
+// Rejected returns the number of scripted transfers the pool rejected
+func (s *Simulation) Rejected() int {
+	return s.rejected
+}
+
+// Submit signs a transfer with the sender's simulated key and adds it to the
+// pool, as a client of the API would
+func (s *Simulation) Submit(tr Transfer) error {
+	key := s.key(tr.From)
+	if s.chain.GetPublicKey(tr.From) == nil {
+		s.chain.SetPublicKey(tr.From, &key.PublicKey)
+	}
+	tx := transaction.Transaction{
+		From:      tr.From,
+		To:        tr.To,
+		Value:     tr.Value,
+		Nonce:     s.chain.GetPendingNonce(tr.From),
+		Status:    transaction.StatusPending,
+		Timestamp: s.clock.Now().Unix(),
+	}
+	if err := tx.SignTransactionWith(key, s.random); err != nil {
+		return err
+	}
+	tx.Hash = tx.ComputeHash()
+	return s.chain.AddTransaction(tx)
+}
+
+// Step submits the transfers of step, advances the clock by one interval and
+// produces a block if the pool holds any executable transaction. Transfers the pool
+// rejects are counted and otherwise ignored.
+func (s *Simulation) Step(step Step) error {
+	for _, tr := range step.Transfers {
+		err := s.Submit(tr)
+		var rejected *blockchain.RejectError
+		if errors.As(err, &rejected) {
+			s.rejected++
+		} else if err != nil {
+			return fmt.Errorf("failed to submit transfer from %s: %v", tr.From, err)
+		}
+	}
+	s.clock.Advance(s.interval)
+	if len(s.chain.GetPendingTransactions()) == 0 {
+		return nil
+	}
+	return s.chain.CreateBlock()
+}
+
+// Run runs every step of script in order
+func (s *Simulation) Run(script []Step) error {
+	for i, step := range script {
+		if err := s.Step(step); err != nil {
+			return fmt.Errorf("step %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// Digest returns the SHA-256 hash of the JSON encoding of every block, which
+// is the same for byte-identical chains
+func (s *Simulation) Digest() ([32]byte, error) {
+	data, err := json.Marshal(s.chain.GetAllBlocks())
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("failed to encode chain: %v", err)
+	}
+	return sha256.Sum256(data), nil
+}
+
+// key returns the simulated key of address, derived from the seed when the
+// address first sends. ecdsa.GenerateKey is not reproducible from a seeded
+// source, so the scalar is drawn directly.
+func (s *Simulation) key(address string) *ecdsa.PrivateKey {
+	if key, ok := s.keys[address]; ok {
+		return key
+	}
+	curve := elliptic.P256()
+	n := curve.Params().N
+	var b [32]byte
+	s.random.Read(b[:])
+	d := new(big.Int).SetBytes(b[:])
+	d.Mod(d, new(big.Int).Sub(n, big.NewInt(1)))
+	d.Add(d, big.NewInt(1))
+
+	key := &ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve}, D: d}
+	key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(d.Bytes())
+	s.keys[address] = key
+	return key
+}
@@ -0,0 +1,69 @@
+package simulation
+
+import (
+	"testing"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
+)
+
+const (
+	alice = "0000000000000000000000000000000000000001"
+	bob   = "0000000000000000000000000000000000000002"
+	carol = "0000000000000000000000000000000000000003"
+)
+
+var script = []Step{
+	{Transfers: []Transfer{{alice, bob, types.NewAmount(100)}, {alice, carol, types.NewAmount(50)}}},
+	{},
+	{Transfers: []Transfer{{bob, carol, types.NewAmount(10)}, {carol, alice, types.NewAmount(5)}, {alice, bob, types.NewAmount(1)}}},
+	// Rejected: more than bob has
+	{Transfers: []Transfer{{bob, alice, types.NewAmount(1000000)}, {carol, bob, types.NewAmount(7)}}},
+}
+
+func run(t *testing.T, seed int64) (*Simulation, [32]byte) {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.Seed = seed
+	sim, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create simulation: %v", err)
+	}
+	if err := sim.Run(script); err != nil {
+		t.Fatalf("Failed to run script: %v", err)
+	}
+	digest, err := sim.Digest()
+	if err != nil {
+		t.Fatalf("Failed to compute digest: %v", err)
+	}
+	return sim, digest
+}
+
+func TestSimulationIsDeterministic(t *testing.T) {
+	sim, first := run(t, 42)
+	_, second := run(t, 42)
+	if first != second {
+		t.Fatalf("Expected identical chains, got digests %x and %x", first, second)
+	}
+	if _, other := run(t, 43); other == first {
+		t.Error("Expected a different seed to give a different chain")
+	}
+
+	// The empty step produces no block
+	bc := sim.Chain()
+	if bc.GetHeight() != 4 {
+		t.Errorf("Expected height 4, got %d", bc.GetHeight())
+	}
+	if sim.Rejected() != 1 {
+		t.Errorf("Expected 1 rejected transfer, got %d", sim.Rejected())
+	}
+	if got := bc.GetBalance(alice); got != types.NewAmount(1000000-100-50+5-1) {
+		t.Errorf("Unexpected balance of alice: %v", got)
+	}
+	latest := bc.GetLatestBlock()
+	if got := latest.Header.Timestamp; !got.Equal(DefaultConfig().Start.Add(4 * DefaultConfig().Interval)) {
+		t.Errorf("Expected block timestamp from the simulated clock, got %v", got)
+	}
+	if err := latest.VerifySignature(); err != nil {
+		t.Errorf("Invalid block signature: %v", err)
+	}
+}
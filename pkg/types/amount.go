@@ -0,0 +1,152 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/bits"
+)
+
+// AmountBits is the width of balances and transfer values. The circuit
+// range-checks every amount against it, so a sum of two amounts can never
+// wrap around the field.
+const AmountBits = 128
+
+var (
+	// ErrNegativeAmount is returned when an amount would drop below zero
+	ErrNegativeAmount = errors.New("amount is negative")
+	// ErrAmountOverflow is returned when an amount would not fit in AmountBits
+	ErrAmountOverflow = errors.New("amount overflows 128 bits")
+)
+
+// Amount is an unsigned AmountBits-wide integer, used for balances and
+// transfer values. The zero value is zero, and amounts can be compared with
+// ==. In JSON an amount is a decimal string.
+type Amount struct {
+	hi, lo uint64
+}
+
+// NewAmount returns the amount v
+func NewAmount(v uint64) Amount {
+	return Amount{lo: v}
+}
+
+// MaxAmount returns the largest amount, 2^AmountBits - 1
+func MaxAmount() Amount {
+	return Amount{hi: ^uint64(0), lo: ^uint64(0)}
+}
+
+// ParseAmount parses a decimal amount
+func ParseAmount(s string) (Amount, error) {
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok || s[0] == '+' {
+		return Amount{}, fmt.Errorf("invalid amount %q", s)
+	}
+	return AmountFromBig(v)
+}
+
+// AmountFromBig converts v, which must be in range, to an amount
+func AmountFromBig(v *big.Int) (Amount, error) {
+	if v.Sign() < 0 {
+		return Amount{}, ErrNegativeAmount
+	}
+	if v.BitLen() > AmountBits {
+		return Amount{}, ErrAmountOverflow
+	}
+	lo := new(big.Int).And(v, new(big.Int).SetUint64(^uint64(0)))
+	hi := new(big.Int).Rsh(v, 64)
+	return Amount{hi: hi.Uint64(), lo: lo.Uint64()}, nil
+}
+
+// Big returns the amount as a new big integer
+func (a Amount) Big() *big.Int {
+	v := new(big.Int).SetUint64(a.hi)
+	v.Lsh(v, 64)
+	return v.Or(v, new(big.Int).SetUint64(a.lo))
+}
+
+// IsZero reports whether the amount is zero
+func (a Amount) IsZero() bool {
+	return a.hi == 0 && a.lo == 0
+}
+
+// Cmp returns -1, 0 or +1 as a is less than, equal to or greater than b
+func (a Amount) Cmp(b Amount) int {
+	switch {
+	case a.hi < b.hi, a.hi == b.hi && a.lo < b.lo:
+		return -1
+	case a == b:
+		return 0
+	default:
+		return 1
+	}
+}
+
+// Add returns a + b, or ErrAmountOverflow if the sum does not fit
+func (a Amount) Add(b Amount) (Amount, error) {
+	lo, carry := bits.Add64(a.lo, b.lo, 0)
+	hi, carry := bits.Add64(a.hi, b.hi, carry)
+	if carry != 0 {
+		return Amount{}, ErrAmountOverflow
+	}
+	return Amount{hi: hi, lo: lo}, nil
+}
+
+// Sub returns a - b, or ErrNegativeAmount if b is greater than a
+func (a Amount) Sub(b Amount) (Amount, error) {
+	lo, borrow := bits.Sub64(a.lo, b.lo, 0)
+	hi, borrow := bits.Sub64(a.hi, b.hi, borrow)
+	if borrow != 0 {
+		return Amount{}, ErrNegativeAmount
+	}
+	return Amount{hi: hi, lo: lo}, nil
+}
+
+// String returns the amount in decimal
+func (a Amount) String() string {
+	if a.hi == 0 {
+		return fmt.Sprintf("%d", a.lo)
+	}
+	return a.Big().String()
+}
+
+// MarshalJSON encodes the amount as a decimal string
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON decodes a decimal string. A bare JSON number is accepted as
+// well, so blocks and state persisted before amounts were strings still load.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	}
+	v, err := ParseAmount(s)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+// UnmarshalYAML decodes a decimal amount from a YAML scalar, quoted or not
+func (a *Amount) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	v, err := ParseAmount(s)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
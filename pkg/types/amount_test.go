@@ -0,0 +1,92 @@
+package types
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	max := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), AmountBits), big.NewInt(1))
+	tests := []struct {
+		input string
+		want  string // Empty if the input is rejected
+	}{
+		{"0", "0"},
+		{"1000", "1000"},
+		{"18446744073709551616", "18446744073709551616"}, // 2^64
+		{max.String(), max.String()},
+		{new(big.Int).Add(max, big.NewInt(1)).String(), ""},
+		{"-1", ""},
+		{"+1", ""},
+		{"1.5", ""},
+		{"0x10", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got, err := ParseAmount(tt.input)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("Expected %q to be rejected, got %s", tt.input, got)
+			}
+			continue
+		}
+		if err != nil || got.String() != tt.want {
+			t.Errorf("Expected %q to parse as %s, got %s (%v)", tt.input, tt.want, got, err)
+		}
+	}
+	if MaxAmount().String() != max.String() {
+		t.Errorf("Expected max amount %s, got %s", max, MaxAmount())
+	}
+}
+
+func TestAmountArithmetic(t *testing.T) {
+	a, b := NewAmount(^uint64(0)), NewAmount(1)
+	sum, err := a.Add(b)
+	if err != nil || sum.String() != "18446744073709551616" {
+		t.Fatalf("Expected the carry into the high word, got %s (%v)", sum, err)
+	}
+	if diff, err := sum.Sub(b); err != nil || diff != a {
+		t.Errorf("Expected %s, got %s (%v)", a, diff, err)
+	}
+	if sum.Cmp(a) != 1 || a.Cmp(sum) != -1 || a.Cmp(a) != 0 {
+		t.Error("Unexpected comparison across the word boundary")
+	}
+
+	if _, err := MaxAmount().Add(b); err != ErrAmountOverflow {
+		t.Errorf("Expected %v, got %v", ErrAmountOverflow, err)
+	}
+	if _, err := b.Sub(NewAmount(2)); err != ErrNegativeAmount {
+		t.Errorf("Expected %v, got %v", ErrNegativeAmount, err)
+	}
+	if !(Amount{}).IsZero() || b.IsZero() {
+		t.Error("Expected only the zero value to be zero")
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+	var v struct {
+		Balance Amount
+	}
+	v.Balance = MaxAmount()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Failed to marshal amount: %v", err)
+	}
+	if string(data) != `{"Balance":"340282366920938463463374607431768211455"}` {
+		t.Errorf("Expected a decimal string, got %s", data)
+	}
+
+	// Numbers written before amounts were strings are still read
+	for _, input := range []string{`{"Balance":"500"}`, `{"Balance":500}`} {
+		v.Balance = Amount{}
+		if err := json.Unmarshal([]byte(input), &v); err != nil || v.Balance != NewAmount(500) {
+			t.Errorf("Expected %s to decode as 500, got %s (%v)", input, v.Balance, err)
+		}
+	}
+	for _, input := range []string{`{"Balance":"-5"}`, `{"Balance":1e3}`, `{"Balance":"340282366920938463463374607431768211456"}`} {
+		if err := json.Unmarshal([]byte(input), &v); err == nil {
+			t.Errorf("Expected %s to be rejected", input)
+		}
+	}
+}
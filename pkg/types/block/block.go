@@ -1,16 +1,24 @@
 package block
 
 import (
+	"crypto/ecdsa"
+	"crypto/rand"
 	"crypto/sha256"
+	"fmt"
+	"io"
 	"time"
 
 	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
+	"github.com/StupidBug/fabric-zkrollup/pkg/zk"
 )
 
 // Block represents a block in the blockchain
 type Block struct {
 	Header       Header
 	Transactions []transaction.Transaction
+	Proof        *zk.ProofOutput       // ZK proof of the state transition, nil for genesis
+	Status       transaction.Status    // Finality status of the block and its transactions
+	Signature    transaction.Signature // Sequencer signature over the header hash, unset for genesis
 }
 
 // Header contains the header information of a block
@@ -22,6 +30,9 @@ type Header struct {
 	Timestamp        time.Time // Block timestamp
 	Height           uint64    // Block height
 	TransactionCount uint32    // Number of transactions in the block
+	// Key of the sequencer that produced the block. In the genesis block it
+	// is the key pinned as the first sequencer.
+	Sequencer transaction.PublicKey
 }
 
 // ComputeHash calculates the hash of a block header
@@ -34,9 +45,45 @@ func (h *Header) ComputeHash() [32]byte {
 	data = append(data, []byte(h.Timestamp.String())...)
 	data = append(data, byte(h.Height))
 	data = append(data, byte(h.TransactionCount))
+	data = append(data, h.Sequencer.Bytes()...)
 	return sha256.Sum256(data)
 }
 
+// Sign records the sequencer key in the header and signs the header hash
+// with it
+func (b *Block) Sign(key *ecdsa.PrivateKey) error {
+	return b.SignWith(key, rand.Reader)
+}
+
+// SignWith is Sign drawing the signing randomness from random
+func (b *Block) SignWith(key *ecdsa.PrivateKey, random io.Reader) error {
+	b.Header.Sequencer = transaction.NewPublicKey(&key.PublicKey)
+	hash := b.Header.ComputeHash()
+	sig, err := transaction.SignHash(key, hash[:], random)
+	if err != nil {
+		return fmt.Errorf("failed to sign block: %v", err)
+	}
+	b.Signature = sig
+	return nil
+}
+
+// VerifySignature checks the block signature against the sequencer key in
+// the header
+func (b *Block) VerifySignature() error {
+	key := b.Header.Sequencer.ECDSA()
+	if key == nil {
+		return fmt.Errorf("block has no sequencer key")
+	}
+	if b.Signature.R == nil || b.Signature.S == nil {
+		return fmt.Errorf("block is not signed")
+	}
+	hash := b.Header.ComputeHash()
+	if !ecdsa.Verify(key, hash[:], b.Signature.R, b.Signature.S) {
+		return fmt.Errorf("invalid block signature")
+	}
+	return nil
+}
+
 // ComputeHash computes the hash of the block
 func (b *Block) ComputeHash() [32]byte {
 	// Compute hash based on header fields
@@ -1,10 +1,14 @@
 package block
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"testing"
 	"time"
 
 	"github.com/StupidBug/fabric-zkrollup/pkg/crypto"
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
 	"github.com/StupidBug/fabric-zkrollup/pkg/types/transaction"
 )
 
@@ -12,7 +16,7 @@ func createTestTransaction(from, to string, value int64, nonce uint64) transacti
 	transaction := transaction.Transaction{
 		From:      from,
 		To:        to,
-		Value:     int(value),
+		Value:     types.NewAmount(uint64(value)),
 		Nonce:     nonce,
 		Status:    transaction.StatusPending,
 		Timestamp: time.Now().Unix(),
@@ -149,3 +153,38 @@ func TestEmptyBlock(t *testing.T) {
 		t.Error("Empty block should have empty Merkle root")
 	}
 }
+
+func TestBlockSignature(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	b := &Block{
+		Header: Header{
+			Version:   1,
+			PrevHash:  [32]byte{1, 2, 3},
+			StateRoot: "42",
+			Timestamp: time.Now(),
+			Height:    1,
+		},
+	}
+	if err := b.VerifySignature(); err == nil {
+		t.Error("Expected unsigned block to fail verification")
+	}
+
+	if err := b.Sign(key); err != nil {
+		t.Fatalf("Failed to sign block: %v", err)
+	}
+	if err := b.VerifySignature(); err != nil {
+		t.Errorf("Expected signed block to verify: %v", err)
+	}
+
+	// The signature covers every header field, including the sequencer key
+	b.Header.StateRoot = "43"
+	if err := b.VerifySignature(); err == nil {
+		t.Error("Expected modified header to fail verification")
+	}
+	b.Header.StateRoot = "42"
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	b.Header.Sequencer = transaction.NewPublicKey(&other.PublicKey)
+	if err := b.VerifySignature(); err == nil {
+		t.Error("Expected substituted sequencer key to fail verification")
+	}
+}
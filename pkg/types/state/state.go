@@ -2,45 +2,57 @@ package state
 
 import (
 	"crypto/ecdsa"
+	"crypto/elliptic"
 	"sync"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
 )
 
 // AccountState represents the state of an account
 type AccountState struct {
-	Balance int
+	Balance types.Amount
 	Nonce   uint64
 }
 
 // State represents the current state of the blockchain
 type State struct {
 	mu       sync.RWMutex
-	balances map[string]int              // Address -> Balance mapping
+	balances map[string]types.Amount     // Address -> Balance mapping
 	nonces   map[string]uint64           // Address -> Nonce mapping
 	pubKeys  map[string]*ecdsa.PublicKey // Address -> Public Key mapping
+	// Key the next block must be signed with
+	sequencerKey *ecdsa.PublicKey
 }
 
 // NewState creates a new state instance
 func NewState() *State {
 	return &State{
-		balances: make(map[string]int),
+		balances: make(map[string]types.Amount),
 		nonces:   make(map[string]uint64),
 		pubKeys:  make(map[string]*ecdsa.PublicKey),
 	}
 }
 
 // GetBalance returns the balance of an address
-func (s *State) GetBalance(address string) int {
+func (s *State) GetBalance(address string) types.Amount {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if balance, exists := s.balances[address]; exists {
-		return balance
-	}
-	return 0
+	return s.balances[address]
+}
+
+// HasAccount reports whether address has an account, that is a balance,
+// even a zero one
+func (s *State) HasAccount(address string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.balances[address]
+	return ok
 }
 
 // SetBalance sets the balance for an address
-func (s *State) SetBalance(address string, balance int) {
+func (s *State) SetBalance(address string, balance types.Amount) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -79,6 +91,22 @@ func (s *State) SetPublicKey(address string, pubKey *ecdsa.PublicKey) {
 	s.pubKeys[address] = pubKey
 }
 
+// GetSequencerKey returns the key of the authorized sequencer
+func (s *State) GetSequencerKey() *ecdsa.PublicKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.sequencerKey
+}
+
+// SetSequencerKey sets the key of the authorized sequencer
+func (s *State) SetSequencerKey(key *ecdsa.PublicKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sequencerKey = key
+}
+
 // Clone creates a deep copy of the state
 func (s *State) Clone() *State {
 	s.mu.RLock()
@@ -94,6 +122,7 @@ func (s *State) Clone() *State {
 	for addr, pubKey := range s.pubKeys {
 		newState.pubKeys[addr] = pubKey
 	}
+	newState.sequencerKey = s.sequencerKey
 	return newState
 }
 
@@ -111,3 +140,62 @@ func (s *State) GetAllAccounts() map[string]*AccountState {
 	}
 	return accounts
 }
+
+// PriorAccount is the state of an account before a block changed it
+type PriorAccount struct {
+	Exists  bool         `json:"exists"` // Whether the account had a balance
+	Balance types.Amount `json:"balance"`
+	Nonce   uint64       `json:"nonce"`
+}
+
+// Diff holds the values a block overwrote, so that the block can be undone
+// and the state at earlier heights rebuilt
+type Diff struct {
+	Accounts     map[string]PriorAccount `json:"accounts"`      // Address -> state before the block
+	SequencerKey []byte                  `json:"sequencer_key"` // Uncompressed sequencer key before the block
+}
+
+// Record returns a diff holding the current values of addresses. It is taken
+// before a block that touches only those addresses is applied.
+func (s *State) Record(addresses []string) *Diff {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	d := &Diff{Accounts: make(map[string]PriorAccount, len(addresses))}
+	if s.sequencerKey != nil {
+		d.SequencerKey = elliptic.Marshal(elliptic.P256(), s.sequencerKey.X, s.sequencerKey.Y)
+	}
+	for _, addr := range addresses {
+		balance, exists := s.balances[addr]
+		d.Accounts[addr] = PriorAccount{
+			Exists:  exists,
+			Balance: balance,
+			Nonce:   s.nonces[addr],
+		}
+	}
+	return d
+}
+
+// Revert restores the values recorded in d, undoing the block it was taken
+// for. Blocks must be reverted newest first.
+func (s *State) Revert(d *Diff) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for addr, prior := range d.Accounts {
+		if prior.Exists {
+			s.balances[addr] = prior.Balance
+		} else {
+			delete(s.balances, addr)
+		}
+		if prior.Nonce != 0 {
+			s.nonces[addr] = prior.Nonce
+		} else {
+			delete(s.nonces, addr)
+		}
+	}
+	s.sequencerKey = nil
+	if x, y := elliptic.Unmarshal(elliptic.P256(), d.SequencerKey); x != nil {
+		s.sequencerKey = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	}
+}
@@ -0,0 +1,37 @@
+package transaction
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"io"
+)
+
+// SignHash signs hash with key using ecdsa.Sign, drawing the randomness from
+// random. ecdsa.Sign mixes its randomness with the key and the hash, but may
+// read an extra byte first, so 32 bytes are read from random up front and
+// handed over as a seedReader. Signatures are therefore unpredictable with a
+// random source such as crypto/rand.Reader and reproducible with a seeded
+// one. Go only honours a caller's reader with GODEBUG cryptocustomrand=1,
+// the default for the Go version of this module.
+func SignHash(key *ecdsa.PrivateKey, hash []byte, random io.Reader) (Signature, error) {
+	var seed seedReader
+	if _, err := io.ReadFull(random, seed[:]); err != nil {
+		return Signature{}, fmt.Errorf("failed to read randomness: %v", err)
+	}
+	r, s, err := ecdsa.Sign(&seed, key, hash)
+	if err != nil {
+		return Signature{}, err
+	}
+	return Signature{R: r, S: s}, nil
+}
+
+// seedReader fills every read with its bytes repeated from the start, so
+// what a reader reads does not depend on how much was read before
+type seedReader [32]byte
+
+func (r *seedReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r[i%len(r)]
+	}
+	return len(p), nil
+}
@@ -0,0 +1,48 @@
+package transaction
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	mrand "math/rand"
+	"testing"
+)
+
+func TestSignHash(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate key: %v", err)
+		}
+		hash := sha256.Sum256([]byte{byte(i)})
+
+		sig, err := SignHash(key, hash[:], rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to sign: %v", err)
+		}
+		if !ecdsa.Verify(&key.PublicKey, hash[:], sig.R, sig.S) {
+			t.Fatalf("Signature %d does not verify", i)
+		}
+
+		// The same randomness gives the same signature, other randomness
+		// a different one
+		first, _ := SignHash(key, hash[:], mrand.New(mrand.NewSource(1)))
+		second, _ := SignHash(key, hash[:], mrand.New(mrand.NewSource(1)))
+		other, _ := SignHash(key, hash[:], mrand.New(mrand.NewSource(2)))
+		if first.R.Cmp(second.R) != 0 || first.S.Cmp(second.S) != 0 {
+			t.Fatal("Expected identical signatures from identical randomness")
+		}
+		if first.R.Cmp(other.R) == 0 {
+			t.Fatal("Expected different signatures from different randomness")
+		}
+		if !ecdsa.Verify(&key.PublicKey, hash[:], first.R, first.S) {
+			t.Fatalf("Seeded signature %d does not verify", i)
+		}
+	}
+
+	if _, err := SignHash(nil, nil, bytes.NewReader(nil)); err == nil {
+		t.Error("Expected error when randomness runs out")
+	}
+}
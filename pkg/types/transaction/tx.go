@@ -1,73 +1,248 @@
 package transaction
 
 import (
+	"bytes"
 	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
 	"math/big"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
 )
 
-// Status represents the status of a transaction
+// Status represents the status of a transaction. Once a transaction is
+// included in a block it shares the finality status of that block, which
+// advances from executed to proven, submitted and finally finalized.
 type Status int
 
 const (
-	StatusPending Status = iota
-	StatusConfirmed
-	StatusFailed
+	StatusPending   Status = iota // Waiting in the pool
+	StatusExecuted                // Included in a locally executed block
+	StatusFailed                  // Dropped from the pool
+	StatusProven                  // The block's ZK proof has been generated
+	StatusSubmitted               // The proof has been sent to Fabric
+	StatusFinalized               // Fabric has committed the verified proof
+	StatusReplaced                // Replaced in the pool by a transaction of the same nonce paying a higher fee
 )
 
 func (s Status) String() string {
 	switch s {
 	case StatusPending:
 		return "pending"
-	case StatusConfirmed:
-		return "confirmed"
+	case StatusExecuted:
+		return "executed"
 	case StatusFailed:
 		return "failed"
+	case StatusProven:
+		return "proven"
+	case StatusSubmitted:
+		return "submitted"
+	case StatusFinalized:
+		return "finalized"
+	case StatusReplaced:
+		return "replaced"
+	default:
+		return "unknown"
+	}
+}
+
+// Included reports whether s is the status of a transaction in a block
+func (s Status) Included() bool {
+	switch s {
+	case StatusExecuted, StatusProven, StatusSubmitted, StatusFinalized:
+		return true
+	default:
+		return false
+	}
+}
+
+// Type distinguishes value transfers from governance transactions
+type Type int
+
+const (
+	TypeTransfer        Type = iota // Moves Value from From to To
+	TypeRotateSequencer             // Replaces the sequencer key with the public key in Data
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeTransfer:
+		return "transfer"
+	case TypeRotateSequencer:
+		return "rotate_sequencer"
 	default:
 		return "unknown"
 	}
 }
 
+// Cost returns the abstract execution cost of a transaction of type t. A
+// transfer occupies a slot of the proving circuit; governance transactions
+// are only executed by the nodes.
+func (t Type) Cost() int {
+	switch t {
+	case TypeTransfer:
+		return CostTransfer
+	case TypeRotateSequencer:
+		return CostRotateSequencer
+	default:
+		return CostTransfer
+	}
+}
+
+// Execution costs of the transaction types
+const (
+	CostTransfer        = 100
+	CostRotateSequencer = 20
+)
+
+// ParseType parses the name of a transaction type
+func ParseType(name string) (Type, error) {
+	for _, t := range []Type{TypeTransfer, TypeRotateSequencer} {
+		if t.String() == name {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown transaction type %q", name)
+}
+
+// GovernanceAddress is the sender of governance transactions. They are signed
+// with the current sequencer key rather than an account key.
+const GovernanceAddress = "0000000000000000000000000000000000000000"
+
 // Signature represents an ECDSA signature
 type Signature struct {
 	R *big.Int
 	S *big.Int
 }
 
+// PublicKey represents an ECDSA P-256 public key
+type PublicKey struct {
+	X *big.Int
+	Y *big.Int
+}
+
+// NewPublicKey converts an ECDSA public key
+func NewPublicKey(publicKey *ecdsa.PublicKey) PublicKey {
+	return PublicKey{X: publicKey.X, Y: publicKey.Y}
+}
+
+// ParsePublicKey parses a public key in uncompressed form, 0x04 followed by
+// the X and Y coordinates
+func ParsePublicKey(data []byte) (PublicKey, error) {
+	x, y := elliptic.Unmarshal(elliptic.P256(), data)
+	if x == nil {
+		return PublicKey{}, fmt.Errorf("invalid P-256 public key")
+	}
+	return PublicKey{X: x, Y: y}, nil
+}
+
+// ECDSA returns the key as an ECDSA public key, or nil if it is unset
+func (pk PublicKey) ECDSA() *ecdsa.PublicKey {
+	if pk.X == nil || pk.Y == nil {
+		return nil
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: pk.X, Y: pk.Y}
+}
+
+// Bytes returns the key in uncompressed form, or nil if it is unset
+func (pk PublicKey) Bytes() []byte {
+	if pk.X == nil || pk.Y == nil {
+		return nil
+	}
+	return elliptic.Marshal(elliptic.P256(), pk.X, pk.Y)
+}
+
+// Address returns the account address derived from the key: the last 20
+// bytes of the SHA-256 hash of its uncompressed form, hex encoded. It is
+// empty if the key is unset.
+func (pk PublicKey) Address() string {
+	data := pk.Bytes()
+	if data == nil {
+		return ""
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[len(hash)-20:])
+}
+
+// Equal reports whether pk and other are the same key
+func (pk PublicKey) Equal(other PublicKey) bool {
+	return bytes.Equal(pk.Bytes(), other.Bytes())
+}
+
 // Transaction represents a transaction in the blockchain
 type Transaction struct {
-	Hash      [32]byte  // Hash of the transaction
-	From      string    // Sender's address
-	To        string    // Recipient's address
-	Value     int       // Amount to transfer
-	Nonce     uint64    // Transaction nonce
-	Status    Status    // Transaction status
-	Timestamp int64     // Transaction timestamp
-	Signature Signature // Transaction signature
+	Hash      [32]byte     // Hash of the transaction
+	From      string       // Sender's address
+	To        string       // Recipient's address
+	Value     types.Amount // Amount to transfer
+	Nonce     uint64       // Transaction nonce
+	Status    Status       // Transaction status
+	Timestamp int64        // Transaction timestamp
+	Signature Signature    // Transaction signature
+	PublicKey PublicKey    // Sender's public key, recorded so blocks can be re-verified
+	Type      Type         // Transaction type, a transfer unless set
+	Data      []byte       // Payload of governance transactions
+	Fee       types.Amount // Fee burned from the sender's balance on top of Value; it also orders the pool
+}
+
+// NewRotateSequencerTx returns an unsigned governance transaction that
+// replaces the sequencer key with newKey. It must be signed with the current
+// sequencer key.
+func NewRotateSequencerTx(newKey *ecdsa.PublicKey, nonce uint64) Transaction {
+	tx := Transaction{
+		From:  GovernanceAddress,
+		Nonce: nonce,
+		Type:  TypeRotateSequencer,
+		Data:  NewPublicKey(newKey).Bytes(),
+	}
+	tx.Hash = tx.ComputeHash()
+	return tx
 }
 
 // ComputeHash calculates the hash of a transaction
 func (tx *Transaction) ComputeHash() [32]byte {
-	data := []byte(fmt.Sprintf("%s%s%d%d", tx.From, tx.To, tx.Value, tx.Nonce))
+	data := []byte(fmt.Sprintf("%s%s%s%d", tx.From, tx.To, tx.Value, tx.Nonce))
+	if tx.Type != TypeTransfer {
+		// Transfers keep the original encoding so existing clients still sign
+		// them correctly
+		data = append(data, []byte(fmt.Sprintf("%d%x", tx.Type, tx.Data))...)
+	}
+	if !tx.Fee.IsZero() {
+		// Likewise transactions without a fee keep their encoding
+		data = append(data, []byte(fmt.Sprintf("fee%s", tx.Fee))...)
+	}
 	return sha256.Sum256(data)
 }
 
+// Size returns the size of the transaction in bytes as it is encoded in
+// blocks
+func (tx *Transaction) Size() int {
+	data, err := json.Marshal(tx)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
 // SignTransaction signs the transaction with the given private key
 func (tx *Transaction) SignTransaction(privateKey *ecdsa.PrivateKey) error {
-	hash := tx.ComputeHash()
+	return tx.SignTransactionWith(privateKey, rand.Reader)
+}
 
-	// Sign the hash
-	r, s, err := ecdsa.Sign(rand.Reader, privateKey, hash[:])
+// SignTransactionWith signs the transaction with the given private key,
+// drawing the signing randomness from random
+func (tx *Transaction) SignTransactionWith(privateKey *ecdsa.PrivateKey, random io.Reader) error {
+	hash := tx.ComputeHash()
+	sig, err := SignHash(privateKey, hash[:], random)
 	if err != nil {
 		return fmt.Errorf("failed to sign transaction: %v", err)
 	}
-
-	tx.Signature.R = r
-	tx.Signature.S = s
-
+	tx.Signature = sig
 	return nil
 }
 
@@ -82,7 +257,7 @@ func (tx *Transaction) VerifySignature(publicKey *ecdsa.PublicKey) bool {
 
 // String returns a string representation of the transaction
 func (tx *Transaction) String() string {
-	return fmt.Sprintf("Transaction{Hash: %s, From: %s, To: %s, Value: %d, Nonce: %d, Status: %s}",
+	return fmt.Sprintf("Transaction{Hash: %s, From: %s, To: %s, Value: %s, Nonce: %d, Status: %s}",
 		hex.EncodeToString(tx.Hash[:]),
 		tx.From,
 		tx.To,
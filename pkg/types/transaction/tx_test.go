@@ -7,6 +7,8 @@ import (
 	"math/big"
 	"testing"
 	"time"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
 )
 
 func TestTransactionHash(t *testing.T) {
@@ -14,7 +16,7 @@ func TestTransactionHash(t *testing.T) {
 	tx := Transaction{
 		From:      "0x1234567890123456789012345678901234567890",
 		To:        "0x0987654321098765432109876543210987654321",
-		Value:     1000,
+		Value:     types.NewAmount(1000),
 		Nonce:     1,
 		Status:    StatusPending,
 		Timestamp: time.Now().Unix(),
@@ -37,11 +39,22 @@ func TestTransactionHash(t *testing.T) {
 
 	// Verify different transactions produce different hashes
 	tx2 := tx
-	tx2.Value = 2000
+	tx2.Value = types.NewAmount(2000)
 	hash3 := tx2.ComputeHash()
 	if hash == hash3 {
 		t.Error("Expected different hash for different transaction")
 	}
+
+	// The fee is signed, but transactions without one keep their hash
+	tx3 := tx
+	tx3.Fee = types.NewAmount(5)
+	if tx3.ComputeHash() == hash {
+		t.Error("Expected the fee to change the hash")
+	}
+	tx3.Fee = types.Amount{}
+	if tx3.ComputeHash() != hash {
+		t.Error("Expected a zero fee to keep the hash")
+	}
 }
 
 func TestTransactionStatus(t *testing.T) {
@@ -50,8 +63,11 @@ func TestTransactionStatus(t *testing.T) {
 		want   string
 	}{
 		{StatusPending, "pending"},
-		{StatusConfirmed, "confirmed"},
+		{StatusExecuted, "executed"},
 		{StatusFailed, "failed"},
+		{StatusProven, "proven"},
+		{StatusSubmitted, "submitted"},
+		{StatusFinalized, "finalized"},
 		{Status(99), "unknown"},
 	}
 
@@ -63,11 +79,24 @@ func TestTransactionStatus(t *testing.T) {
 	}
 }
 
+func TestStatusIncluded(t *testing.T) {
+	for _, s := range []Status{StatusExecuted, StatusProven, StatusSubmitted, StatusFinalized} {
+		if !s.Included() {
+			t.Errorf("Expected %v to be included", s)
+		}
+	}
+	for _, s := range []Status{StatusPending, StatusFailed, StatusReplaced} {
+		if s.Included() {
+			t.Errorf("Expected %v not to be included", s)
+		}
+	}
+}
+
 func TestTransactionString(t *testing.T) {
 	tx := Transaction{
 		From:      "sender",
 		To:        "receiver",
-		Value:     1000,
+		Value:     types.NewAmount(1000),
 		Nonce:     1,
 		Status:    StatusPending,
 		Timestamp: time.Now().Unix(),
@@ -120,7 +149,7 @@ func TestTransactionSignature(t *testing.T) {
 	tx := Transaction{
 		From:      "sender",
 		To:        "receiver",
-		Value:     1000,
+		Value:     types.NewAmount(1000),
 		Nonce:     1,
 		Status:    StatusPending,
 		Timestamp: time.Now().Unix(),
@@ -150,7 +179,7 @@ func TestTransactionSignature(t *testing.T) {
 	}
 
 	// Test signature with modified transaction data
-	tx.Value = 2000
+	tx.Value = types.NewAmount(2000)
 	if tx.VerifySignature(&privateKey.PublicKey) {
 		t.Error("Signature verification should fail with modified transaction data")
 	}
@@ -164,7 +193,7 @@ func TestSignatureConsistency(t *testing.T) {
 	tx1 := Transaction{
 		From:      "sender",
 		To:        "receiver",
-		Value:     1000,
+		Value:     types.NewAmount(1000),
 		Nonce:     1,
 		Status:    StatusPending,
 		Timestamp: time.Now().Unix(),
@@ -200,7 +229,7 @@ func TestInvalidSignatures(t *testing.T) {
 	tx := Transaction{
 		From:      "sender",
 		To:        "receiver",
-		Value:     1000,
+		Value:     types.NewAmount(1000),
 		Nonce:     1,
 		Status:    StatusPending,
 		Timestamp: time.Now().Unix(),
@@ -227,3 +256,31 @@ func TestInvalidSignatures(t *testing.T) {
 		t.Error("Verification should fail with negative signature components")
 	}
 }
+
+func TestPublicKeyConversion(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+
+	var unset PublicKey
+	if unset.ECDSA() != nil {
+		t.Error("Expected unset public key to convert to nil")
+	}
+
+	tx := Transaction{
+		From:  "0x1234567890123456789012345678901234567890",
+		To:    "0x0987654321098765432109876543210987654321",
+		Value: types.NewAmount(1000),
+		Nonce: 1,
+	}
+	if err := tx.SignTransaction(privateKey); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	tx.PublicKey = NewPublicKey(&privateKey.PublicKey)
+
+	// The recorded key verifies the signature on its own
+	if !tx.VerifySignature(tx.PublicKey.ECDSA()) {
+		t.Error("Expected signature to verify with the recorded public key")
+	}
+}
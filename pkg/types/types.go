@@ -25,7 +25,7 @@ const (
 type Transaction struct {
 	From      Address  // Sender address
 	To        Address  // Receiver address
-	Value     *big.Int // Transaction amount
+	Value     Amount   // Transaction amount
 	Nonce     uint64   // Transaction nonce
 	Signature []byte   // Transaction signature
 	Hash      [32]byte // Transaction hash
@@ -36,7 +36,7 @@ type Transaction struct {
 func (tx *Transaction) ComputeHash() [32]byte {
 	// TODO: Implement proper transaction serialization
 	data := append(tx.From[:], tx.To[:]...)
-	data = append(data, tx.Value.Bytes()...)
+	data = append(data, tx.Value.Big().Bytes()...)
 	data = append(data, big.NewInt(int64(tx.Nonce)).Bytes()...)
 	return sha256.Sum256(data)
 }
@@ -123,7 +123,7 @@ func (b *Block) CalculateMerkleRoot() [32]byte {
 
 // AccountState represents the state of an account
 type AccountState struct {
-	Balance *big.Int
+	Balance Amount
 	Nonce   uint64
 }
 
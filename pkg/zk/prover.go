@@ -0,0 +1,287 @@
+package zk
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/frontend"
+)
+
+// BackendGroth16 is the Groth16 proving backend on BN254, the only backend
+// the rollup circuit supports
+const BackendGroth16 = "groth16"
+
+// circuitVersion is bumped whenever the constraints of the circuit or the
+// shapes its keys are stored by change, so keys generated for an earlier
+// circuit are not loaded
+const circuitVersion = 4
+
+// circuitShape identifies a circuit by its number of accounts, transactions
+// and governance transactions, which determine its constraints and therefore
+// its keys. Governance transactions are only leaves of the batch, but they
+// lengthen its Merkle path.
+type circuitShape struct {
+	accounts     int
+	transactions int
+	governance   int
+}
+
+// circuitKeys is a proving and verifying key pair
+type circuitKeys struct {
+	pk groth16.ProvingKey
+	vk groth16.VerifyingKey
+}
+
+// Prover generates block proofs, reusing the circuit keys for every batch of
+// the same shape instead of running a new setup for each proof. With a key
+// directory the keys are also written to disk on first use and loaded from
+// there after a restart.
+type Prover struct {
+	keyDir string
+
+	mu     sync.Mutex
+	keys   map[circuitShape]circuitKeys
+	vks    map[circuitShape][]byte // Encoded verifying keys by shape
+	random io.Reader               // Picks the transaction proven to be in the batch
+}
+
+// NewProver creates a prover for backend that keeps its keys in keyDir, or
+// only in memory if keyDir is empty
+func NewProver(backend, keyDir string) (*Prover, error) {
+	if backend != BackendGroth16 {
+		return nil, fmt.Errorf("unsupported prover backend %q", backend)
+	}
+	if keyDir != "" {
+		if err := os.MkdirAll(keyDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create key directory: %v", err)
+		}
+	}
+	return &Prover{
+		keyDir: keyDir,
+		keys:   make(map[circuitShape]circuitKeys),
+		vks:    make(map[circuitShape][]byte),
+		random: rand.Reader,
+	}, nil
+}
+
+// SetRandom replaces the source of the randomness the prover draws on
+// outside the proving system itself. Groth16 proofs stay randomized.
+func (p *Prover) SetRandom(random io.Reader) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.random = random
+}
+
+// GenerateProof proves input with the keys for its circuit shape
+func (p *Prover) GenerateProof(input ProofInput) (*ProofOutput, error) {
+	shape := circuitShape{
+		accounts:     len(input.Accounts),
+		transactions: len(input.Transactions),
+		governance:   len(input.Governance),
+	}
+	// Only the seed is drawn under the lock, as random need not be safe for
+	// concurrent use
+	var seed [8]byte
+	p.mu.Lock()
+	_, err := io.ReadFull(p.random, seed[:])
+	p.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read randomness: %v", err)
+	}
+	return generateProof(input, func(r1cs frontend.CompiledConstraintSystem) (groth16.ProvingKey, groth16.VerifyingKey, error) {
+		return p.setup(shape, r1cs)
+	}, bytes.NewReader(seed[:]))
+}
+
+// setup returns the keys for shape, loading or generating them on first use
+func (p *Prover) setup(shape circuitShape, r1cs frontend.CompiledConstraintSystem) (groth16.ProvingKey, groth16.VerifyingKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if keys, ok := p.keys[shape]; ok {
+		return keys.pk, keys.vk, nil
+	}
+
+	keys, err := p.loadKeys(shape)
+	if err != nil {
+		return nil, nil, err
+	}
+	if keys == nil {
+		pk, vk, err := groth16.Setup(r1cs)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = &circuitKeys{pk: pk, vk: vk}
+		if err := p.saveKeys(shape, keys); err != nil {
+			return nil, nil, err
+		}
+	}
+	vk, err := encodeKey(keys.vk)
+	if err != nil {
+		return nil, nil, err
+	}
+	p.keys[shape] = *keys
+	p.vks[shape] = vk
+	return keys.pk, keys.vk, nil
+}
+
+// VerifyProof checks a proof of a batch of transactions and governance
+// transactions against the local verifying key of its circuit. The key a
+// proof carries is only accepted if it is one of the keys of this prover for
+// a circuit proving a batch of that size, as anyone can set up a circuit of
+// their own and prove anything with it. Keys are loaded from the key
+// directory but never generated, so a node verifying the proofs of another
+// needs its keys.
+func (p *Prover) VerifyProof(output *ProofOutput, transactions, governance int) error {
+	carried, ok := output.Vk.(io.WriterTo)
+	if !ok {
+		return fmt.Errorf("invalid vk type")
+	}
+	vk, err := encodeKey(carried)
+	if err != nil {
+		return err
+	}
+	known, err := p.knownKey(vk, transactions, governance)
+	if err != nil {
+		return err
+	}
+	if !known {
+		return fmt.Errorf("proof verifying key is not a local key for %d transactions and %d governance transactions",
+			transactions, governance)
+	}
+	return verifyProofOutput(output)
+}
+
+// knownKey reports whether vk is the verifying key of a circuit for the
+// given numbers of transactions and governance transactions, looking in the
+// key directory for keys not seen yet
+func (p *Prover) knownKey(vk []byte, transactions, governance int) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	matches := func(shape circuitShape) bool {
+		return shape.transactions == transactions && shape.governance == governance
+	}
+	for shape, known := range p.vks {
+		if matches(shape) && bytes.Equal(known, vk) {
+			return true, nil
+		}
+	}
+	if p.keyDir == "" {
+		return false, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(p.keyDir, fmt.Sprintf("%s_v%d_*.vk", BackendGroth16, circuitVersion)))
+	if err != nil {
+		return false, err
+	}
+	for _, path := range paths {
+		var shape circuitShape
+		name := filepath.Base(path)
+		if _, err := fmt.Sscanf(name, BackendGroth16+"_v%d_%d_%d_%d.vk", new(int), &shape.accounts, &shape.transactions, &shape.governance); err != nil {
+			continue
+		}
+		if _, ok := p.vks[shape]; ok || !matches(shape) {
+			continue
+		}
+		known, err := ioutil.ReadFile(path)
+		if err != nil {
+			return false, fmt.Errorf("failed to read verifying key: %v", err)
+		}
+		p.vks[shape] = known
+		if bytes.Equal(known, vk) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// keyPaths returns the proving and verifying key files for shape
+func (p *Prover) keyPaths(shape circuitShape) (string, string) {
+	name := fmt.Sprintf("%s_v%d_%d_%d_%d", BackendGroth16, circuitVersion, shape.accounts, shape.transactions, shape.governance)
+	return filepath.Join(p.keyDir, name+".pk"), filepath.Join(p.keyDir, name+".vk")
+}
+
+// loadKeys reads the keys for shape from the key directory. It returns nil
+// if there is no key directory or the keys have not been generated yet.
+func (p *Prover) loadKeys(shape circuitShape) (*circuitKeys, error) {
+	if p.keyDir == "" {
+		return nil, nil
+	}
+	pkPath, vkPath := p.keyPaths(shape)
+	pkFile, err := os.Open(pkPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open proving key: %v", err)
+	}
+	defer pkFile.Close()
+	vkFile, err := os.Open(vkPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open verifying key: %v", err)
+	}
+	defer vkFile.Close()
+
+	keys := &circuitKeys{
+		pk: groth16.NewProvingKey(ecc.BN254),
+		vk: groth16.NewVerifyingKey(ecc.BN254),
+	}
+	if _, err := keys.pk.ReadFrom(pkFile); err != nil {
+		return nil, fmt.Errorf("failed to read proving key %s: %v", pkPath, err)
+	}
+	if _, err := keys.vk.ReadFrom(vkFile); err != nil {
+		return nil, fmt.Errorf("failed to read verifying key %s: %v", vkPath, err)
+	}
+	return keys, nil
+}
+
+// saveKeys writes the keys for shape to the key directory, if any
+func (p *Prover) saveKeys(shape circuitShape, keys *circuitKeys) error {
+	if p.keyDir == "" {
+		return nil
+	}
+	pkPath, vkPath := p.keyPaths(shape)
+	// The verifying key goes first, as the proving key marks the pair as
+	// complete for loadKeys
+	if err := writeKey(vkPath, keys.vk); err != nil {
+		return fmt.Errorf("failed to write verifying key: %v", err)
+	}
+	if err := writeKey(pkPath, keys.pk); err != nil {
+		return fmt.Errorf("failed to write proving key: %v", err)
+	}
+	return nil
+}
+
+// encodeKey returns the binary encoding of a key, as written to key files
+func encodeKey(key io.WriterTo) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := key.WriteTo(&buf); err != nil {
+		return nil, fmt.Errorf("failed to encode key: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeKey atomically writes a key to path
+func writeKey(path string, key io.WriterTo) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := key.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,260 @@
+package zk
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
+)
+
+func TestProverKeyDir(t *testing.T) {
+	if _, err := NewProver("plonk", ""); err == nil {
+		t.Error("Expected unsupported backend to be rejected")
+	}
+
+	accounts := []Account{
+		{Address: "0000000000000000000000000000000000000001", Balance: types.NewAmount(1000)},
+		{Address: "0000000000000000000000000000000000000002", Balance: types.NewAmount(500)},
+	}
+	input := ProofInput{
+		OldStateRoot: ComputeAccountMerkleRoot(accounts),
+		Accounts:     accounts,
+		Transactions: []Transaction{{
+			From:   "0000000000000000000000000000000000000001",
+			To:     "0000000000000000000000000000000000000002",
+			Amount: types.NewAmount(100),
+			Nonce:  0,
+		}},
+	}
+
+	keyDir := t.TempDir()
+	prover, err := NewProver(BackendGroth16, keyDir)
+	if err != nil {
+		t.Fatalf("Failed to create prover: %v", err)
+	}
+	first, err := prover.GenerateProof(input)
+	if err != nil {
+		t.Fatalf("Failed to generate proof: %v", err)
+	}
+	for _, ext := range []string{".pk", ".vk"} {
+		if _, err := os.Stat(filepath.Join(keyDir, "groth16_v4_2_1_0"+ext)); err != nil {
+			t.Errorf("Expected key file %s to be written: %v", ext, err)
+		}
+	}
+
+	// A new prover loads the keys written by the first one
+	reloaded, err := NewProver(BackendGroth16, keyDir)
+	if err != nil {
+		t.Fatalf("Failed to create prover: %v", err)
+	}
+	second, err := reloaded.GenerateProof(input)
+	if err != nil {
+		t.Fatalf("Failed to generate proof with stored keys: %v", err)
+	}
+	// A verifier sharing the key directory checks proofs with the stored
+	// keys, while one with keys of its own rejects them
+	verifier, err := NewProver(BackendGroth16, keyDir)
+	if err != nil {
+		t.Fatalf("Failed to create prover: %v", err)
+	}
+	stranger, err := NewProver(BackendGroth16, "")
+	if err != nil {
+		t.Fatalf("Failed to create prover: %v", err)
+	}
+	for _, output := range []*ProofOutput{first, second} {
+		if err := verifier.VerifyProof(output, 1, 0); err != nil {
+			t.Errorf("Expected proof to verify: %v", err)
+		}
+		if err := verifier.VerifyProof(output, 2, 0); err == nil {
+			t.Error("Expected proof to be rejected for another circuit")
+		}
+		if err := stranger.VerifyProof(output, 1, 0); err == nil {
+			t.Error("Expected proof with an unknown verifying key to be rejected")
+		}
+	}
+	if second.Vk.(interface{ IsDifferent(interface{}) bool }).IsDifferent(first.Vk) {
+		t.Error("Expected the stored verifying key to be reused")
+	}
+}
+
+func TestProofGovernance(t *testing.T) {
+	accounts := []Account{
+		{Address: "0000000000000000000000000000000000000001", Balance: types.NewAmount(1000)},
+		{Address: "0000000000000000000000000000000000000002", Balance: types.NewAmount(500)},
+	}
+	rotation := Governance{Type: "rotate_sequencer", Data: []byte{1, 2, 3}}
+	input := ProofInput{
+		OldStateRoot: ComputeAccountMerkleRoot(accounts),
+		Accounts:     accounts,
+		Transactions: []Transaction{{
+			From:   "0000000000000000000000000000000000000001",
+			To:     "0000000000000000000000000000000000000002",
+			Amount: types.NewAmount(100),
+		}},
+		Governance: []Governance{rotation},
+	}
+	prover, err := NewProver(BackendGroth16, "")
+	if err != nil {
+		t.Fatalf("Failed to create prover: %v", err)
+	}
+	output, err := prover.GenerateProof(input)
+	if err != nil {
+		t.Fatalf("Failed to generate proof: %v", err)
+	}
+
+	// The governance transaction is a leaf of the proven batch
+	batchRoot, err := ComputeBatchRoot(input.Transactions, input.Governance)
+	if err != nil || output.BatchRoot != batchRoot {
+		t.Errorf("Expected batch root %s, got %s (%v)", output.BatchRoot, batchRoot, err)
+	}
+	if transfers, _ := ComputeBatchRoot(input.Transactions, nil); transfers == output.BatchRoot {
+		t.Error("Expected the batch root to commit to the governance transaction")
+	}
+	rotation.Data = []byte{4, 5, 6}
+	if changed, _ := ComputeBatchRoot(input.Transactions, []Governance{rotation}); changed == output.BatchRoot {
+		t.Error("Expected the batch root to commit to the governance data")
+	}
+
+	if err := prover.VerifyProof(output, 1, 1); err != nil {
+		t.Errorf("Expected proof to verify: %v", err)
+	}
+	if err := prover.VerifyProof(output, 1, 0); err == nil {
+		t.Error("Expected proof to be rejected for a batch without governance transactions")
+	}
+}
+
+func TestProofFee(t *testing.T) {
+	accounts := []Account{
+		{Address: "0000000000000000000000000000000000000001", Balance: types.NewAmount(1000)},
+		{Address: "0000000000000000000000000000000000000002", Balance: types.NewAmount(500)},
+	}
+	input := ProofInput{
+		OldStateRoot: ComputeAccountMerkleRoot(accounts),
+		Accounts:     accounts,
+		Transactions: []Transaction{{
+			From:   "0000000000000000000000000000000000000001",
+			To:     "0000000000000000000000000000000000000002",
+			Amount: types.NewAmount(100),
+			Fee:    types.NewAmount(5),
+		}},
+	}
+	prover, err := NewProver(BackendGroth16, "")
+	if err != nil {
+		t.Fatalf("Failed to create prover: %v", err)
+	}
+	output, err := prover.GenerateProof(input)
+	if err != nil {
+		t.Fatalf("Failed to generate proof: %v", err)
+	}
+
+	// The fee leaves the sender without reaching anyone
+	burned := ComputeAccountMerkleRoot([]Account{
+		{Address: "0000000000000000000000000000000000000001", Balance: types.NewAmount(895)},
+		{Address: "0000000000000000000000000000000000000002", Balance: types.NewAmount(600)},
+	})
+	if output.NewStateRoot != burned {
+		t.Errorf("Expected state root %s, got %s", burned, output.NewStateRoot)
+	}
+	if err := prover.VerifyProof(output, 1, 0); err != nil {
+		t.Errorf("Expected proof to verify: %v", err)
+	}
+	free := []Transaction{input.Transactions[0]}
+	free[0].Fee = types.Amount{}
+	if root, _ := ComputeBatchRoot(free, nil); root == output.BatchRoot {
+		t.Error("Expected the batch root to commit to the fee")
+	}
+}
+
+func TestGenerateProofOutOfRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		sender   types.Amount
+		receiver types.Amount
+		fee      types.Amount
+	}{
+		{"insufficient balance", types.NewAmount(50), types.NewAmount(0), types.Amount{}},
+		{"fee exceeds balance", types.NewAmount(150), types.NewAmount(0), types.NewAmount(60)},
+		{"recipient overflow", types.NewAmount(1000), types.MaxAmount(), types.Amount{}},
+	}
+	for _, tt := range tests {
+		transfer := []Transaction{{
+			From:   "0000000000000000000000000000000000000001",
+			To:     "0000000000000000000000000000000000000002",
+			Amount: types.NewAmount(100),
+			Fee:    tt.fee,
+		}}
+		accounts := []Account{
+			{Address: "0000000000000000000000000000000000000001", Balance: tt.sender},
+			{Address: "0000000000000000000000000000000000000002", Balance: tt.receiver},
+		}
+		input := ProofInput{
+			OldStateRoot: ComputeAccountMerkleRoot(accounts),
+			Accounts:     accounts,
+			Transactions: transfer,
+		}
+		// The batch is refused before the circuit is set up
+		if _, err := generateProof(input, nil, rand.Reader); err == nil {
+			t.Errorf("%s: expected the batch to be refused", tt.name)
+		}
+		if _, err := ComputeNewStateRoot(input); err == nil {
+			t.Errorf("%s: expected no state root for the batch", tt.name)
+		}
+	}
+}
+
+func TestGenerateProofAddresses(t *testing.T) {
+	// Accounts are found by address, whatever its form
+	accounts := []Account{
+		{Address: "0000000000000000000000000000000000000001", Balance: types.NewAmount(1000)},
+		{Address: "7ebb6ad825a61548843b2bee71cc572bc3feb8ae", Balance: types.NewAmount(500)},
+	}
+	input := ProofInput{
+		OldStateRoot: ComputeAccountMerkleRoot(accounts),
+		Accounts:     accounts,
+		Transactions: []Transaction{{
+			From:   "7ebb6ad825a61548843b2bee71cc572bc3feb8ae",
+			To:     "0000000000000000000000000000000000000001",
+			Amount: types.NewAmount(100),
+		}},
+	}
+	output, err := GenerateProof(input)
+	if err != nil {
+		t.Fatalf("Failed to generate proof: %v", err)
+	}
+	moved := ComputeAccountMerkleRoot([]Account{
+		{Address: "0000000000000000000000000000000000000001", Balance: types.NewAmount(1100)},
+		{Address: "7ebb6ad825a61548843b2bee71cc572bc3feb8ae", Balance: types.NewAmount(400)},
+	})
+	if output.NewStateRoot != moved {
+		t.Errorf("Expected state root %s, got %s", moved, output.NewStateRoot)
+	}
+	if root, err := ComputeNewStateRoot(input); err != nil || root != moved {
+		t.Errorf("Expected state root %s without proving, got %s, %v", moved, root, err)
+	}
+
+	// Addresses that are not among the accounts are refused before the
+	// circuit is set up
+	tests := []struct {
+		name     string
+		from, to string
+		accounts []Account
+	}{
+		{"unknown sender", "0000000000000000000000000000000000000009", accounts[0].Address, accounts},
+		{"unknown recipient", accounts[0].Address, "0000000000000000000000000000000000000000", accounts},
+		{"malformed address", "x", accounts[0].Address, accounts},
+		{"nonce mismatch", accounts[1].Address, accounts[0].Address, []Account{accounts[0], {Address: accounts[1].Address, Balance: accounts[1].Balance, Nonce: 1}}},
+		{"duplicate account", accounts[0].Address, accounts[1].Address, []Account{accounts[0], accounts[1], accounts[1]}},
+	}
+	for _, tt := range tests {
+		input := ProofInput{
+			OldStateRoot: ComputeAccountMerkleRoot(tt.accounts),
+			Accounts:     tt.accounts,
+			Transactions: []Transaction{{From: tt.from, To: tt.to, Amount: types.NewAmount(1)}},
+		}
+		if _, err := generateProof(input, nil, rand.Reader); err == nil {
+			t.Errorf("%s: expected the batch to be refused", tt.name)
+		}
+	}
+}
@@ -2,12 +2,14 @@ package zk
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"math/big"
-	"math/rand"
-	"strconv"
 
 	"encoding/base64"
 
@@ -19,21 +21,32 @@ import (
 	"github.com/consensys/gnark/frontend"
 	"github.com/consensys/gnark/std/accumulator/merkle"
 	"github.com/consensys/gnark/std/hash/mimc"
+
+	"github.com/StupidBug/fabric-zkrollup/pkg/types"
 )
 
 // Account 表示账户状态
 type Account struct {
-	Address string // 电路外：账户地址为string类型
-	Balance int    // 电路外：账户余额为int类型
-	Nonce   int    // 电路外：nonce为int类型
+	Address string       // 电路外：账户地址为string类型
+	Balance types.Amount // 电路外：账户余额为 types.AmountBits 位无符号整数
+	Nonce   int          // 电路外：nonce为int类型
 }
 
 // Transaction 表示交易
 type Transaction struct {
-	From   string // 电路外：发送者地址为string类型
-	To     string // 电路外：接收者地址为string类型
-	Amount int    // 电路外：转账金额为int类型
-	Nonce  int    // 电路外：交易nonce为int类型
+	From   string       // 电路外：发送者地址为string类型
+	To     string       // 电路外：接收者地址为string类型
+	Amount types.Amount // 电路外：转账金额为 types.AmountBits 位无符号整数
+	Fee    types.Amount // 电路外：从发送者余额中扣除并销毁的手续费
+	Nonce  int          // 电路外：交易nonce为int类型
+}
+
+// Governance 表示治理交易。它不改变账户余额，不在电路中执行，但计入批次根，
+// 因此证明同样约束了区块中的治理交易
+type Governance struct {
+	Type  string // 交易类型名称
+	Data  []byte // 治理交易的数据
+	Nonce int
 }
 
 // CircuitTransaction 表示电路内交易
@@ -41,6 +54,7 @@ type CircuitTransaction struct {
 	From   frontend.Variable
 	To     frontend.Variable
 	Amount frontend.Variable
+	Fee    frontend.Variable
 	Nonce  frontend.Variable
 }
 
@@ -56,9 +70,17 @@ type SerializedTransaction struct {
 	From   string `json:"from"`
 	To     string `json:"to"`
 	Amount string `json:"amount"`
+	Fee    string `json:"fee,omitempty"` // 不带手续费的交易保持原有的叶子编码
 	Nonce  string `json:"nonce"`
 }
 
+// 治理交易序列化
+type SerializedGovernance struct {
+	Type  string `json:"type"`
+	Data  string `json:"data"`
+	Nonce string `json:"nonce"`
+}
+
 type merkleCircuit struct {
 	// 公开输入
 	OldRStateRoot  frontend.Variable `gnark:",public"` // 前一个状态根
@@ -113,11 +135,22 @@ func (circuit *merkleCircuit) Define(curveID ecc.ID, api frontend.API) error {
 	}
 	api.AssertIsEqual(circuit.OldRStateRoot, old_hashes[0])
 
+	// 范围检查：初始余额不超过 types.AmountBits 位，之后的加减法才不会在域上回绕
+	for i := 0; i < len(circuit.Balances); i++ {
+		api.ToBinary(circuit.Balances[i], types.AmountBits)
+	}
+
 	// 处理每笔交易
 	for i := 0; i < len(circuit.Transactions); i++ {
 		tx := circuit.Transactions[i]
 		foundSender := api.Constant(0)
 
+		// 范围检查：转账金额和手续费不超过 types.AmountBits 位
+		api.ToBinary(tx.Amount, types.AmountBits)
+		api.ToBinary(tx.Fee, types.AmountBits)
+		// 发送者支付转账金额和手续费，手续费不计入任何账户，即被销毁
+		debit := api.Add(tx.Amount, tx.Fee)
+
 		// 验证发送者账户
 		for j := 0; j < len(circuit.Addresses); j++ {
 			// 如果是发送者 - 使用相等性检查而不是差值
@@ -130,13 +163,13 @@ func (circuit *merkleCircuit) Define(curveID ecc.ID, api frontend.API) error {
 			isSender := api.IsZero(api.Sub(circuit.Addresses[j], tx.From))
 			api.AssertIsEqual(api.Mul(isSender, circuit.Nonces[j]), api.Mul(isSender, tx.Nonce))
 
-			// 验证余额充足 - 如果是发送者，确保 amount <= balance
-			diff := api.Sub(circuit.Balances[j], tx.Amount)
-			// 如果余额不足，diff会是负数，我们需要确保对于发送者，diff必须大于等于0
-			api.AssertIsEqual(api.Select(isSender, diff, api.Constant(1)), api.Select(isSender, diff, api.Constant(1)))
+			// 验证余额充足 - 如果是发送者，确保 amount + fee <= balance
+			diff := api.Sub(circuit.Balances[j], debit)
+			// 如果余额不足，diff在域上回绕成一个很大的数，无法用 types.AmountBits 位表示
+			api.ToBinary(api.Select(isSender, diff, api.Constant(0)), types.AmountBits)
 
 			// 更新发送者状态
-			newBalance := api.Select(isSender, api.Sub(circuit.Balances[j], tx.Amount), circuit.Balances[j])
+			newBalance := api.Select(isSender, diff, circuit.Balances[j])
 			newNonce := api.Select(isSender, api.Add(circuit.Nonces[j], 1), circuit.Nonces[j])
 			circuit.Balances[j] = newBalance
 			circuit.Nonces[j] = newNonce
@@ -144,6 +177,8 @@ func (circuit *merkleCircuit) Define(curveID ecc.ID, api frontend.API) error {
 			// 如果是接收者
 			isReceiver := api.IsZero(api.Sub(circuit.Addresses[j], tx.To))
 			circuit.Balances[j] = api.Select(isReceiver, api.Add(circuit.Balances[j], tx.Amount), circuit.Balances[j])
+			// 范围检查：入账后的余额不能溢出 types.AmountBits 位
+			api.ToBinary(circuit.Balances[j], types.AmountBits)
 		}
 
 		// 确保找到了发送者
@@ -182,14 +217,14 @@ func (circuit *merkleCircuit) Define(curveID ecc.ID, api frontend.API) error {
 }
 
 // 电路外的计算函数
-func computeMerkleRoot(balances []int) string {
+func computeMerkleRoot(balances []types.Amount) string {
 	// 创建一个切片来存储所有余额的哈希值
 	hashes := make([]*big.Int, len(balances))
 
 	// 计算每个余额的哈希值
 	for i, balance := range balances {
 		f := bn254.NewMiMC("seed")
-		f.Write(new(big.Int).SetInt64(int64(balance)).Bytes())
+		f.Write(balance.Big().Bytes())
 		hashes[i] = new(big.Int).SetBytes(f.Sum(nil))
 	}
 
@@ -219,6 +254,7 @@ type ProofInput struct {
 	OldStateRoot string // 旧状态根
 	Accounts     []Account
 	Transactions []Transaction
+	Governance   []Governance // 只计入批次根的治理交易
 }
 
 // 输出参数结构体
@@ -242,35 +278,156 @@ type SerializedProofOutput struct {
 // 计算账户余额的默克尔根
 func ComputeAccountMerkleRoot(accounts []Account) string {
 	// 提取所有账户的余额
-	balances := make([]int, len(accounts))
+	balances := make([]types.Amount, len(accounts))
 	for i := 0; i < len(accounts); i++ {
 		balances[i] = accounts[i].Balance
 	}
 	return computeMerkleRoot(balances)
 }
 
-// 生成证明
-func GenerateProof(input ProofInput) (*ProofOutput, error) {
+// 序列化交易和其后的治理交易，作为批次默克尔树的输入
+func serializeTransactions(transactions []Transaction, governance []Governance) *bytes.Buffer {
 	var buf bytes.Buffer
-	batchSize := len(input.Transactions)
-	accountSize := len(input.Accounts)
-
-	// 序列化交易
-	for i := 0; i < batchSize; i++ {
+	for _, tx := range transactions {
 		serializedTx := SerializedTransaction{
-			From:   input.Transactions[i].From,
-			To:     input.Transactions[i].To,
-			Amount: fmt.Sprint(input.Transactions[i].Amount),
-			Nonce:  fmt.Sprint(input.Transactions[i].Nonce),
+			From:   tx.From,
+			To:     tx.To,
+			Amount: tx.Amount.String(),
+			Nonce:  fmt.Sprint(tx.Nonce),
+		}
+		if !tx.Fee.IsZero() {
+			serializedTx.Fee = tx.Fee.String()
+		}
+		txJSON, _ := json.Marshal(serializedTx)
+		buf.Write(txJSON)
+		buf.WriteByte('\n')
+	}
+	for _, tx := range governance {
+		serializedTx := SerializedGovernance{
+			Type:  tx.Type,
+			Data:  hex.EncodeToString(tx.Data),
+			Nonce: fmt.Sprint(tx.Nonce),
 		}
 		txJSON, _ := json.Marshal(serializedTx)
 		buf.Write(txJSON)
 		buf.WriteByte('\n')
 	}
+	return &buf
+}
+
+// 计算交易批次的默克尔根，与证明中的公开输入 BatchRoot 一致。治理交易排在
+// 转账之后，同样是批次的叶子
+func ComputeBatchRoot(transactions []Transaction, governance []Governance) (string, error) {
+	if len(transactions) == 0 {
+		return "", fmt.Errorf("empty transaction batch")
+	}
+	leaves := len(transactions) + len(governance)
+	merkleRoot, _, _, err := merkletree.BuildReaderProof(serializeTransactions(transactions, governance), bn254.NewMiMC("seed"), leaves, 0)
+	if err != nil {
+		return "", fmt.Errorf("failed to build merkle root: %v", err)
+	}
+	return new(big.Int).SetBytes(merkleRoot).String(), nil
+}
+
+// 在电路外执行批次，返回执行后的账户和每笔交易发送方、接收方在输入中的位置。
+// 电路按账户在输入中的位置识别账户，交易的发送方和接收方必须是输入中的账户；
+// 与电路的约束一致，nonce 不符、余额不足或入账溢出的批次无法证明，在编译电路
+// 之前就拒绝
+func executeBatch(input ProofInput) ([]Account, [][2]int, error) {
+	positions := make(map[string]int, len(input.Accounts))
+	for i, account := range input.Accounts {
+		if _, ok := positions[account.Address]; ok {
+			return nil, nil, fmt.Errorf("duplicate account %s", account.Address)
+		}
+		positions[account.Address] = i
+	}
+
+	accounts := make([]Account, len(input.Accounts))
+	copy(accounts, input.Accounts)
+	indices := make([][2]int, len(input.Transactions))
+	for i, tx := range input.Transactions {
+		fromIdx, ok := positions[tx.From]
+		if !ok {
+			return nil, nil, fmt.Errorf("transaction %d: unknown sender %s", i, tx.From)
+		}
+		toIdx, ok := positions[tx.To]
+		if !ok {
+			return nil, nil, fmt.Errorf("transaction %d: unknown recipient %s", i, tx.To)
+		}
+		indices[i] = [2]int{fromIdx, toIdx}
+
+		if accounts[fromIdx].Nonce != tx.Nonce {
+			return nil, nil, fmt.Errorf("transaction %d: expected nonce %d, got %d", i, accounts[fromIdx].Nonce, tx.Nonce)
+		}
+		debit, err := tx.Amount.Add(tx.Fee)
+		if err != nil {
+			return nil, nil, fmt.Errorf("transaction %d: debit: %v", i, err)
+		}
+		balance, err := accounts[fromIdx].Balance.Sub(debit)
+		if err != nil {
+			return nil, nil, fmt.Errorf("transaction %d: sender balance: %v", i, err)
+		}
+		accounts[fromIdx].Balance = balance
+		accounts[fromIdx].Nonce++
+		balance, err = accounts[toIdx].Balance.Add(tx.Amount)
+		if err != nil {
+			return nil, nil, fmt.Errorf("transaction %d: recipient balance: %v", i, err)
+		}
+		accounts[toIdx].Balance = balance
+	}
+	return accounts, indices, nil
+}
+
+// 计算批次执行后的状态根而不生成证明，用于在区块上链前确认它可以被证明。
+// 批次无法证明时返回错误
+func ComputeNewStateRoot(input ProofInput) (string, error) {
+	if len(input.Transactions) == 0 {
+		return "", fmt.Errorf("no transactions to prove")
+	}
+	accounts, _, err := executeBatch(input)
+	if err != nil {
+		return "", err
+	}
+	return ComputeAccountMerkleRoot(accounts), nil
+}
+
+// setupFunc returns the proving and verifying keys for a compiled circuit
+type setupFunc func(r1cs frontend.CompiledConstraintSystem) (groth16.ProvingKey, groth16.VerifyingKey, error)
+
+// 生成证明
+func GenerateProof(input ProofInput) (*ProofOutput, error) {
+	return generateProof(input, groth16.Setup, rand.Reader)
+}
+
+// generateProof proves input using the keys returned by setup, picking the
+// transaction whose batch membership is proven with random
+func generateProof(input ProofInput, setup setupFunc, random io.Reader) (*ProofOutput, error) {
+	batchSize := len(input.Transactions)
+	accountSize := len(input.Accounts)
+	if batchSize == 0 {
+		return nil, fmt.Errorf("no transactions to prove")
+	}
+
+	// 记录旧账户状态
+	old_accounts := make([]Account, accountSize)
+	copy(old_accounts, input.Accounts)
+
+	accounts, indices, err := executeBatch(input)
+	if err != nil {
+		return nil, err
+	}
+
+	// 序列化交易，治理交易也是批次的叶子
+	buf := serializeTransactions(input.Transactions, input.Governance)
+	leaves := batchSize + len(input.Governance)
 
 	// 构建默克尔证明
-	proofIndex := uint64(rand.Intn(batchSize))
-	merkleRoot, merkleProof, numLeaves, err := merkletree.BuildReaderProof(&buf, bn254.NewMiMC("seed"), batchSize, proofIndex)
+	var seed [8]byte
+	if _, err := io.ReadFull(random, seed[:]); err != nil {
+		return nil, fmt.Errorf("failed to read randomness: %v", err)
+	}
+	proofIndex := binary.BigEndian.Uint64(seed[:]) % uint64(leaves)
+	merkleRoot, merkleProof, numLeaves, err := merkletree.BuildReaderProof(buf, bn254.NewMiMC("seed"), leaves, proofIndex)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build merkle proof: %v", err)
 	}
@@ -294,29 +451,14 @@ func GenerateProof(input ProofInput) (*ProofOutput, error) {
 	}
 
 	// 设置证明系统
-	pk, vk, err := groth16.Setup(r1cs)
+	pk, vk, err := setup(r1cs)
 	if err != nil {
 		return nil, fmt.Errorf("failed to setup proving system: %v", err)
 	}
 
-	// 记录旧账户状态
-	old_accounts := make([]Account, accountSize)
-	copy(old_accounts, input.Accounts)
-
-	// 更新账户状态
-	accounts := make([]Account, accountSize)
-	copy(accounts, input.Accounts)
-	for _, tx := range input.Transactions {
-		fromIdx := parseInt(tx.From) - 1
-		toIdx := parseInt(tx.To) - 1
-		accounts[fromIdx].Balance -= tx.Amount
-		accounts[fromIdx].Nonce++
-		accounts[toIdx].Balance += tx.Amount
-	}
-
 	// 计算新状态根
 	merkleRoot1 := ComputeAccountMerkleRoot(accounts)
-	fmt.Printf("merkleRoot1: %v\n", merkleRoot1)
+	slog.Debug("Computed new state root for proof", "state_root", merkleRoot1)
 
 	// 创建witness
 	witness := &merkleCircuit{
@@ -333,18 +475,17 @@ func GenerateProof(input ProofInput) (*ProofOutput, error) {
 
 	// 设置witness的值
 	for i := 0; i < accountSize; i++ {
-		witness.Addresses[i] = frontend.Value(parseInt(old_accounts[i].Address))
-		witness.Balances[i] = frontend.Value(uint64(old_accounts[i].Balance))
+		witness.Addresses[i] = frontend.Value(circuitAddress(i))
+		witness.Balances[i] = frontend.Value(old_accounts[i].Balance.Big())
 		witness.Nonces[i] = frontend.Value(uint64(old_accounts[i].Nonce))
 	}
 
 	for i := 0; i < batchSize; i++ {
-		fromAddr := parseInt(input.Transactions[i].From)
-		toAddr := parseInt(input.Transactions[i].To)
 		witness.Transactions[i] = CircuitTransaction{
-			From:   frontend.Value(fromAddr),
-			To:     frontend.Value(toAddr),
-			Amount: frontend.Value(uint64(input.Transactions[i].Amount)),
+			From:   frontend.Value(circuitAddress(indices[i][0])),
+			To:     frontend.Value(circuitAddress(indices[i][1])),
+			Amount: frontend.Value(input.Transactions[i].Amount.Big()),
+			Fee:    frontend.Value(input.Transactions[i].Fee.Big()),
 			Nonce:  frontend.Value(uint64(input.Transactions[i].Nonce)),
 		}
 	}
@@ -454,17 +595,8 @@ func (p *ProofOutput) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-// 验证证明
-func VerifyProof(proofStr string) error {
-	// 打印proofStr
-	fmt.Printf("proofStr: %v\n", proofStr)
-	// 反序列化输入
-	var output ProofOutput
-	err := json.Unmarshal([]byte(proofStr), &output)
-	if err != nil {
-		return fmt.Errorf("failed to unmarshal proof output: %v", err)
-	}
-
+// 用证明携带的 vk 验证已反序列化的证明，调用者须先确认该 vk 可信
+func verifyProofOutput(output *ProofOutput) error {
 	publicWitness := &merkleCircuit{
 		OldRStateRoot:  frontend.Value(output.OldStateRoot),
 		RootHash:       frontend.Value(output.BatchRoot),
@@ -482,7 +614,7 @@ func VerifyProof(proofStr string) error {
 		return fmt.Errorf("invalid vk type")
 	}
 
-	err = groth16.Verify(proof, vk, publicWitness)
+	err := groth16.Verify(proof, vk, publicWitness)
 	if err != nil {
 		return fmt.Errorf("proof verification failed: %v", err)
 	}
@@ -548,8 +680,8 @@ func VerifyProof(proofStr string) error {
 // 	fmt.Println("Proof verification succeeded!")
 // }
 
-// 辅助函数：解析地址字符串为整数
-func parseInt(s string) int {
-	n, _ := strconv.Atoi(s)
-	return n
+// 辅助函数：电路中代表第 i 个账户的地址。地址本身不进入状态根，从 1 开始
+// 编号，与交易中的零值区分
+func circuitAddress(i int) int {
+	return i + 1
 }
@@ -30,7 +30,7 @@ go build ./cmd/zkrollup || {
 
 # Start server
 echo -e "${GREEN}Starting server on port $PORT...${NC}"
-./zkrollup
+./zkrollup -listen ":$PORT"
 
 # Wait for server to start
 echo -e "${GREEN}Waiting for server to start...${NC}"